@@ -0,0 +1,65 @@
+// Command exploit runs every scenario's attack against both variants of a
+// running shop and reports which ones it got through. It exits non-zero if
+// any secure variant was exploited or an attack could not be run, so it can
+// gate a deployment in CI.
+//
+// The attacks place orders and buy up stock; run them against a throwaway
+// instance, not a demo someone is using.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"secure-webapp/exploit"
+	"secure-webapp/scenario"
+	"text/tabwriter"
+)
+
+func main() {
+	target := flag.String("target", "http://localhost:8080", "base URL of the shop to attack")
+	only := flag.String("scenario", "", "run only the attack for this scenario, e.g. \"Price Manipulation\"")
+	flag.Parse()
+
+	attacks := exploit.Attacks
+	if *only != "" {
+		attacks = nil
+		for _, attack := range exploit.Attacks {
+			if attack.Scenario == *only {
+				attacks = append(attacks, attack)
+			}
+		}
+		if len(attacks) == 0 {
+			fmt.Fprintf(os.Stderr, "no attack for scenario %q\n", *only)
+			os.Exit(2)
+		}
+	}
+
+	results := exploit.Run(*target, attacks)
+
+	failed := false
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "SCENARIO\tVARIANT\tOUTCOME\tEVIDENCE")
+	for _, r := range results {
+		outcome, evidence := r.Finding.Outcome.String(), r.Finding.Evidence
+		switch {
+		case r.Err != nil:
+			outcome, evidence = "error", r.Err.Error()
+			failed = true
+		case r.Variant == scenario.Secure && r.Finding.Outcome == exploit.Exploited:
+			outcome = "EXPLOITED"
+			failed = true
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", r.Attack.Scenario, r.Variant, outcome, evidence)
+	}
+	tw.Flush()
+
+	for _, r := range results {
+		if r.Err == nil && r.Variant == scenario.Vulnerable && r.Finding.Outcome == exploit.Blocked {
+			fmt.Fprintf(os.Stderr, "warning: the %s attack no longer works on the vulnerable variant\n", r.Attack.Scenario)
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
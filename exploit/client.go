@@ -0,0 +1,148 @@
+package exploit
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+var csrfFieldPattern = regexp.MustCompile(`name="csrf_token" value="([^"]*)"`)
+
+// Client is one visitor's browser: it keeps cookies, follows redirects and,
+// like a real page would, sends back the CSRF token of the last page it
+// loaded with every same-origin form post
+type Client struct {
+	base *url.URL
+	http *http.Client
+
+	mu   sync.Mutex
+	csrf string
+}
+
+// Response is a request's outcome after redirects were followed
+type Response struct {
+	Status int
+	URL    *url.URL
+	Body   string
+}
+
+func NewClient(target string) (*Client, error) {
+	base, err := url.Parse(strings.TrimSuffix(target, "/"))
+	if err != nil {
+		return nil, err
+	}
+	if base.Scheme == "" || base.Host == "" {
+		return nil, fmt.Errorf("target %q is not an absolute URL", target)
+	}
+	jar, _ := cookiejar.New(nil)
+	return &Client{
+		base: base,
+		http: &http.Client{Jar: jar, Timeout: 30 * time.Second},
+	}, nil
+}
+
+// Origin is the value browsers send in the Origin header for the target
+func (c *Client) Origin() string {
+	return c.base.Scheme + "://" + c.base.Host
+}
+
+func (c *Client) Get(path string) (Response, error) {
+	return c.do("GET", path, nil, nil)
+}
+
+// Post submits a form from one of the target's own pages
+func (c *Client) Post(path string, form url.Values) (Response, error) {
+	form = cloneValues(form)
+	c.mu.Lock()
+	if c.csrf != "" {
+		form.Set("csrf_token", c.csrf)
+	}
+	c.mu.Unlock()
+	header := http.Header{
+		"Content-Type": {"application/x-www-form-urlencoded"},
+		"Origin":       {c.Origin()},
+	}
+	return c.do("POST", path, header, strings.NewReader(form.Encode()))
+}
+
+// PostFrom submits a form from a page on another site: the browser attaches
+// the target's cookies but the page can't know the CSRF token
+func (c *Client) PostFrom(origin, path string, form url.Values) (Response, error) {
+	header := http.Header{
+		"Content-Type": {"application/x-www-form-urlencoded"},
+		"Origin":       {origin},
+	}
+	return c.do("POST", path, header, strings.NewReader(form.Encode()))
+}
+
+// JSON sends body as application/json, the way the target's own scripts do
+func (c *Client) JSON(method, path, body string, extra http.Header) (Response, error) {
+	header := http.Header{
+		"Content-Type": {"application/json"},
+		"Origin":       {c.Origin()},
+	}
+	for name, values := range extra {
+		header[name] = values
+	}
+	return c.do(method, path, header, strings.NewReader(body))
+}
+
+// Cookie returns the value of the named cookie the target set, if any
+func (c *Client) Cookie(name string) string {
+	for _, cookie := range c.http.Jar.Cookies(c.base) {
+		if cookie.Name == name {
+			return cookie.Value
+		}
+	}
+	return ""
+}
+
+// SetCookie plants a cookie for the target, as a sibling subdomain or an
+// injected script could
+func (c *Client) SetCookie(name, value string) {
+	c.http.Jar.SetCookies(c.base, []*http.Cookie{{Name: name, Value: value, Path: "/"}})
+}
+
+func (c *Client) do(method, path string, header http.Header, body io.Reader) (Response, error) {
+	req, err := http.NewRequest(method, c.base.String()+path, body)
+	if err != nil {
+		return Response{}, err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return Response{}, err
+	}
+	defer resp.Body.Close()
+
+	var buf bytes.Buffer
+	if _, err := io.Copy(&buf, io.LimitReader(resp.Body, 4<<20)); err != nil {
+		return Response{}, fmt.Errorf("%s %s: reading body: %w", method, path, err)
+	}
+	page := buf.String()
+
+	if m := csrfFieldPattern.FindStringSubmatch(page); m != nil {
+		c.mu.Lock()
+		c.csrf = m[1]
+		c.mu.Unlock()
+	}
+	return Response{Status: resp.StatusCode, URL: resp.Request.URL, Body: page}, nil
+}
+
+func cloneValues(v url.Values) url.Values {
+	out := url.Values{}
+	for key, values := range v {
+		out[key] = append([]string(nil), values...)
+	}
+	return out
+}
@@ -0,0 +1,83 @@
+// Package exploit attacks a running shop over HTTP to show which variant of
+// each scenario falls for its flaw. Every attack runs the same steps against
+// the vulnerable and the secure variant and reports whether it got what it
+// was after. Attacks place real orders and use up stock, so point them at a
+// throwaway instance.
+package exploit
+
+import (
+	"fmt"
+	"secure-webapp/scenario"
+)
+
+// Outcome is whether an attack achieved its goal
+type Outcome int
+
+const (
+	Blocked Outcome = iota
+	Exploited
+)
+
+func (o Outcome) String() string {
+	if o == Exploited {
+		return "exploited"
+	}
+	return "blocked"
+}
+
+// Finding is what one run of an attack observed
+type Finding struct {
+	Outcome Outcome
+	// Evidence describes what the target answered, e.g. "paid $0.01"
+	Evidence string
+}
+
+func exploited(format string, args ...any) Finding {
+	return Finding{Outcome: Exploited, Evidence: fmt.Sprintf(format, args...)}
+}
+
+func blocked(format string, args ...any) Finding {
+	return Finding{Outcome: Blocked, Evidence: fmt.Sprintf(format, args...)}
+}
+
+// Attack demonstrates the flaw of one scenario
+type Attack struct {
+	// Scenario is the name the pair is registered under
+	Scenario string
+	// Technique is a one-line description of what the attack does
+	Technique string
+	// Run attacks the given variant of the shop at target, a base URL such
+	// as "http://localhost:8080". It returns an error only when the attack
+	// could not be carried out at all.
+	Run func(target string, v scenario.Variant) (Finding, error)
+}
+
+// Result is the outcome of one attack against one variant
+type Result struct {
+	Attack  Attack
+	Variant scenario.Variant
+	Finding Finding
+	Err     error
+}
+
+// Proven reports whether the result is what the pair is meant to show: the
+// vulnerable variant exploited and the secure one blocked
+func (r Result) Proven() bool {
+	if r.Err != nil {
+		return false
+	}
+	return (r.Finding.Outcome == Exploited) == (r.Variant == scenario.Vulnerable)
+}
+
+// Run carries out each attack against both variants of the target, the
+// vulnerable one first
+func Run(target string, attacks []Attack) []Result {
+	var results []Result
+	for _, attack := range attacks {
+		for _, v := range []scenario.Variant{scenario.Vulnerable, scenario.Secure} {
+			finding, err := attack.Run(target, v)
+			results = append(results, Result{Attack: attack, Variant: v, Finding: finding, Err: err})
+		}
+	}
+	return results
+}
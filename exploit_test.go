@@ -0,0 +1,68 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"secure-webapp/exploit"
+	"secure-webapp/gateway"
+	"secure-webapp/handlers"
+	"secure-webapp/models"
+	"testing"
+)
+
+// startShop serves the whole app from a fresh in-memory store, with the
+// payment gateway sending its webhooks back to the same test server
+func startShop(t *testing.T) (*handlers.Server, string) {
+	t.Helper()
+
+	store := models.NewMemoryStore()
+	models.InitStores(store)
+	s := handlers.NewServer(store)
+
+	ts := httptest.NewUnstartedServer(nil)
+	s.Gateway = gateway.New(s.WebhookSecret, "http://"+ts.Listener.Addr().String()+"/secure-order/webhook")
+	ts.Config.Handler = routes(s, http.NotFoundHandler())
+	ts.Start()
+	t.Cleanup(ts.Close)
+
+	return s, ts.URL
+}
+
+func TestAttacksCoverEveryScenario(t *testing.T) {
+	s, _ := startShop(t)
+
+	attacked := map[string]bool{}
+	for _, attack := range exploit.Attacks {
+		if _, ok := s.Scenarios.Get(attack.Scenario); !ok {
+			t.Errorf("attack for %q, which is not a registered scenario", attack.Scenario)
+		}
+		attacked[attack.Scenario] = true
+	}
+	for _, sc := range s.Scenarios.Scenarios() {
+		if !attacked[sc.Name()] {
+			t.Errorf("scenario %q has no attack in the exploit harness", sc.Name())
+		}
+	}
+}
+
+// TestExploitHarness runs every attack against both variants of a fresh
+// shop: the vulnerable variant has to fall for it and the secure one has to
+// hold
+func TestExploitHarness(t *testing.T) {
+	for _, attack := range exploit.Attacks {
+		t.Run(attack.Scenario, func(t *testing.T) {
+			_, target := startShop(t)
+
+			for _, r := range exploit.Run(target, []exploit.Attack{attack}) {
+				switch {
+				case r.Err != nil:
+					t.Errorf("%s: attack failed to run: %v", r.Variant, r.Err)
+				case !r.Proven():
+					t.Errorf("%s variant was %s (%s)", r.Variant, r.Finding.Outcome, r.Finding.Evidence)
+				default:
+					t.Logf("%s: %s (%s)", r.Variant, r.Finding.Outcome, r.Finding.Evidence)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,229 @@
+// Package gateway is an in-process stand-in for a card payment provider.
+// Charges are created synchronously, their outcome is decided by the card
+// number, and the final result is reported to the merchant through an
+// HMAC-signed webhook, just like a real provider would.
+package gateway
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"secure-webapp/models"
+	"strings"
+	"sync"
+	"time"
+)
+
+type ChargeStatus string
+
+const (
+	// ChargeProcessing means the charge was accepted and the webhook with the
+	// final result has not been sent yet (or never will be, for the timeout card)
+	ChargeProcessing     ChargeStatus = "processing"
+	ChargeRequiresAction ChargeStatus = "requires_action" // 3-D Secure challenge pending
+	ChargeSucceeded      ChargeStatus = "succeeded"
+	ChargeFailed         ChargeStatus = "failed"
+	ChargeRefunded       ChargeStatus = "refunded"
+)
+
+// Test card numbers with special outcomes. Any other number that passes the
+// Luhn check is approved.
+const (
+	CardDecline      = "4000000000000002"
+	CardRequires3DS  = "4000000000003220"
+	CardTimeout      = "4000000000000119"
+	CardApproveBasic = "4242424242424242"
+)
+
+var (
+	ErrCardDeclined  = errors.New("card declined")
+	ErrInvalidCard   = errors.New("invalid card number")
+	ErrInvalidAmount = errors.New("amount must be positive")
+	ErrChargeUnknown = errors.New("charge not found")
+	ErrNotRefundable = errors.New("only a succeeded charge can be refunded")
+)
+
+type Charge struct {
+	ID        string       `json:"id"`
+	OrderID   string       `json:"order_id"`
+	Amount    models.Money `json:"amount"`
+	Status    ChargeStatus `json:"status"`
+	CardLast4 string       `json:"card_last4"`
+	Created   time.Time    `json:"created"`
+	// ReturnURL is where the 3-D Secure page sends the customer afterwards;
+	// it is always a path on the merchant's own site
+	ReturnURL string `json:"-"`
+}
+
+// Gateway keeps its charges in memory and delivers webhooks to WebhookURL
+type Gateway struct {
+	Secret     []byte
+	WebhookURL string
+	Client     *http.Client
+	// WebhookDelay is how long an approved charge "processes" before the
+	// success webhook is sent
+	WebhookDelay time.Duration
+
+	mu      sync.Mutex
+	charges map[string]*Charge
+}
+
+func New(secret []byte, webhookURL string) *Gateway {
+	return &Gateway{
+		Secret:       secret,
+		WebhookURL:   webhookURL,
+		Client:       &http.Client{Timeout: 5 * time.Second},
+		WebhookDelay: time.Second,
+		charges:      make(map[string]*Charge),
+	}
+}
+
+// CreateCharge authorises a card for amount. Declined and invalid cards and
+// amounts fail immediately; everything else returns a charge whose final
+// outcome arrives later through the webhook.
+func (g *Gateway) CreateCharge(orderID string, amount models.Money, cardNumber, returnURL string) (Charge, error) {
+	if amount.Amount <= 0 {
+		return Charge{}, ErrInvalidAmount
+	}
+	card := normalizeCard(cardNumber)
+	if !luhnValid(card) {
+		return Charge{}, ErrInvalidCard
+	}
+
+	charge := &Charge{
+		ID:        "ch_" + models.GenerateID(),
+		OrderID:   orderID,
+		Amount:    amount,
+		Status:    ChargeProcessing,
+		CardLast4: card[len(card)-4:],
+		Created:   time.Now(),
+		ReturnURL: localURL(returnURL),
+	}
+
+	switch card {
+	case CardDecline:
+		charge.Status = ChargeFailed
+	case CardRequires3DS:
+		charge.Status = ChargeRequiresAction
+	}
+
+	g.mu.Lock()
+	g.charges[charge.ID] = charge
+	g.mu.Unlock()
+
+	switch {
+	case charge.Status == ChargeFailed:
+		return *charge, ErrCardDeclined
+	case card == CardTimeout:
+		// The provider never answers; the order stays unpaid until it expires
+	case charge.Status == ChargeProcessing:
+		g.settleLater(charge.ID, ChargeSucceeded)
+	}
+	return *charge, nil
+}
+
+func (g *Gateway) GetCharge(id string) (Charge, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	charge, exists := g.charges[id]
+	if !exists {
+		return Charge{}, false
+	}
+	return *charge, true
+}
+
+// CompleteChallenge resolves a 3-D Secure challenge
+func (g *Gateway) CompleteChallenge(id string, approved bool) (Charge, error) {
+	g.mu.Lock()
+	charge, exists := g.charges[id]
+	if !exists {
+		g.mu.Unlock()
+		return Charge{}, ErrChargeUnknown
+	}
+	if charge.Status != ChargeRequiresAction {
+		result := *charge
+		g.mu.Unlock()
+		return result, nil
+	}
+	charge.Status = ChargeProcessing
+	result := *charge
+	g.mu.Unlock()
+
+	if approved {
+		g.settleLater(id, ChargeSucceeded)
+	} else {
+		g.settleLater(id, ChargeFailed)
+	}
+	return result, nil
+}
+
+// Refund gives the whole of a succeeded charge back to the card
+func (g *Gateway) Refund(id string) (Charge, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	charge, exists := g.charges[id]
+	if !exists {
+		return Charge{}, ErrChargeUnknown
+	}
+	if charge.Status != ChargeSucceeded {
+		return *charge, ErrNotRefundable
+	}
+	charge.Status = ChargeRefunded
+	return *charge, nil
+}
+
+// settleLater records the final status after WebhookDelay and notifies the
+// merchant
+func (g *Gateway) settleLater(id string, status ChargeStatus) {
+	go func() {
+		time.Sleep(g.WebhookDelay)
+
+		g.mu.Lock()
+		charge := g.charges[id]
+		charge.Status = status
+		settled := *charge
+		g.mu.Unlock()
+
+		g.deliver(settled)
+	}()
+}
+
+// localURL returns returnURL if it can only lead to a page on the same site,
+// and "/" otherwise. Browsers read "//host" and "/\host" as another site.
+func localURL(returnURL string) string {
+	u, err := url.Parse(returnURL)
+	if err != nil || u.Scheme != "" || u.Host != "" || !strings.HasPrefix(returnURL, "/") ||
+		strings.HasPrefix(returnURL, "//") || strings.Contains(returnURL, `\`) {
+		return "/"
+	}
+	return returnURL
+}
+
+func normalizeCard(number string) string {
+	return strings.NewReplacer(" ", "", "-", "").Replace(number)
+}
+
+func luhnValid(number string) bool {
+	if len(number) < 12 || len(number) > 19 {
+		return false
+	}
+	sum := 0
+	double := false
+	for i := len(number) - 1; i >= 0; i-- {
+		c := number[i]
+		if c < '0' || c > '9' {
+			return false
+		}
+		digit := int(c - '0')
+		if double {
+			digit *= 2
+			if digit > 9 {
+				digit -= 9
+			}
+		}
+		sum += digit
+		double = !double
+	}
+	return sum%10 == 0
+}
@@ -0,0 +1,17 @@
+package gateway
+
+import (
+	"errors"
+	"secure-webapp/models"
+	"testing"
+)
+
+func TestCreateChargeNeedsAPositiveAmount(t *testing.T) {
+	g := New([]byte("secret"), "http://localhost/webhook")
+	for _, cents := range []int64{0, -100} {
+		charge, err := g.CreateCharge("order", models.NewMoney(cents, models.BaseCurrency), CardRequires3DS, "/")
+		if !errors.Is(err, ErrInvalidAmount) || charge.ID != "" {
+			t.Errorf("charging %d cents = %q, %v; want no charge and ErrInvalidAmount", cents, charge.ID, err)
+		}
+	}
+}
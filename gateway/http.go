@@ -0,0 +1,106 @@
+package gateway
+
+import (
+	"bytes"
+	"embed"
+	"encoding/json"
+	"errors"
+	"html/template"
+	"log"
+	"net/http"
+	"secure-webapp/models"
+)
+
+// The bank's 3-D Secure page belongs to the gateway, not the shop, so it is
+// a standalone document rather than a page in the shop's layout
+//
+//go:embed templates/3ds.html
+var templateFS embed.FS
+
+var challengePage = template.Must(template.ParseFS(templateFS, "templates/3ds.html"))
+
+type chargeRequest struct {
+	OrderID    string       `json:"order_id"`
+	Amount     models.Money `json:"amount"`
+	CardNumber string       `json:"card_number"`
+	ReturnURL  string       `json:"return_url"`
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
+
+// ChargesHandler is the provider's API: POST creates a charge, GET ?id=
+// looks one up
+func (g *Gateway) ChargesHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		charge, exists := g.GetCharge(r.URL.Query().Get("id"))
+		if !exists {
+			writeError(w, http.StatusNotFound, ErrChargeUnknown.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, charge)
+
+	case "POST":
+		var req chargeRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid JSON body")
+			return
+		}
+		if req.OrderID == "" {
+			writeError(w, http.StatusBadRequest, "order_id is required")
+			return
+		}
+		charge, err := g.CreateCharge(req.OrderID, req.Amount, req.CardNumber, req.ReturnURL)
+		switch {
+		case errors.Is(err, ErrInvalidAmount), errors.Is(err, ErrInvalidCard):
+			writeError(w, http.StatusBadRequest, err.Error())
+		case errors.Is(err, ErrCardDeclined):
+			writeJSON(w, http.StatusPaymentRequired, charge)
+		default:
+			writeJSON(w, http.StatusCreated, charge)
+		}
+
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// ChallengeHandler is the bank's 3-D Secure page the customer is sent to
+// for CardRequires3DS charges
+func (g *Gateway) ChallengeHandler(w http.ResponseWriter, r *http.Request) {
+	chargeID := r.FormValue("charge_id")
+	charge, exists := g.GetCharge(chargeID)
+	if !exists {
+		http.Error(w, "Charge not found", http.StatusNotFound)
+		return
+	}
+
+	if r.Method == "POST" {
+		charge, err := g.CompleteChallenge(chargeID, r.FormValue("result") == "approve")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		// CreateCharge only kept the return URL if it stays on this site
+		http.Redirect(w, r, charge.ReturnURL, http.StatusSeeOther)
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := challengePage.Execute(&buf, charge); err != nil {
+		log.Printf("Rendering 3-D Secure page: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	buf.WriteTo(w)
+}
@@ -0,0 +1,43 @@
+package gateway
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"secure-webapp/models"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestChallengeOnlyReturnsToTheMerchant(t *testing.T) {
+	g := New([]byte("secret"), "http://localhost/webhook")
+	g.WebhookDelay = time.Hour
+
+	tests := []struct {
+		returnURL, want string
+	}{
+		{"/secure-order/result?order_id=1", "/secure-order/result?order_id=1"},
+		{"https://evil.example/", "/"},
+		{"//evil.example", "/"},
+		{"///evil.example", "/"},
+		{`/\evil.example`, "/"},
+		{"/\t/evil.example", "/"},
+		{"javascript:alert(1)", "/"},
+		{"result", "/"},
+		{"", "/"},
+	}
+	for _, tt := range tests {
+		charge, err := g.CreateCharge("order", models.NewMoney(100, models.BaseCurrency), CardRequires3DS, tt.returnURL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req := httptest.NewRequest("POST", "/gateway/3ds", strings.NewReader(url.Values{"charge_id": {charge.ID}, "result": {"approve"}}.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+		g.ChallengeHandler(rec, req)
+		if got := rec.Header().Get("Location"); rec.Code != http.StatusSeeOther || got != tt.want {
+			t.Errorf("return URL %q redirected to %q with %d, want %q", tt.returnURL, got, rec.Code, tt.want)
+		}
+	}
+}
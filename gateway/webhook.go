@@ -0,0 +1,138 @@
+package gateway
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"secure-webapp/models"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SignatureHeader carries "t=<unix seconds>,v1=<hex HMAC-SHA256>" where the
+// MAC covers "<t>.<raw body>"
+const SignatureHeader = "Gateway-Signature"
+
+const (
+	EventChargeSucceeded = "charge.succeeded"
+	EventChargeFailed    = "charge.failed"
+)
+
+var (
+	ErrMissingSignature = errors.New("missing webhook signature")
+	ErrBadSignature     = errors.New("webhook signature mismatch")
+	ErrStaleSignature   = errors.New("webhook timestamp outside tolerance")
+)
+
+// Event is the webhook payload
+type Event struct {
+	ID       string       `json:"id"` // unique per delivery attempt series, usable as a nonce
+	Type     string       `json:"type"`
+	ChargeID string       `json:"charge_id"`
+	OrderID  string       `json:"order_id"`
+	Amount   models.Money `json:"amount"`
+	Created  int64        `json:"created"`
+}
+
+// Sign computes the signature header value for body at time t
+func Sign(secret []byte, t time.Time, body []byte) string {
+	ts := strconv.FormatInt(t.Unix(), 10)
+	return "t=" + ts + ",v1=" + mac(secret, ts, body)
+}
+
+func mac(secret []byte, ts string, body []byte) string {
+	h := hmac.New(sha256.New, secret)
+	h.Write([]byte(ts))
+	h.Write([]byte("."))
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// VerifySignature checks header against body and returns the signed
+// timestamp. Signatures older or newer than tolerance relative to now are
+// rejected so captured deliveries cannot be replayed indefinitely.
+func VerifySignature(secret []byte, header string, body []byte, tolerance time.Duration, now time.Time) (time.Time, error) {
+	if header == "" {
+		return time.Time{}, ErrMissingSignature
+	}
+	var ts, sig string
+	for _, part := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch key {
+		case "t":
+			ts = value
+		case "v1":
+			sig = value
+		}
+	}
+	unix, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil || sig == "" {
+		return time.Time{}, ErrBadSignature
+	}
+	if !hmac.Equal([]byte(sig), []byte(mac(secret, ts, body))) {
+		return time.Time{}, ErrBadSignature
+	}
+	signed := time.Unix(unix, 0)
+	if diff := now.Sub(signed); diff > tolerance || diff < -tolerance {
+		return signed, ErrStaleSignature
+	}
+	return signed, nil
+}
+
+// deliver posts the signed event for a settled charge, retrying a few times
+// with backoff like a real provider
+func (g *Gateway) deliver(charge Charge) {
+	event := Event{
+		ID:       "evt_" + models.GenerateID(),
+		Type:     EventChargeSucceeded,
+		ChargeID: charge.ID,
+		OrderID:  charge.OrderID,
+		Amount:   charge.Amount,
+		Created:  time.Now().Unix(),
+	}
+	if charge.Status == ChargeFailed {
+		event.Type = EventChargeFailed
+	}
+	body, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("gateway: encoding webhook for %s: %v", charge.ID, err)
+		return
+	}
+
+	backoff := 500 * time.Millisecond
+	for attempt := 1; attempt <= 3; attempt++ {
+		err = g.post(body)
+		if err == nil {
+			return
+		}
+		log.Printf("gateway: webhook for %s attempt %d: %v", charge.ID, attempt, err)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+func (g *Gateway) post(body []byte) error {
+	req, err := http.NewRequest("POST", g.WebhookURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(SignatureHeader, Sign(g.Secret, time.Now(), body))
+
+	resp, err := g.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("merchant answered %s", resp.Status)
+	}
+	return nil
+}
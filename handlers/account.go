@@ -0,0 +1,136 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"secure-webapp/models"
+	"sync"
+)
+
+// dummyPasswordHash is checked against when a login names an unknown user,
+// so the response takes as long as for a wrong password and doesn't reveal
+// which usernames exist
+var (
+	dummyPasswordHash     string
+	dummyPasswordHashOnce sync.Once
+)
+
+func checkDummyPassword(password string) {
+	dummyPasswordHashOnce.Do(func() {
+		dummyPasswordHash, _ = models.HashPassword(models.GenerateID())
+	})
+	models.CheckPassword(dummyPasswordHash, password)
+}
+
+// sessionUser returns the account the session is signed in to, if any
+func (s *Server) sessionUser(sessionID string) (models.User, bool) {
+	session, exists := s.Store.GetSession(sessionID)
+	if !exists || session.Username == "" {
+		return models.User{}, false
+	}
+	return s.Store.GetUser(session.UserID)
+}
+
+// signIn binds the session to user under a fresh session ID
+func (s *Server) signIn(w http.ResponseWriter, r *http.Request, user models.User) {
+	s.rotateSession(w, r, func(session *models.Session) {
+		session.UserID = user.ID
+		session.Username = user.Username
+	})
+}
+
+func (s *Server) renderAccountForm(w http.ResponseWriter, r *http.Request, status int, title, action, next, username, message string) {
+	data := struct {
+		Title    string
+		Action   string
+		Next     string
+		Username string
+		Error    string
+	}{
+		Title:    title,
+		Action:   action,
+		Next:     next,
+		Username: username,
+		Error:    message,
+	}
+
+	s.renderStatus(w, r, status, "account-form", data)
+}
+
+func (s *Server) RegisterHandler(w http.ResponseWriter, r *http.Request) {
+	next := localPath(r.FormValue("next"), "/account")
+	if r.Method != "POST" {
+		s.renderAccountForm(w, r, http.StatusOK, "Register", "/register", next, "", "")
+		return
+	}
+
+	username := r.FormValue("username")
+	user, err := models.NewUser(username, r.FormValue("password"))
+	if err == nil {
+		err = s.Store.CreateUser(user)
+	}
+	if err != nil {
+		message := err.Error()
+		if !errors.Is(err, models.ErrUsernameTaken) && !errors.Is(err, models.ErrInvalidUsername) && !errors.Is(err, models.ErrWeakPassword) {
+			message = "Registration failed, please try again"
+		}
+		s.renderAccountForm(w, r, http.StatusBadRequest, "Register", "/register", next, username, message)
+		return
+	}
+
+	s.signIn(w, r, user)
+	http.Redirect(w, r, next, http.StatusSeeOther)
+}
+
+func (s *Server) LoginHandler(w http.ResponseWriter, r *http.Request) {
+	next := localPath(r.FormValue("next"), "/account")
+	if r.Method != "POST" {
+		s.renderAccountForm(w, r, http.StatusOK, "Sign In", "/login", next, "", "")
+		return
+	}
+
+	username := r.FormValue("username")
+	password := r.FormValue("password")
+	user, exists := s.Store.GetUserByUsername(username)
+	if !exists {
+		checkDummyPassword(password)
+	}
+	if !exists || !models.CheckPassword(user.PasswordHash, password) {
+		// One message for both cases so usernames can't be enumerated
+		s.renderAccountForm(w, r, http.StatusUnauthorized, "Sign In", "/login", next, username, "Invalid username or password")
+		return
+	}
+
+	s.signIn(w, r, user)
+	http.Redirect(w, r, next, http.StatusSeeOther)
+}
+
+func (s *Server) LogoutHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	s.endSession(w, r)
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// AccountHandler shows the signed-in customer their orders
+func (s *Server) AccountHandler(w http.ResponseWriter, r *http.Request) {
+	sessionID := s.getOrCreateSession(w, r)
+	user, ok := s.sessionUser(sessionID)
+	if !ok {
+		http.Redirect(w, r, "/login?next=/account", http.StatusSeeOther)
+		return
+	}
+
+	data := struct {
+		User   models.User
+		Orders []models.Order
+	}{
+		User:   user,
+		Orders: s.ordersForUser(user.ID),
+	}
+
+	s.render(w, r, "account", data)
+}
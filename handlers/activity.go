@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"net/http"
+	"secure-webapp/models"
+	"sync"
+	"time"
+)
+
+// maxTamperEvents bounds how much exploit history the dashboard keeps; it is
+// a live view, not an audit log
+const maxTamperEvents = 200
+
+// tamperEvent is one exploit attempt a shop noticed
+type tamperEvent struct {
+	At       time.Time
+	Scenario string
+	Kind     string
+	Detail   string
+	Session  string // shortened, so the dashboard can't be used to hijack it
+	Visitor  string
+}
+
+// activityFeed keeps the latest tamper events and wakes the dashboard
+// streams whenever one is recorded
+type activityFeed struct {
+	mu       sync.Mutex
+	events   []tamperEvent
+	watchers map[chan struct{}]struct{}
+}
+
+func (f *activityFeed) record(event tamperEvent) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.events = append(f.events, event)
+	if len(f.events) > maxTamperEvents {
+		f.events = append([]tamperEvent(nil), f.events[len(f.events)-maxTamperEvents:]...)
+	}
+	for watcher := range f.watchers {
+		// A watcher that already has a wake-up pending will see this event too
+		select {
+		case watcher <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// recent returns the kept events, newest first
+func (f *activityFeed) recent() []tamperEvent {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	events := make([]tamperEvent, len(f.events))
+	for i, event := range f.events {
+		events[len(events)-1-i] = event
+	}
+	return events
+}
+
+// watch returns a channel that receives after every new event, and a
+// function to stop watching
+func (f *activityFeed) watch() (<-chan struct{}, func()) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	watcher := make(chan struct{}, 1)
+	if f.watchers == nil {
+		f.watchers = map[chan struct{}]struct{}{}
+	}
+	f.watchers[watcher] = struct{}{}
+	return watcher, func() {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		delete(f.watchers, watcher)
+	}
+}
+
+// shortID is enough of a session ID to tell sessions apart on the dashboard
+func shortID(id string) string {
+	return id[:min(len(id), 8)]
+}
+
+// recordTampering logs an exploit attempt against the visitor's session
+func (s *Server) recordTampering(r *http.Request, scenario, kind, detail string) {
+	event := tamperEvent{At: time.Now(), Scenario: scenario, Kind: kind, Detail: detail, Session: "new", Visitor: "guest"}
+	if session, ok := s.currentSession(r); ok {
+		event.Session = shortID(session.ID)
+		if session.Username != "" {
+			event.Visitor = session.Username
+		}
+	}
+	s.activity.record(event)
+}
+
+// checkClientPrice logs a price the client sent for a product when it isn't
+// the catalog price
+func (s *Server) checkClientPrice(r *http.Request, scenario string, product models.Product, sent models.Money) {
+	if sent != product.Price {
+		s.recordTampering(r, scenario, "client price", product.Name+" sent at "+sent.String()+", catalog price "+product.Price.String())
+	}
+}
+
+// exploited is called by a vulnerable shop once the visitor has pulled off
+// its exploit. It is logged for the dashboard and, in CTF mode, captures the
+// flag for the visitor's team.
+func (s *Server) exploited(r *http.Request, challengeID string) {
+	challenge, _ := ctfChallengeByID(challengeID)
+	s.recordTampering(r, challenge.Scenario, "exploit", challenge.Goal)
+	s.captureFlag(r, challengeID)
+}
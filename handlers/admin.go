@@ -0,0 +1,201 @@
+package handlers
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"secure-webapp/models"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// dashboardRefresh is how often a dashboard stream checks for session,
+	// cart and order changes; tamper events are pushed straight away
+	dashboardRefresh = 2 * time.Second
+	// dashboardKeepAlive stops proxies closing a stream that has been quiet
+	dashboardKeepAlive = 30 * time.Second
+	// dashboardOrders is how many of the newest orders the dashboard lists
+	dashboardOrders = 50
+)
+
+// adminSession returns the visitor's session and whether it is signed in,
+// and as one of the Admins
+func (s *Server) adminSession(r *http.Request) (session models.Session, signedIn, admin bool) {
+	session, ok := s.currentSession(r)
+	signedIn = ok && session.Username != ""
+	return session, signedIn, signedIn && slices.Contains(s.Admins, session.UserID)
+}
+
+// Admin only lets users listed in Admins through. Anyone else is sent to
+// sign in, or refused if they already are.
+func (s *Server) Admin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		_, signedIn, admin := s.adminSession(r)
+		if !signedIn {
+			http.Redirect(w, r, "/login?next="+r.URL.Path, http.StatusSeeOther)
+			return
+		}
+		if !admin {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// AdminAPI is Admin for the JSON API: it answers with an API error instead
+// of redirecting
+func (s *Server) AdminAPI(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		_, signedIn, admin := s.adminSession(r)
+		if !signedIn {
+			writeAPIError(w, http.StatusUnauthorized, "unauthorized", "sign in as an admin first")
+			return
+		}
+		if !admin {
+			writeAPIError(w, http.StatusForbidden, "forbidden", "admins only")
+			return
+		}
+		next(w, r)
+	}
+}
+
+type dashboardCart struct {
+	Shop  string
+	Items int
+	Total models.Money
+}
+
+type dashboardSession struct {
+	ID       string
+	Visitor  string
+	Team     string
+	LastSeen time.Time
+	Carts    []dashboardCart
+	Orders   int
+}
+
+type dashboardOrder struct {
+	models.Order
+	Visitor string
+}
+
+type dashboard struct {
+	Sessions []dashboardSession
+	Orders   []dashboardOrder
+	Events   []tamperEvent
+}
+
+// dashboard gathers what the instructor sees: live sessions with their
+// carts, the newest orders and the recent tamper events
+func (s *Server) dashboard() dashboard {
+	now := time.Now()
+	orders := s.Store.ListOrders()
+	sort.Slice(orders, func(i, j int) bool { return orders[i].Timestamp.After(orders[j].Timestamp) })
+
+	ordersByUser := map[string]int{}
+	for _, order := range orders {
+		ordersByUser[order.UserID]++
+	}
+
+	var d dashboard
+	for _, session := range s.Store.ListSessions() {
+		// Fixation demo sessions are keyed outside the real ID space
+		if !validSessionID(session.ID) || sessionExpired(session, now) {
+			continue
+		}
+		row := dashboardSession{
+			ID:       shortID(session.ID),
+			Visitor:  s.visitorName(session.UserID),
+			LastSeen: session.LastSeen,
+			Orders:   ordersByUser[session.UserID],
+		}
+		if team, ok := s.Store.GetTeam(session.TeamID); ok {
+			row.Team = team.Name
+		}
+		for shop, cart := range s.Store.ListCarts(session.ID) {
+			if len(cart.Items) > 0 {
+				row.Carts = append(row.Carts, dashboardCart{Shop: shop, Items: len(cart.Items), Total: cart.Total})
+			}
+		}
+		sort.Slice(row.Carts, func(i, j int) bool { return row.Carts[i].Shop < row.Carts[j].Shop })
+		d.Sessions = append(d.Sessions, row)
+	}
+	sort.Slice(d.Sessions, func(i, j int) bool { return d.Sessions[i].LastSeen.After(d.Sessions[j].LastSeen) })
+
+	for _, order := range orders[:min(len(orders), dashboardOrders)] {
+		d.Orders = append(d.Orders, dashboardOrder{Order: order, Visitor: s.visitorName(order.UserID)})
+	}
+	d.Events = s.activity.recent()
+	return d
+}
+
+// visitorName is the username behind a user ID, or "guest" for the random
+// IDs anonymous sessions get
+func (s *Server) visitorName(userID string) string {
+	if user, ok := s.Store.GetUser(userID); ok {
+		return user.Username
+	}
+	return "guest"
+}
+
+func (s *Server) AdminHandler(w http.ResponseWriter, r *http.Request) {
+	s.render(w, r, "admin", s.dashboard())
+}
+
+// AdminEventsHandler streams the dashboard as Server-Sent Events. Each
+// "activity" event carries the re-rendered activity fragment, sent whenever
+// it differs from the last one.
+func (s *Server) AdminEventsHandler(w http.ResponseWriter, r *http.Request) {
+	rc := http.NewResponseController(w)
+	wake, stop := s.activity.watch()
+	defer stop()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+
+	refresh := time.NewTicker(dashboardRefresh)
+	defer refresh.Stop()
+	keepAlive := time.NewTicker(dashboardKeepAlive)
+	defer keepAlive.Stop()
+
+	var last []byte
+	for {
+		var buf bytes.Buffer
+		if err := s.executeTemplate(&buf, w, r, "admin", "admin-activity", s.dashboard()); err != nil {
+			log.Printf("Rendering dashboard: %v", err)
+			return
+		}
+		if !bytes.Equal(buf.Bytes(), last) {
+			last = buf.Bytes()
+			var event strings.Builder
+			event.WriteString("event: activity\n")
+			for line := range strings.Lines(buf.String()) {
+				event.WriteString("data: " + strings.TrimSuffix(line, "\n") + "\n")
+			}
+			event.WriteString("\n")
+			if _, err := w.Write([]byte(event.String())); err != nil {
+				return
+			}
+			if err := rc.Flush(); err != nil {
+				return
+			}
+		}
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-wake:
+		case <-refresh.C:
+		case <-keepAlive.C:
+			if _, err := w.Write([]byte(": keep-alive\n\n")); err != nil {
+				return
+			}
+			rc.Flush()
+		}
+	}
+}
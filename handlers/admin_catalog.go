@@ -0,0 +1,336 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"secure-webapp/models"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Catalog administration: admins create, edit, reprice and archive products
+// from /admin/products or /api/v1/admin/products. Every change lands in the
+// store's audit log, and the shops read the catalog on each request, so they
+// pick it up straight away.
+
+// catalogMessages maps the codes the catalog admin redirects with to their
+// text
+var catalogMessages = map[string]string{
+	"invalid_name":      models.ErrInvalidProduct.Error() + ".",
+	"invalid_price":     models.ErrInvalidPrice.Error() + ".",
+	"invalid_stock":     models.ErrInvalidStock.Error() + ".",
+	"unchanged":         "Nothing was changed.",
+	"product_not_found": "No such product.",
+}
+
+// catalogErrorCode is the code a rejected catalog change is reported with,
+// or "" for failures that aren't the admin's fault
+func catalogErrorCode(err error) string {
+	switch {
+	case errors.Is(err, models.ErrInvalidProduct):
+		return "invalid_name"
+	case errors.Is(err, models.ErrInvalidPrice):
+		return "invalid_price"
+	case errors.Is(err, models.ErrInvalidStock):
+		return "invalid_stock"
+	case errors.Is(err, models.ErrNoProductChanges):
+		return "unchanged"
+	case errors.Is(err, models.ErrProductNotFound):
+		return "product_not_found"
+	}
+	return ""
+}
+
+// actor is who the audit log credits with a change
+func (s *Server) actor(r *http.Request) string {
+	session, _, _ := s.adminSession(r)
+	return session.Username
+}
+
+// allProducts is the whole catalog, archived products included, in ID order
+func (s *Server) allProducts() []models.Product {
+	var products []models.Product
+	for _, product := range s.Store.ListProducts() {
+		products = append(products, product)
+	}
+	sort.Slice(products, func(i, j int) bool {
+		a, errA := strconv.Atoi(products[i].ID)
+		b, errB := strconv.Atoi(products[j].ID)
+		if errA != nil || errB != nil {
+			return products[i].ID < products[j].ID
+		}
+		return a < b
+	})
+	return products
+}
+
+// productChanges is the audit log, newest first
+func (s *Server) productChanges() []models.ProductChange {
+	changes := s.Store.ListProductChanges()
+	sort.SliceStable(changes, func(i, j int) bool { return changes[i].At.After(changes[j].At) })
+	return changes
+}
+
+// productForm reads the name, price and stock fields of a catalog form
+func productForm(r *http.Request) (models.Product, string) {
+	price, err := models.ParseMoney(strings.TrimSpace(r.FormValue("price")), models.BaseCurrency)
+	if err != nil {
+		return models.Product{}, "invalid_price"
+	}
+	stock, err := strconv.Atoi(strings.TrimSpace(r.FormValue("stock")))
+	if err != nil {
+		return models.Product{}, "invalid_stock"
+	}
+	return models.Product{Name: r.FormValue("name"), Price: price, Stock: stock}, ""
+}
+
+// AdminProductsHandler lists the catalog and its audit log, and creates
+// products posted to it
+func (s *Server) AdminProductsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "POST" {
+		product, code := productForm(r)
+		if code == "" {
+			var err error
+			product, err = s.Store.CreateProduct(product, s.actor(r))
+			if err != nil {
+				if code = catalogErrorCode(err); code == "" {
+					http.Error(w, "Could not create product", http.StatusInternalServerError)
+					return
+				}
+			}
+		}
+		if code != "" {
+			http.Redirect(w, r, "/admin/products?error="+code, http.StatusSeeOther)
+			return
+		}
+		http.Redirect(w, r, "/admin/products?saved="+product.ID, http.StatusSeeOther)
+		return
+	}
+
+	var saved string
+	if product, ok := s.Store.GetProduct(r.URL.Query().Get("saved")); ok {
+		saved = product.Name
+	}
+	data := struct {
+		Products []models.Product
+		Changes  []models.ProductChange
+		Saved    string
+		Error    string
+	}{
+		Products: s.allProducts(),
+		Changes:  s.productChanges(),
+		Saved:    saved,
+		Error:    catalogMessages[r.URL.Query().Get("error")],
+	}
+	s.render(w, r, "admin-products", data)
+}
+
+// AdminProductUpdateHandler saves an edited product, or archives or restores
+// it, depending on which button was pressed
+func (s *Server) AdminProductUpdateHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Redirect(w, r, "/admin/products", http.StatusSeeOther)
+		return
+	}
+
+	var update func(*models.Product) error
+	switch r.FormValue("action") {
+	case "archive", "restore":
+		archived := r.FormValue("action") == "archive"
+		update = func(p *models.Product) error {
+			p.Archived = archived
+			return nil
+		}
+	default:
+		edited, code := productForm(r)
+		if code != "" {
+			http.Redirect(w, r, "/admin/products?error="+code, http.StatusSeeOther)
+			return
+		}
+		update = func(p *models.Product) error {
+			p.Name, p.Price, p.Stock = edited.Name, edited.Price, edited.Stock
+			return nil
+		}
+	}
+
+	product, err := s.Store.UpdateProduct(r.FormValue("id"), s.actor(r), update)
+	if err != nil {
+		code := catalogErrorCode(err)
+		if code == "" {
+			http.Error(w, "Could not update product", http.StatusInternalServerError)
+			return
+		}
+		http.Redirect(w, r, "/admin/products?error="+code, http.StatusSeeOther)
+		return
+	}
+	http.Redirect(w, r, "/admin/products?saved="+product.ID, http.StatusSeeOther)
+}
+
+// Admin API: /api/v1/admin/...
+
+type apiAdminProduct struct {
+	ID       string       `json:"id"`
+	Name     string       `json:"name"`
+	Price    models.Money `json:"price"`
+	Stock    int          `json:"stock"`
+	Reserved int          `json:"reserved"`
+	Archived bool         `json:"archived"`
+}
+
+// apiProductRequest creates a product, or patches the fields it sets
+type apiProductRequest struct {
+	Name     *string       `json:"name,omitempty"`
+	Price    *models.Money `json:"price,omitempty"`
+	Stock    *int          `json:"stock,omitempty"`
+	Archived *bool         `json:"archived,omitempty"`
+}
+
+type apiProductChange struct {
+	ProductID string               `json:"product_id"`
+	Action    models.ProductAction `json:"action"`
+	Actor     string               `json:"actor"`
+	At        time.Time            `json:"at"`
+	OldName   string               `json:"old_name,omitempty"`
+	NewName   string               `json:"new_name"`
+	OldPrice  *models.Money        `json:"old_price,omitempty"`
+	NewPrice  models.Money         `json:"new_price"`
+	OldStock  int                  `json:"old_stock"`
+	NewStock  int                  `json:"new_stock"`
+}
+
+func toAPIAdminProduct(product models.Product) apiAdminProduct {
+	return apiAdminProduct{
+		ID:       product.ID,
+		Name:     product.Name,
+		Price:    product.Price,
+		Stock:    product.Stock,
+		Reserved: product.Reserved,
+		Archived: product.Archived,
+	}
+}
+
+// apply copies the fields the request sets onto product
+func (req apiProductRequest) apply(product *models.Product) {
+	if req.Name != nil {
+		product.Name = *req.Name
+	}
+	if req.Price != nil {
+		product.Price = *req.Price
+	}
+	if req.Stock != nil {
+		product.Stock = *req.Stock
+	}
+	if req.Archived != nil {
+		product.Archived = *req.Archived
+	}
+}
+
+// writeCatalogError answers a rejected catalog change
+func writeCatalogError(w http.ResponseWriter, err error) {
+	switch code := catalogErrorCode(err); code {
+	case "":
+		writeAPIError(w, http.StatusInternalServerError, "internal_error", "could not save the catalog")
+	case "product_not_found":
+		writeAPIError(w, http.StatusNotFound, code, "no such product")
+	case "unchanged":
+		writeAPIError(w, http.StatusConflict, code, err.Error())
+	default:
+		writeAPIError(w, http.StatusBadRequest, code, err.Error())
+	}
+}
+
+func (s *Server) AdminAPIProductsHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		products := []apiAdminProduct{}
+		for _, product := range s.allProducts() {
+			products = append(products, toAPIAdminProduct(product))
+		}
+		writeJSON(w, http.StatusOK, products)
+
+	case "POST":
+		if !requireJSON(w, r) {
+			return
+		}
+		var req apiProductRequest
+		if !decodeStrictJSON(w, r, &req) {
+			return
+		}
+		var product models.Product
+		req.apply(&product)
+		// New products are always on sale
+		product.Archived = false
+		product, err := s.Store.CreateProduct(product, s.actor(r))
+		if err != nil {
+			writeCatalogError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusCreated, toAPIAdminProduct(product))
+
+	default:
+		methodNotAllowed(w, "GET, POST")
+	}
+}
+
+func (s *Server) AdminAPIProductHandler(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+
+	switch r.Method {
+	case "GET":
+		product, exists := s.Store.GetProduct(id)
+		if !exists {
+			writeAPIError(w, http.StatusNotFound, "product_not_found", "no such product")
+			return
+		}
+		writeJSON(w, http.StatusOK, toAPIAdminProduct(product))
+
+	case "PATCH":
+		if !requireJSON(w, r) {
+			return
+		}
+		var req apiProductRequest
+		if !decodeStrictJSON(w, r, &req) {
+			return
+		}
+		product, err := s.Store.UpdateProduct(id, s.actor(r), func(p *models.Product) error {
+			req.apply(p)
+			return nil
+		})
+		if err != nil {
+			writeCatalogError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, toAPIAdminProduct(product))
+
+	default:
+		methodNotAllowed(w, "GET, PATCH")
+	}
+}
+
+func (s *Server) AdminAPIProductChangesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		methodNotAllowed(w, "GET")
+		return
+	}
+	changes := []apiProductChange{}
+	for _, change := range s.productChanges() {
+		row := apiProductChange{
+			ProductID: change.ProductID,
+			Action:    change.Action,
+			Actor:     change.Actor,
+			At:        change.At,
+			OldName:   change.OldName,
+			NewName:   change.NewName,
+			NewPrice:  change.NewPrice,
+			OldStock:  change.OldStock,
+			NewStock:  change.NewStock,
+		}
+		if change.Action != models.ProductCreated {
+			row.OldPrice = &change.OldPrice
+		}
+		changes = append(changes, row)
+	}
+	writeJSON(w, http.StatusOK, changes)
+}
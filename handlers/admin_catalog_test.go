@@ -0,0 +1,163 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"secure-webapp/models"
+	"strings"
+	"testing"
+)
+
+// adminAPI serves the catalog API the way main.go mounts it
+func adminAPI(s *Server) *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/admin/products", s.AdminAPI(s.AdminAPIProductsHandler))
+	mux.HandleFunc("/api/v1/admin/products/{id}", s.AdminAPI(s.AdminAPIProductHandler))
+	mux.HandleFunc("/api/v1/admin/product-changes", s.AdminAPI(s.AdminAPIProductChangesHandler))
+	return mux
+}
+
+func (p *ctfPlayer) sendJSON(h http.Handler, method, path, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	for _, cookie := range p.cookies {
+		req.AddCookie(cookie)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestCatalogChangesReachShopsAndAreAudited(t *testing.T) {
+	s := newCTFServer()
+	admin, user := signedIn(t, s, "teacher")
+	s.Admins = []string{user.ID}
+
+	if got := admin.post(s.AdminProductsHandler, url.Values{"name": {"Webcam"}, "price": {"49.99"}, "stock": {"5"}}).Header().Get("Location"); got != "/admin/products?saved=5" {
+		t.Fatalf("creating a product redirected to %q", got)
+	}
+	admin.post(s.AdminProductUpdateHandler, url.Values{"id": {"1"}, "name": {"Laptop"}, "price": {"899.99"}, "stock": {"10"}})
+	admin.post(s.AdminProductUpdateHandler, url.Values{"id": {"2"}, "action": {"archive"}})
+	if got := admin.post(s.AdminProductUpdateHandler, url.Values{"id": {"1"}, "name": {"Laptop"}, "price": {"0"}, "stock": {"10"}}).Header().Get("Location"); got != "/admin/products?error=invalid_price" {
+		t.Errorf("a zero price redirected to %q", got)
+	}
+
+	var products []apiProduct
+	json.Unmarshal(admin.get(s.SecureAPIProductsHandler).Body.Bytes(), &products)
+	prices := map[string]string{}
+	for _, product := range products {
+		prices[product.Name] = product.Price.String()
+	}
+	if prices["Laptop"] != "$899.99" || prices["Webcam"] != "$49.99" || prices["Mouse"] != "" {
+		t.Errorf("shop lists %v, want the new Laptop price, the Webcam and no Mouse", prices)
+	}
+
+	shopper := &ctfPlayer{cookies: map[string]*http.Cookie{}}
+	shopper.post(s.SecurePriceAddToCartHandler, url.Values{"product_id": {"2"}, "quantity": {"1"}})
+	shopper.post(s.SecurePriceAddToCartHandler, url.Values{"product_id": {"1"}, "quantity": {"1"}})
+	cart := s.Store.GetCart(shopper.cookies[sessionCookieName].Value, cartSecurePrice)
+	if len(cart.Items) != 1 || cart.Total != models.NewMoney(89999, models.BaseCurrency) {
+		t.Errorf("cart = %+v, want just the Laptop at its new price", cart)
+	}
+
+	changes := s.Store.ListProductChanges()
+	if len(changes) != 3 {
+		t.Fatalf("audit log has %d entries, want 3: %+v", len(changes), changes)
+	}
+	reprice := changes[1]
+	if reprice.Actor != "teacher" || reprice.Action != models.ProductUpdated || reprice.OldPrice.String() != "$999.99" || reprice.NewPrice.String() != "$899.99" {
+		t.Errorf("reprice logged as %+v", reprice)
+	}
+	if changes[2].Action != models.ProductArchived || changes[2].ProductID != "2" {
+		t.Errorf("archive logged as %+v", changes[2])
+	}
+
+	body := admin.get(s.AdminProductsHandler).Body.String()
+	for _, want := range []string{"$999.99 &rarr; $899.99", "archived", "Webcam"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("catalog page doesn't show %q", want)
+		}
+	}
+}
+
+func TestCatalogAPIIsAdminOnly(t *testing.T) {
+	s := newCTFServer()
+	admin, user := signedIn(t, s, "teacher")
+	student, _ := signedIn(t, s, "student")
+	s.Admins = []string{user.ID}
+	api := adminAPI(s)
+	reprice := `{"price": {"amount": 1, "currency": "USD"}}`
+
+	guest := &ctfPlayer{cookies: map[string]*http.Cookie{}}
+	if rec := guest.sendJSON(api, "PATCH", "/api/v1/admin/products/1", reprice); rec.Code != http.StatusUnauthorized {
+		t.Errorf("guest got %d, want 401", rec.Code)
+	}
+	if rec := student.sendJSON(api, "PATCH", "/api/v1/admin/products/1", reprice); rec.Code != http.StatusForbidden {
+		t.Errorf("student got %d, want 403", rec.Code)
+	}
+	if product, _ := s.Store.GetProduct("1"); product.Price.String() != "$999.99" {
+		t.Fatalf("refused requests repriced the Laptop to %s", product.Price)
+	}
+
+	rec := admin.sendJSON(api, "PATCH", "/api/v1/admin/products/1", `{"price": {"amount": 79999, "currency": "USD"}, "archived": true}`)
+	var product apiAdminProduct
+	json.Unmarshal(rec.Body.Bytes(), &product)
+	if rec.Code != http.StatusOK || product.Price.String() != "$799.99" || !product.Archived {
+		t.Errorf("admin PATCH got %d %s", rec.Code, rec.Body)
+	}
+	if rec := admin.sendJSON(api, "PATCH", "/api/v1/admin/products/1", `{"archived": true}`); rec.Code != http.StatusConflict {
+		t.Errorf("a PATCH that changes nothing got %d, want 409", rec.Code)
+	}
+	if rec := admin.sendJSON(api, "POST", "/api/v1/admin/products", `{"name": "Webcam", "price": {"amount": -1, "currency": "USD"}, "stock": 1}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("a negative price got %d, want 400", rec.Code)
+	}
+
+	var changes []apiProductChange
+	json.Unmarshal(admin.sendJSON(api, "GET", "/api/v1/admin/product-changes", "").Body.Bytes(), &changes)
+	if len(changes) != 1 || changes[0].Action != models.ProductArchived || changes[0].Actor != "teacher" || changes[0].OldPrice == nil || changes[0].OldPrice.String() != "$999.99" {
+		t.Errorf("audit log = %+v", changes)
+	}
+}
+
+func TestCheckoutChargesTodaysCatalogPrice(t *testing.T) {
+	s := newCTFServer()
+	admin, user := signedIn(t, s, "teacher")
+	s.Admins = []string{user.ID}
+
+	// Carts filled at the old prices
+	shopper := &ctfPlayer{cookies: map[string]*http.Cookie{}}
+	laptop := url.Values{"product_id": {"1"}, "quantity": {"1"}}
+	shopper.post(s.SecureAddToCartHandler, laptop)
+	shopper.post(s.SecureCouponAddToCartHandler, laptop)
+	shopper.post(s.SecureQuantityAddToCartHandler, laptop)
+	shopper.post(s.SecureCurrencyAddToCartHandler, laptop)
+	mouse := url.Values{"product_id": {"2"}, "quantity": {"1"}}
+	shopper.post(s.SecureAddToCartHandler, mouse)
+
+	admin.post(s.AdminProductUpdateHandler, url.Values{"id": {"1"}, "name": {"Laptop"}, "price": {"1099.99"}, "stock": {"5"}})
+	admin.post(s.AdminProductUpdateHandler, url.Values{"id": {"2"}, "action": {"archive"}})
+
+	// The archived mouse is taken out of the cart before anything is charged
+	if got := shopper.post(s.SecureCheckoutHandler, nil).Header().Get("Location"); got != "/secure-order?error=not_on_sale" {
+		t.Errorf("checking out an archived product redirected to %q", got)
+	}
+	shopper.post(s.SecureCheckoutHandler, nil)
+	shopper.post(s.SecureCouponCheckoutHandler, nil)
+	shopper.post(s.SecureQuantityCheckoutHandler, nil)
+	shopper.post(s.SecureCurrencyCheckoutHandler, nil)
+
+	charged := map[string]string{}
+	for _, order := range s.Store.ListOrders() {
+		charged[order.Shop] = order.Total.String()
+		if len(order.Items) != 1 || order.Items[0].Price.String() != "$1099.99" {
+			t.Errorf("%s order has lines %+v, want the Laptop at its new price", order.Shop, order.Items)
+		}
+	}
+	for _, shop := range []string{cartSecureOrder, cartSecureCoupon, cartSecureQuantity, cartSecureCurrency} {
+		if charged[shop] != "$1099.99" {
+			t.Errorf("%s charged %q, want the new $1099.99", shop, charged[shop])
+		}
+	}
+}
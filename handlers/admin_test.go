@@ -0,0 +1,125 @@
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"secure-webapp/models"
+	"strings"
+	"testing"
+	"time"
+)
+
+// signedIn registers an account and returns a player signed in to it
+func signedIn(t *testing.T, s *Server, username string) (*ctfPlayer, models.User) {
+	t.Helper()
+	user, err := models.NewUser(username, "correct horse")
+	if err == nil {
+		err = s.Store.CreateUser(user)
+	}
+	if err != nil {
+		t.Fatalf("creating %s: %v", username, err)
+	}
+	p := &ctfPlayer{cookies: map[string]*http.Cookie{}}
+	p.post(s.LoginHandler, url.Values{"username": {username}, "password": {"correct horse"}})
+	return p, user
+}
+
+func (p *ctfPlayer) get(handler http.HandlerFunc) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("GET", "/admin", nil)
+	for _, cookie := range p.cookies {
+		req.AddCookie(cookie)
+	}
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+	return rec
+}
+
+func TestAdminDashboardIsAdminOnly(t *testing.T) {
+	s := newCTFServer()
+	admin, user := signedIn(t, s, "teacher")
+	student, _ := signedIn(t, s, "student")
+	s.Admins = []string{user.ID}
+	dashboard := s.Admin(s.AdminHandler)
+
+	guest := &ctfPlayer{cookies: map[string]*http.Cookie{}}
+	if rec := guest.get(dashboard); rec.Code != http.StatusSeeOther || rec.Header().Get("Location") != "/login?next=/admin" {
+		t.Errorf("guest got %d %s, want a redirect to sign in", rec.Code, rec.Header().Get("Location"))
+	}
+	if rec := student.get(dashboard); rec.Code != http.StatusForbidden {
+		t.Errorf("student got %d, want 403", rec.Code)
+	}
+	if rec := admin.get(dashboard); rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "Instructor Dashboard") {
+		t.Errorf("admin got %d", rec.Code)
+	}
+}
+
+func TestAdminDashboardShowsTampering(t *testing.T) {
+	s := newCTFServer()
+	admin, user := signedIn(t, s, "teacher")
+	s.Admins = []string{user.ID}
+	student, _ := signedIn(t, s, "student")
+
+	student.post(s.VulnerablePriceAddToCartHandler, url.Values{"product_id": {"1"}, "quantity": {"1"}, "price": {"0.01"}})
+	student.post(s.VulnerablePriceCheckoutHandler, nil)
+	student.post(s.VulnerablePriceAddToCartHandler, url.Values{"product_id": {"2"}, "quantity": {"1"}, "price": {"29.99"}})
+
+	events := s.activity.recent()
+	if len(events) != 2 || events[0].Kind != "exploit" || events[1].Kind != "client price" || events[1].Visitor != "student" {
+		t.Fatalf("events = %+v, want the client price and then the exploit, and nothing for the honest price", events)
+	}
+
+	body := admin.get(s.Admin(s.AdminHandler)).Body.String()
+	for _, want := range []string{"Laptop sent at $0.01, catalog price $999.99", "student", "vulnerable-price: 1 items"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("dashboard doesn't show %q", want)
+		}
+	}
+}
+
+func TestAdminEventsStreamPushesTampering(t *testing.T) {
+	s := newCTFServer()
+	admin, user := signedIn(t, s, "teacher")
+	s.Admins = []string{user.ID}
+
+	ts := httptest.NewServer(s.Admin(s.AdminEventsHandler))
+	defer ts.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, "GET", ts.URL, nil)
+	for _, cookie := range admin.cookies {
+		req.AddCookie(cookie)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("Content-Type = %q", ct)
+	}
+
+	// The stream opens with the current fragment; a tamper event has to
+	// arrive well before the periodic refresh would send it
+	lines := bufio.NewScanner(resp.Body)
+	waitFor := func(want string) {
+		t.Helper()
+		for lines.Scan() {
+			if strings.Contains(lines.Text(), want) {
+				return
+			}
+		}
+		t.Fatalf("stream ended before %q: %v", want, lines.Err())
+	}
+	waitFor("Nothing suspicious yet.")
+
+	start := time.Now()
+	student := &ctfPlayer{cookies: map[string]*http.Cookie{}}
+	student.post(s.VulnerablePriceAddToCartHandler, url.Values{"product_id": {"2"}, "quantity": {"1"}, "price": {"0.01"}})
+	waitFor("Mouse sent at $0.01")
+	if elapsed := time.Since(start); elapsed >= dashboardRefresh {
+		t.Errorf("event took %v to arrive, want it pushed before the %v refresh", elapsed, dashboardRefresh)
+	}
+}
@@ -0,0 +1,177 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"secure-webapp/models"
+	"sort"
+	"strings"
+	"time"
+)
+
+// The /api/v1 JSON API mirrors the HTML shops. Every failure is answered
+// with the same body, {"error": {"code": "...", "message": "..."}}, and a
+// status code that matches the code.
+
+const maxAPIBodySize = 64 << 10
+
+type apiErrorBody struct {
+	Error apiError `json:"error"`
+}
+
+type apiError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func writeAPIError(w http.ResponseWriter, status int, code, message string) {
+	writeJSON(w, status, apiErrorBody{Error: apiError{Code: code, Message: message}})
+}
+
+// methodNotAllowed answers a request whose method the route doesn't support
+func methodNotAllowed(w http.ResponseWriter, allow string) {
+	w.Header().Set("Allow", allow)
+	writeAPIError(w, http.StatusMethodNotAllowed, "method_not_allowed", "method not allowed")
+}
+
+// APINotFoundHandler answers every /api/ path no other route matches, so API
+// clients never get the HTML home page back
+func (s *Server) APINotFoundHandler(w http.ResponseWriter, r *http.Request) {
+	writeAPIError(w, http.StatusNotFound, "not_found", "no such endpoint")
+}
+
+// decodeJSON reads a JSON request body into v. An empty body leaves v
+// untouched.
+func decodeJSON(w http.ResponseWriter, r *http.Request, v any) bool {
+	err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxAPIBodySize)).Decode(v)
+	if err != nil && !errors.Is(err, io.EOF) {
+		writeAPIError(w, http.StatusBadRequest, "invalid_json", "request body is not valid JSON for this endpoint")
+		return false
+	}
+	return true
+}
+
+// decodeStrictJSON is decodeJSON for the secure API: fields the target type
+// doesn't declare and anything after the JSON value are rejected instead of
+// silently ignored
+func decodeStrictJSON(w http.ResponseWriter, r *http.Request, v any) bool {
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxAPIBodySize))
+	dec.DisallowUnknownFields()
+	err := dec.Decode(v)
+	if errors.Is(err, io.EOF) {
+		return true
+	}
+	if err == nil && dec.More() {
+		err = errors.New("json: unexpected data after the request body")
+	}
+	if err != nil {
+		code := "invalid_json"
+		if strings.HasPrefix(err.Error(), "json: unknown field") {
+			code = "unknown_field"
+		}
+		writeAPIError(w, http.StatusBadRequest, code, strings.TrimPrefix(err.Error(), "json: "))
+		return false
+	}
+	return true
+}
+
+// requireJSON rejects state-changing requests that aren't declared as JSON.
+// Browsers can't send that content type cross-site without a CORS
+// preflight, which this API never grants, so it doubles as CSRF protection
+// for the cookie-authenticated secure API.
+func requireJSON(w http.ResponseWriter, r *http.Request) bool {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "application/json" {
+		writeAPIError(w, http.StatusUnsupportedMediaType, "unsupported_media_type", "requests must be sent as application/json")
+		return false
+	}
+	if !sameOrigin(r) {
+		writeAPIError(w, http.StatusForbidden, "cross_origin", "cross-origin request blocked")
+		return false
+	}
+	return true
+}
+
+type apiProduct struct {
+	ID        string       `json:"id"`
+	Name      string       `json:"name"`
+	Price     models.Money `json:"price"`
+	Available int          `json:"available"`
+}
+
+type apiCartItem struct {
+	ProductID string       `json:"product_id"`
+	Quantity  int          `json:"quantity"`
+	Price     models.Money `json:"price"`
+}
+
+type apiCart struct {
+	Items []apiCartItem `json:"items"`
+	Total models.Money  `json:"total"`
+}
+
+type apiStatusChange struct {
+	From  models.OrderStatus `json:"from,omitempty"`
+	To    models.OrderStatus `json:"to"`
+	At    time.Time          `json:"at"`
+	Actor string             `json:"actor"`
+}
+
+type apiOrder struct {
+	ID        string             `json:"id"`
+	Status    models.OrderStatus `json:"status"`
+	Items     []apiCartItem      `json:"items"`
+	Total     models.Money       `json:"total"`
+	PaymentID string             `json:"payment_id,omitempty"`
+	Created   time.Time          `json:"created"`
+	History   []apiStatusChange  `json:"history"`
+}
+
+func toAPIItems(items []models.CartItem) []apiCartItem {
+	out := make([]apiCartItem, len(items))
+	for i, item := range items {
+		out[i] = apiCartItem{ProductID: item.ProductID, Quantity: item.Quantity, Price: item.Price}
+	}
+	return out
+}
+
+func toAPICart(cart models.Cart) apiCart {
+	return apiCart{Items: toAPIItems(cart.Items), Total: cart.Total}
+}
+
+func toAPIOrder(order models.Order) apiOrder {
+	history := make([]apiStatusChange, len(order.History))
+	for i, change := range order.History {
+		history[i] = apiStatusChange{From: change.From, To: change.To, At: change.At, Actor: change.Actor}
+	}
+	return apiOrder{
+		ID:        order.ID,
+		Status:    order.Status,
+		Items:     toAPIItems(order.Items),
+		Total:     order.Total,
+		PaymentID: order.PaymentID,
+		Created:   order.Timestamp,
+		History:   history,
+	}
+}
+
+func (s *Server) apiProducts() []apiProduct {
+	products := []apiProduct{}
+	for _, product := range s.catalog() {
+		products = append(products, apiProduct{ID: product.ID, Name: product.Name, Price: product.Price, Available: product.Available()})
+	}
+	sort.Slice(products, func(i, j int) bool { return products[i].ID < products[j].ID })
+	return products
+}
+
+// apiOrders lists the orders a user placed through one of the APIs
+func (s *Server) apiOrders(shop, userID string) []apiOrder {
+	orders := []apiOrder{}
+	for _, order := range s.shopOrdersForUser(shop, userID) {
+		orders = append(orders, toAPIOrder(order))
+	}
+	return orders
+}
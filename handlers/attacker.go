@@ -0,0 +1,52 @@
+package handlers
+
+import (
+	"net/http"
+	"secure-webapp/scenario"
+)
+
+// csrfScenario aims the attacker page at the order shop without CSRF
+// protection and at the one with it
+func (s *Server) csrfScenario() scenario.Definition {
+	return scenario.Definition{
+		Title:   "Cross-Site Request Forgery",
+		Group:   "Sessions & Access Control",
+		Summary: "Another site can make the visitor's browser check out in the order shop, cookies and all.",
+		Level:   scenario.Intermediate,
+		Clues: []string{
+			"Open the attacker page while you have items in the vulnerable order shop.",
+			"What in a form post proves it came from the shop's own page?",
+		},
+		// Both variants are the same attacker page aimed at a different shop
+		VulnerableSet: scenario.HandlerSet{
+			Summary: "Forged checkout against the unprotected order shop",
+			Landing: "/attacker",
+			Prefix:  "/attacker",
+			Routes:  []scenario.Route{{Path: "", Handler: s.AttackerHandler}},
+		},
+		SecureSet: scenario.HandlerSet{
+			Summary: "Session-bound tokens and Origin checks",
+			Landing: "/attacker?target=secure",
+		},
+	}
+}
+
+// AttackerHandler plays a malicious page the victim is lured to while signed
+// in to the shop. It silently adds a laptop to the victim's cart and checks
+// out, first against the vulnerable order shop and, with ?target=secure,
+// against the secure one, where both requests are refused for lacking a
+// CSRF token.
+func (s *Server) AttackerHandler(w http.ResponseWriter, r *http.Request) {
+	target := "vulnerable"
+	if r.URL.Query().Get("target") == "secure" {
+		target = "secure"
+	}
+
+	data := struct {
+		Target string
+	}{
+		Target: target,
+	}
+
+	s.render(w, r, "attacker", data)
+}
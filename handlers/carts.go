@@ -0,0 +1,37 @@
+package handlers
+
+// Cart scenarios. Every shop keeps its own cart per session, named after the
+// shop's path, so an item put in one shop's cart - at a tampered price, say -
+// can only ever be checked out through that same shop.
+const (
+	cartVulnerablePrice    = "vulnerable-price"
+	cartSecurePrice        = "secure-price"
+	cartVulnerableOrder    = "vulnerable-order"
+	cartSecureOrder        = "secure-order"
+	cartVulnerableCurrency = "vulnerable-currency"
+	cartSecureCurrency     = "secure-currency"
+	cartVulnerableQuantity = "vulnerable-quantity"
+	cartSecureQuantity     = "secure-quantity"
+	cartVulnerableCoupon   = "vulnerable-coupon"
+	cartSecureCoupon       = "secure-coupon"
+	cartVulnerableAPI      = "vulnerable-api"
+	cartSecureAPI          = "secure-api"
+)
+
+// Orders are tagged with the scenario that placed them, so a vulnerable shop
+// can be kept to its own orders. The shops above use their cart's name; these
+// ones have no cart.
+const (
+	shopVulnerableRace = "vulnerable-race"
+	shopSecureRace     = "secure-race"
+	shopVulnerableIDOR = "vulnerable-idor"
+	shopSecureIDOR     = "secure-idor"
+)
+
+// shopCart picks the scenario for code shared by a vulnerable/secure pair
+func shopCart(shop, vulnerable, secure string) string {
+	if shop == "secure" {
+		return secure
+	}
+	return vulnerable
+}
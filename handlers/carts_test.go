@@ -0,0 +1,149 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"secure-webapp/gateway"
+	"secure-webapp/models"
+	"strings"
+	"testing"
+)
+
+// cartClient sends requests to handlers directly with one session's cookie
+type cartClient struct {
+	cookies []*http.Cookie
+}
+
+func (c cartClient) send(handler http.HandlerFunc, contentType, body string) {
+	c.serve(handler, "/", contentType, body)
+}
+
+func (c cartClient) serve(h http.Handler, path, contentType, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("POST", path, strings.NewReader(body))
+	req.Header.Set("Content-Type", contentType)
+	for _, cookie := range c.cookies {
+		req.AddCookie(cookie)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func (c cartClient) post(handler http.HandlerFunc, form url.Values) {
+	c.send(handler, "application/x-www-form-urlencoded", form.Encode())
+}
+
+// TestTamperedCartNeverReachesSecureCheckout fills a cart through each
+// vulnerable shop with a manipulated price or quantity, then runs each
+// secure checkout in the same session. None of them may see those items.
+// Orders the vulnerable shops placed can't be paid or cancelled by the
+// secure ones either.
+func TestTamperedCartNeverReachesSecureCheckout(t *testing.T) {
+	tampered := []struct {
+		scenario string
+		add      func(*Server, cartClient)
+	}{
+		{cartVulnerablePrice, func(s *Server, c cartClient) {
+			c.post(s.VulnerablePriceAddToCartHandler, url.Values{"product_id": {"1"}, "quantity": {"1"}, "price": {"0.01"}})
+		}},
+		{cartVulnerableQuantity, func(s *Server, c cartClient) {
+			c.post(s.VulnerableQuantityAddToCartHandler, url.Values{"product_id": {"1"}, "quantity": {"-5"}})
+		}},
+		{cartVulnerableAPI, func(s *Server, c cartClient) {
+			c.send(s.VulnerableAPICartItemsHandler, "application/json", `{"product_id": "1", "quantity": 1, "price": {"amount": 1, "currency": "USD"}}`)
+		}},
+	}
+
+	checkouts := []struct {
+		scenario string
+		checkout func(*Server, cartClient)
+	}{
+		{cartSecurePrice, func(s *Server, c cartClient) { c.post(s.SecurePriceCheckoutHandler, nil) }},
+		{cartSecureOrder, func(s *Server, c cartClient) { c.post(s.SecureCheckoutHandler, nil) }},
+		{cartSecureCurrency, func(s *Server, c cartClient) { c.post(s.SecureCurrencyCheckoutHandler, nil) }},
+		{cartSecureQuantity, func(s *Server, c cartClient) { c.post(s.SecureQuantityCheckoutHandler, nil) }},
+		{cartSecureCoupon, func(s *Server, c cartClient) { c.post(s.SecureCouponCheckoutHandler, nil) }},
+		{cartSecureAPI, func(s *Server, c cartClient) { c.send(s.SecureAPIOrdersHandler, "application/json", "") }},
+	}
+
+	for _, tc := range tampered {
+		for _, secure := range checkouts {
+			store := models.NewMemoryStore()
+			models.InitStores(store)
+			s := NewServer(store)
+
+			rec := httptest.NewRecorder()
+			sessionID := s.getOrCreateSession(rec, httptest.NewRequest("GET", "/", nil))
+			client := cartClient{cookies: rec.Result().Cookies()}
+
+			tc.add(s, client)
+			if cart := store.GetCart(sessionID, tc.scenario); len(cart.Items) == 0 {
+				t.Fatalf("%s: tampered item didn't reach the vulnerable cart", tc.scenario)
+			}
+			if cart := store.GetCart(sessionID, secure.scenario); len(cart.Items) != 0 {
+				t.Errorf("%s cart shows items added in %s: %+v", secure.scenario, tc.scenario, cart.Items)
+			}
+
+			secure.checkout(s, client)
+			if orders := store.ListOrders(); len(orders) != 0 {
+				t.Errorf("%s checked out the cart filled in %s: %+v", secure.scenario, tc.scenario, orders[0])
+			}
+		}
+	}
+
+	placed := []struct {
+		scenario string
+		place    func(*Server, cartClient)
+	}{
+		{cartVulnerableOrder, func(s *Server, c cartClient) {
+			c.post(s.VulnerableAddToCartHandler, url.Values{"product_id": {"1"}, "quantity": {"1"}})
+			c.post(s.VulnerableCheckoutHandler, nil)
+		}},
+		{cartVulnerableAPI, func(s *Server, c cartClient) {
+			c.send(s.VulnerableAPICartItemsHandler, "application/json", `{"product_id": "1", "quantity": 1, "price": {"amount": 1, "currency": "USD"}}`)
+			c.send(s.VulnerableAPIOrdersHandler, "application/json", "")
+		}},
+	}
+
+	payments := []struct {
+		name string
+		pay  func(*Server, cartClient, string) int
+	}{
+		{"secure pay", func(s *Server, c cartClient, id string) int {
+			return c.serve(http.HandlerFunc(s.SecurePayHandler), "/", "application/x-www-form-urlencoded", url.Values{"order_id": {id}, "card_number": {gateway.CardApproveBasic}}.Encode()).Code
+		}},
+		{"secure cancel", func(s *Server, c cartClient, id string) int {
+			return c.serve(http.HandlerFunc(s.SecureCancelHandler), "/", "application/x-www-form-urlencoded", url.Values{"order_id": {id}}.Encode()).Code
+		}},
+		{"secure API pay", func(s *Server, c cartClient, id string) int {
+			mux := http.NewServeMux()
+			mux.HandleFunc("/api/v1/secure/orders/{id}/pay", s.SecureAPIPayHandler)
+			return c.serve(mux, "/api/v1/secure/orders/"+id+"/pay", "application/json", `{"card_number": "`+gateway.CardApproveBasic+`"}`).Code
+		}},
+	}
+
+	for _, tc := range placed {
+		for _, secure := range payments {
+			store := models.NewMemoryStore()
+			models.InitStores(store)
+			s := NewServer(store)
+
+			rec := httptest.NewRecorder()
+			s.getOrCreateSession(rec, httptest.NewRequest("GET", "/", nil))
+			client := cartClient{cookies: rec.Result().Cookies()}
+
+			tc.place(s, client)
+			orders := store.ListOrders()
+			if len(orders) != 1 || orders[0].Shop != tc.scenario {
+				t.Fatalf("%s placed %+v, want one order", tc.scenario, orders)
+			}
+			if code := secure.pay(s, client, orders[0].ID); code != http.StatusNotFound {
+				t.Errorf("%s on a %s order got %d, want 404", secure.name, tc.scenario, code)
+			}
+			if order, _ := store.GetOrder(orders[0].ID); order.Status != orders[0].Status || order.PaymentID != "" {
+				t.Errorf("%s moved a %s order to %s with payment %q", secure.name, tc.scenario, order.Status, order.PaymentID)
+			}
+		}
+	}
+}
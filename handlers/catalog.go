@@ -0,0 +1,39 @@
+package handlers
+
+import "secure-webapp/models"
+
+// Shops read the catalog on every request, so an admin's edits show up
+// straight away. Archived products stay in the store for the orders that
+// reference them but are left out here.
+
+// catalog returns the products on sale, keyed by ID
+func (s *Server) catalog() map[string]models.Product {
+	products := s.Store.ListProducts()
+	for id, product := range products {
+		if product.Archived {
+			delete(products, id)
+		}
+	}
+	return products
+}
+
+// productOnSale looks up a product for a new purchase
+func (s *Server) productOnSale(id string) (models.Product, bool) {
+	product, exists := s.Store.GetProduct(id)
+	return product, exists && !product.Archived
+}
+
+// atCatalogPrices reprices cart lines at checkout, since the catalog may have
+// changed while they sat in the cart. The lines still on sale are returned;
+// ok is false if any product was archived meanwhile.
+func (s *Server) atCatalogPrices(items []models.CartItem) (priced []models.CartItem, ok bool) {
+	priced = make([]models.CartItem, 0, len(items))
+	for _, item := range items {
+		product, exists := s.productOnSale(item.ProductID)
+		if !exists {
+			continue
+		}
+		priced = append(priced, models.CartItem{ProductID: item.ProductID, Quantity: item.Quantity, Price: product.Price})
+	}
+	return priced, len(priced) == len(items)
+}
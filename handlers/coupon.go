@@ -0,0 +1,104 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"secure-webapp/models"
+	"sort"
+	"strings"
+)
+
+// couponErrors maps coupon failures to the codes the coupon shops redirect
+// with
+var couponErrors = []struct {
+	err  error
+	code string
+}{
+	{models.ErrCouponUnknown, "coupon_unknown"},
+	{models.ErrCouponExpired, "coupon_expired"},
+	{models.ErrCouponMinSpend, "coupon_min_spend"},
+	{models.ErrCouponNotApplicable, "coupon_not_applicable"},
+	{models.ErrCouponNotStackable, "coupon_not_stackable"},
+	{models.ErrCouponAlreadyUsed, "coupon_already_used"},
+	{models.ErrCouponLimit, "coupon_limit"},
+}
+
+func couponErrorCode(err error) string {
+	for _, known := range couponErrors {
+		if errors.Is(err, known.err) {
+			return known.code
+		}
+	}
+	return "coupon_not_applicable"
+}
+
+// normalizeCouponCode makes codes case-insensitive
+func normalizeCouponCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// couponListing is a coupon as shown on the shop page
+type couponListing struct {
+	models.Coupon
+	Used int
+}
+
+// couponListings lists the promotions with how often each was used in the
+// shop whose ledger is given
+func (s *Server) couponListings(ledger string) []couponListing {
+	coupons := s.Store.ListCoupons()
+	sort.Slice(coupons, func(i, j int) bool { return coupons[i].Code < coupons[j].Code })
+	listings := make([]couponListing, len(coupons))
+	for i, coupon := range coupons {
+		used, _ := s.Store.CouponUsage(ledger, coupon.Code, "")
+		listings[i] = couponListing{Coupon: coupon, Used: used}
+	}
+	return listings
+}
+
+// renderCouponShop shows the catalog, the promotions and the cart for both
+// coupon shops
+func (s *Server) renderCouponShop(w http.ResponseWriter, r *http.Request, shop, title, banner string) {
+	sessionID := s.getOrCreateSession(w, r)
+	ledger := shopCart(shop, cartVulnerableCoupon, cartSecureCoupon)
+	cart := s.Store.GetCart(sessionID, ledger)
+	subtotal, _ := models.SumItems(cart.Items)
+
+	data := struct {
+		Shop     string
+		Title    string
+		Banner   string
+		Products map[string]models.Product
+		Coupons  []couponListing
+		Cart     models.Cart
+		Subtotal models.Money
+		Error    string
+	}{
+		Shop:     shop,
+		Title:    title,
+		Banner:   banner,
+		Products: s.catalog(),
+		Coupons:  s.couponListings(ledger),
+		Cart:     cart,
+		Subtotal: subtotal,
+		Error:    shopMessages[r.URL.Query().Get("error")],
+	}
+
+	s.render(w, r, "coupon-shop", data)
+}
+
+// renderCouponOrder shows a placed order for both coupon shops. Only the
+// vulnerable one offers to apply a coupon afterwards.
+func (s *Server) renderCouponOrder(w http.ResponseWriter, r *http.Request, order models.Order, shop string) {
+	data := struct {
+		Order models.Order
+		Shop  string
+		Error string
+	}{
+		Order: order,
+		Shop:  shop,
+		Error: shopMessages[r.URL.Query().Get("error")],
+	}
+
+	s.render(w, r, "coupon-order", data)
+}
@@ -0,0 +1,58 @@
+package handlers
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestVulnerableCouponShopKeepsItsOwnLedger(t *testing.T) {
+	s := newCTFServer()
+	secure := placeSecureOrder(t, s)
+
+	// LAUNCH50 may be used once; the vulnerable shop ignores that twice over
+	attacker := &ctfPlayer{cookies: map[string]*http.Cookie{}}
+	for range 2 {
+		attacker.post(s.VulnerableCouponAddToCartHandler, url.Values{"product_id": {"2"}, "quantity": {"1"}})
+		attacker.post(s.VulnerableCouponApplyHandler, url.Values{"code": {"LAUNCH50"}})
+		attacker.post(s.VulnerableCouponCheckoutHandler, nil)
+	}
+	if rec := attacker.post(s.VulnerableCouponOrderApplyHandler, url.Values{"order_id": {secure.ID}, "code": {"TAKE5"}}); rec.Code != http.StatusNotFound {
+		t.Errorf("discounting a secure order got %d, want 404", rec.Code)
+	}
+	assertUntouched(t, s, secure)
+
+	shopper := &ctfPlayer{cookies: map[string]*http.Cookie{}}
+	shopper.post(s.SecureCouponAddToCartHandler, url.Values{"product_id": {"2"}, "quantity": {"1"}})
+	if got := shopper.post(s.SecureCouponApplyHandler, url.Values{"code": {"LAUNCH50"}}).Header().Get("Location"); got != "/secure-coupon" {
+		t.Fatalf("applying LAUNCH50 in the secure shop redirected to %q", got)
+	}
+	if got := shopper.post(s.SecureCouponCheckoutHandler, nil).Header().Get("Location"); !strings.HasPrefix(got, "/secure-coupon/order?") {
+		t.Errorf("secure checkout with LAUNCH50 redirected to %q, want its one use still available", got)
+	}
+}
+
+func TestPerCustomerCouponsNeedAnAccount(t *testing.T) {
+	s := newCTFServer()
+	anonymous := &ctfPlayer{cookies: map[string]*http.Cookie{}}
+	anonymous.post(s.SecureCouponAddToCartHandler, url.Values{"product_id": {"2"}, "quantity": {"1"}})
+	if got := anonymous.post(s.SecureCouponApplyHandler, url.Values{"code": {"TAKE5"}}).Header().Get("Location"); got != "/secure-coupon?error=coupon_sign_in" {
+		t.Errorf("an anonymous TAKE5 redirected to %q, want a sign-in prompt", got)
+	}
+
+	customer, _ := signedIn(t, s, "alice")
+	customer.post(s.SecureCouponAddToCartHandler, url.Values{"product_id": {"2"}, "quantity": {"1"}})
+	customer.post(s.SecureCouponApplyHandler, url.Values{"code": {"TAKE5"}})
+	if got := customer.post(s.SecureCouponCheckoutHandler, nil).Header().Get("Location"); !strings.HasPrefix(got, "/secure-coupon/order?") {
+		t.Fatalf("signed-in checkout with TAKE5 redirected to %q", got)
+	}
+
+	// A new browser is a new session, but the same account
+	again := &ctfPlayer{cookies: map[string]*http.Cookie{}}
+	again.post(s.LoginHandler, url.Values{"username": {"alice"}, "password": {"correct horse"}})
+	again.post(s.SecureCouponAddToCartHandler, url.Values{"product_id": {"2"}, "quantity": {"1"}})
+	if got := again.post(s.SecureCouponApplyHandler, url.Values{"code": {"TAKE5"}}).Header().Get("Location"); got != "/secure-coupon?error=coupon_limit" {
+		t.Errorf("TAKE5 a second time from a fresh session redirected to %q, want coupon_limit", got)
+	}
+}
@@ -0,0 +1,340 @@
+package handlers
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"html/template"
+	"net/http"
+	"net/url"
+	"secure-webapp/models"
+	"strings"
+	"time"
+)
+
+// ctfChallenge is a flag hidden behind the exploit of one vulnerable shop
+type ctfChallenge struct {
+	ID string
+	// Scenario is the registered pair the challenge belongs to; its
+	// difficulty decides the points
+	Scenario string
+	Goal     string
+}
+
+var ctfChallenges = []ctfChallenge{
+	{"price", "Price Manipulation", "Check out for less than the catalog price"},
+	{"quantity", "Quantity Tampering", "Get charged nothing, or less than the items cost"},
+	{"currency", "Currency Conversion", "Pay less than the order is worth at the real exchange rate"},
+	{"coupon", "Coupon Abuse", "Take more off than the coupon rules allow"},
+	{"order", "Order Processing", "Complete an order without paying for it"},
+	{"race", "Race Conditions", "Buy stock that had already sold out"},
+	{"webhook", "Webhook Forgery", "Get an order marked paid by a webhook you sent"},
+	{"session", "Session Fixation", "Use a demo session someone else signed in to"},
+	{"idor", "Insecure Direct Object Reference", "Open an order placed by another customer"},
+	{"csrf", "Cross-Site Request Forgery", "Get an order placed from the attacker page"},
+	{"api", "JSON API", "Place an API order below the catalog price"},
+	{"mass-assignment", "Mass Assignment", "Create an order with a status or total you chose"},
+}
+
+// ctfMessages maps the codes the CTF pages redirect with to their text
+var ctfMessages = map[string]string{
+	"team_unknown":   "There is no team with that name.",
+	"team_password":  "Wrong team password.",
+	"team_taken":     "A team with that name already exists.",
+	"team_invalid":   models.ErrInvalidTeamName.Error() + ".",
+	"weak_password":  models.ErrWeakPassword.Error() + ".",
+	"no_team":        "Join a team before submitting flags.",
+	"wrong_flag":     "That is not one of your team's flags.",
+	"already_solved": "Your team has already scored that flag.",
+}
+
+func (s *Server) ctfEnabled() bool {
+	return len(s.CTFSecret) > 0
+}
+
+func ctfChallengeByID(id string) (ctfChallenge, bool) {
+	for _, c := range ctfChallenges {
+		if c.ID == id {
+			return c, true
+		}
+	}
+	return ctfChallenge{}, false
+}
+
+// points is worth 100 per difficulty level of the challenge's scenario
+func (s *Server) points(c ctfChallenge) int {
+	if sc, ok := s.Scenarios.Get(c.Scenario); ok {
+		return 100 * int(sc.Difficulty())
+	}
+	return 100
+}
+
+// flagFor derives a team's flag for a challenge. Flags differ per team, so
+// one team's flag scores nothing for another.
+func (s *Server) flagFor(teamID, challengeID string) string {
+	mac := hmac.New(sha256.New, s.CTFSecret)
+	mac.Write([]byte(teamID + "/" + challengeID))
+	return "FLAG{" + challengeID + "-" + hex.EncodeToString(mac.Sum(nil)[:12]) + "}"
+}
+
+// captureFlag gives the visitor's team the challenge's flag, shown on the
+// next page the visitor loads; visitors without a team get nothing
+func (s *Server) captureFlag(r *http.Request, challengeID string) {
+	if !s.ctfEnabled() {
+		return
+	}
+	session, ok := s.currentSession(r)
+	if !ok || session.TeamID == "" {
+		return
+	}
+	for _, id := range session.NewFlags {
+		if id == challengeID {
+			return
+		}
+	}
+	session.NewFlags = append(append([]string(nil), session.NewFlags...), challengeID)
+	s.Store.SetSession(session)
+}
+
+type capturedFlag struct {
+	Goal string
+	Flag string
+}
+
+// ctfFuncs provides {{ctfEnabled}} and {{newFlags}}, which hands the layout
+// the flags captured since the last page and forgets them
+func (s *Server) ctfFuncs(r *http.Request) template.FuncMap {
+	return template.FuncMap{
+		"ctfEnabled": s.ctfEnabled,
+		"newFlags": func() []capturedFlag {
+			session, ok := s.currentSession(r)
+			if !ok || len(session.NewFlags) == 0 {
+				return nil
+			}
+			var flags []capturedFlag
+			for _, id := range session.NewFlags {
+				if c, ok := ctfChallengeByID(id); ok && session.TeamID != "" {
+					flags = append(flags, capturedFlag{Goal: c.Goal, Flag: s.flagFor(session.TeamID, id)})
+				}
+			}
+			session.NewFlags = nil
+			s.Store.SetSession(session)
+			return flags
+		},
+	}
+}
+
+// catalogTotal prices items at the current catalog prices
+func (s *Server) catalogTotal(items []models.CartItem) (models.Money, error) {
+	priced := make([]models.CartItem, len(items))
+	for i, item := range items {
+		product, exists := s.Store.GetProduct(item.ProductID)
+		if !exists {
+			return models.Money{}, models.ErrInvalidAmount
+		}
+		priced[i] = models.CartItem{ProductID: item.ProductID, Quantity: item.Quantity, Price: product.Price}
+	}
+	return models.SumItems(priced)
+}
+
+// underpriced reports whether total is less than items cost at catalog
+// prices, or whether the items can't honestly be priced at all
+func (s *Server) underpriced(items []models.CartItem, total models.Money) bool {
+	for _, item := range items {
+		if item.Quantity < 1 {
+			return true
+		}
+	}
+	fair, err := s.catalogTotal(items)
+	return err != nil || total.Cmp(fair) < 0
+}
+
+// couponRulesBroken reports whether a vulnerable coupon cart breaks the
+// stacking rules or the redemption limits the secure shop enforces, counted
+// in the vulnerable shop's own ledger, or took more off
+func (s *Server) couponRulesBroken(cart models.Cart, userID string) bool {
+	coupons := make([]models.Coupon, 0, len(cart.Coupons))
+	for _, code := range cart.Coupons {
+		coupon, exists := s.Store.GetCoupon(code)
+		if !exists {
+			return true
+		}
+		used, usedByUser := s.Store.CouponUsage(cartVulnerableCoupon, code, userID)
+		if (coupon.GlobalLimit > 0 && used >= coupon.GlobalLimit) || (coupon.PerUserLimit > 0 && usedByUser >= coupon.PerUserLimit) {
+			return true
+		}
+		coupons = append(coupons, coupon)
+	}
+	_, _, fair, err := models.PriceCart(cart.Items, coupons, time.Now())
+	return err != nil || cart.Total.Cmp(fair) < 0
+}
+
+// fromAttackerPage reports whether a form was posted from another site, or
+// from the demo's own attacker page
+func fromAttackerPage(r *http.Request) bool {
+	if !sameOrigin(r) {
+		return true
+	}
+	referer, err := url.Parse(r.Referer())
+	return err == nil && referer.Path == "/attacker"
+}
+
+func (s *Server) CTFHandler(w http.ResponseWriter, r *http.Request) {
+	sessionID := s.getOrCreateSession(w, r)
+	session, _ := s.Store.GetSession(sessionID)
+	team, inTeam := s.Store.GetTeam(session.TeamID)
+
+	type challengeRow struct {
+		ctfChallenge
+		Points  int
+		Landing string
+		Solved  bool
+	}
+	solved := map[string]bool{}
+	score := 0
+	for _, solve := range s.Store.ListSolves() {
+		if inTeam && solve.TeamID == team.ID {
+			solved[solve.Challenge] = true
+			score += solve.Points
+		}
+	}
+	rows := make([]challengeRow, len(ctfChallenges))
+	for i, c := range ctfChallenges {
+		rows[i] = challengeRow{ctfChallenge: c, Points: s.points(c), Solved: solved[c.ID]}
+		if sc, ok := s.Scenarios.Get(c.Scenario); ok {
+			rows[i].Landing = sc.Vulnerable().Landing
+		}
+	}
+
+	var solvedGoal string
+	if c, ok := ctfChallengeByID(r.URL.Query().Get("solved")); ok {
+		solvedGoal = c.Goal
+	}
+
+	data := struct {
+		Team       models.Team
+		InTeam     bool
+		Score      int
+		Challenges []challengeRow
+		Solved     string
+		Error      string
+	}{
+		Team:       team,
+		InTeam:     inTeam,
+		Score:      score,
+		Challenges: rows,
+		Solved:     solvedGoal,
+		Error:      ctfMessages[r.URL.Query().Get("error")],
+	}
+
+	s.render(w, r, "ctf", data)
+}
+
+// CTFJoinHandler creates a team or joins an existing one. Changing teams
+// rotates the session like signing in does.
+func (s *Server) CTFJoinHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Redirect(w, r, "/ctf", http.StatusSeeOther)
+		return
+	}
+
+	name := strings.TrimSpace(r.FormValue("team"))
+	password := r.FormValue("password")
+
+	var team models.Team
+	if r.FormValue("create") != "" {
+		var err error
+		team, err = models.NewTeam(name, password)
+		if err == nil {
+			err = s.Store.CreateTeam(team)
+		}
+		switch err {
+		case nil:
+		case models.ErrInvalidTeamName:
+			http.Redirect(w, r, "/ctf?error=team_invalid", http.StatusSeeOther)
+			return
+		case models.ErrWeakPassword:
+			http.Redirect(w, r, "/ctf?error=weak_password", http.StatusSeeOther)
+			return
+		case models.ErrTeamNameTaken:
+			http.Redirect(w, r, "/ctf?error=team_taken", http.StatusSeeOther)
+			return
+		default:
+			http.Error(w, "Could not create team", http.StatusInternalServerError)
+			return
+		}
+	} else {
+		var exists bool
+		team, exists = s.Store.GetTeamByName(name)
+		if !exists {
+			http.Redirect(w, r, "/ctf?error=team_unknown", http.StatusSeeOther)
+			return
+		}
+		if !models.CheckPassword(team.PasswordHash, password) {
+			http.Redirect(w, r, "/ctf?error=team_password", http.StatusSeeOther)
+			return
+		}
+	}
+
+	s.rotateSession(w, r, func(session *models.Session) {
+		session.TeamID = team.ID
+		session.NewFlags = nil
+	})
+	http.Redirect(w, r, "/ctf", http.StatusSeeOther)
+}
+
+// CTFSubmitHandler scores a flag for the visitor's team
+func (s *Server) CTFSubmitHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Redirect(w, r, "/ctf", http.StatusSeeOther)
+		return
+	}
+
+	sessionID := s.getOrCreateSession(w, r)
+	session, _ := s.Store.GetSession(sessionID)
+	team, inTeam := s.Store.GetTeam(session.TeamID)
+	if !inTeam {
+		http.Redirect(w, r, "/ctf?error=no_team", http.StatusSeeOther)
+		return
+	}
+
+	submitted := []byte(strings.TrimSpace(r.FormValue("flag")))
+	for _, c := range ctfChallenges {
+		if !hmac.Equal(submitted, []byte(s.flagFor(team.ID, c.ID))) {
+			continue
+		}
+		if !s.Store.RecordSolve(models.Solve{TeamID: team.ID, Challenge: c.ID, Points: s.points(c), At: time.Now()}) {
+			http.Redirect(w, r, "/ctf?error=already_solved", http.StatusSeeOther)
+			return
+		}
+		http.Redirect(w, r, "/ctf?solved="+c.ID, http.StatusSeeOther)
+		return
+	}
+
+	http.Redirect(w, r, "/ctf?error=wrong_flag", http.StatusSeeOther)
+}
+
+func (s *Server) CTFLeaderboardHandler(w http.ResponseWriter, r *http.Request) {
+	sessionID := s.getOrCreateSession(w, r)
+	session, _ := s.Store.GetSession(sessionID)
+
+	type rankedScore struct {
+		Rank int
+		models.TeamScore
+	}
+	data := struct {
+		Scores []rankedScore
+		TeamID string
+		Total  int
+	}{
+		TeamID: session.TeamID,
+	}
+	for i, score := range models.Leaderboard(s.Store.ListTeams(), s.Store.ListSolves()) {
+		data.Scores = append(data.Scores, rankedScore{Rank: i + 1, TeamScore: score})
+	}
+	for _, c := range ctfChallenges {
+		data.Total += s.points(c)
+	}
+
+	s.render(w, r, "ctf-leaderboard", data)
+}
@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"secure-webapp/models"
+	"strings"
+	"testing"
+	"time"
+)
+
+// ctfPlayer posts forms to handlers directly, keeping the cookies each
+// response sets the way a browser would
+type ctfPlayer struct {
+	cookies map[string]*http.Cookie
+}
+
+func (p *ctfPlayer) post(handler http.HandlerFunc, form url.Values) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("POST", "/", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	for _, cookie := range p.cookies {
+		req.AddCookie(cookie)
+	}
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+	for _, cookie := range rec.Result().Cookies() {
+		p.cookies[cookie.Name] = cookie
+	}
+	return rec
+}
+
+func newCTFServer() *Server {
+	store := models.NewMemoryStore()
+	models.InitStores(store)
+	s := NewServer(store)
+	s.CTFSecret = []byte("test secret")
+	return s
+}
+
+// joinTeam creates a team and returns a player signed in to it
+func joinTeam(t *testing.T, s *Server, name string) (*ctfPlayer, models.Team) {
+	t.Helper()
+	p := &ctfPlayer{cookies: map[string]*http.Cookie{}}
+	p.post(s.CTFJoinHandler, url.Values{"team": {name}, "password": {"correct horse"}, "create": {"1"}})
+	team, ok := s.Store.GetTeamByName(name)
+	if !ok {
+		t.Fatalf("team %q was not created", name)
+	}
+	return p, team
+}
+
+func buyLaptop(s *Server, p *ctfPlayer, price string) string {
+	p.post(s.VulnerablePriceAddToCartHandler, url.Values{"product_id": {"1"}, "quantity": {"1"}, "price": {price}})
+	return p.post(s.VulnerablePriceCheckoutHandler, nil).Body.String()
+}
+
+func TestCTFFlagIsRevealedAndScoredOnce(t *testing.T) {
+	s := newCTFServer()
+	player, team := joinTeam(t, s, "Red Team")
+	flag := s.flagFor(team.ID, "price")
+
+	if body := buyLaptop(s, player, "999.99"); strings.Contains(body, flag) {
+		t.Fatal("an honest checkout revealed the flag")
+	}
+	if body := buyLaptop(s, player, "0.01"); !strings.Contains(body, flag) {
+		t.Fatal("checking out below the catalog price didn't reveal the flag")
+	}
+
+	submit := func(flag string) string {
+		return player.post(s.CTFSubmitHandler, url.Values{"flag": {flag}}).Header().Get("Location")
+	}
+	if got := submit(flag); got != "/ctf?solved=price" {
+		t.Errorf("submitting the flag redirected to %q", got)
+	}
+	if got := submit(flag); got != "/ctf?error=already_solved" {
+		t.Errorf("resubmitting the flag redirected to %q", got)
+	}
+
+	board := models.Leaderboard(s.Store.ListTeams(), s.Store.ListSolves())
+	if len(board) != 1 || board[0].Points != 100 || board[0].Solves != 1 {
+		t.Errorf("leaderboard = %+v, want Red Team on 100 points", board)
+	}
+}
+
+func TestCTFFlagsArePerTeam(t *testing.T) {
+	s := newCTFServer()
+	_, red := joinTeam(t, s, "Red Team")
+	blue, _ := joinTeam(t, s, "Blue Team")
+
+	got := blue.post(s.CTFSubmitHandler, url.Values{"flag": {s.flagFor(red.ID, "price")}}).Header().Get("Location")
+	if got != "/ctf?error=wrong_flag" {
+		t.Errorf("another team's flag redirected to %q", got)
+	}
+	if solves := s.Store.ListSolves(); len(solves) != 0 {
+		t.Errorf("solves = %+v", solves)
+	}
+}
+
+func TestCTFOffRevealsNothing(t *testing.T) {
+	s := newCTFServer()
+	player, team := joinTeam(t, s, "Red Team")
+	flag := s.flagFor(team.ID, "price")
+	s.CTFSecret = nil
+
+	if body := buyLaptop(s, player, "0.01"); strings.Contains(body, "Flag captured") || strings.Contains(body, flag) {
+		t.Error("a flag was revealed with CTF mode off")
+	}
+}
+
+func TestSessionReaperForgetsFixationSignIns(t *testing.T) {
+	s := newCTFServer()
+	victim := &ctfPlayer{cookies: map[string]*http.Cookie{}}
+	victim.post(s.VulnerableSessionLoginHandler, url.Values{"username": {"alice"}})
+	id := fixationKeyPrefix + victim.cookies[fixationCookieName].Value
+	if _, ok := s.fixationSignIns.Load(id); !ok {
+		t.Fatal("signing in to the fixation demo recorded nothing")
+	}
+
+	s.ExpireSessions(time.Now().Add(sessionAbsoluteTimeout + time.Minute))
+	if _, ok := s.fixationSignIns.Load(id); ok {
+		t.Error("the fixation sign-in outlived its expired session")
+	}
+}
@@ -0,0 +1,57 @@
+package handlers
+
+import (
+	"net/http"
+	"secure-webapp/models"
+)
+
+// pricedProduct is a catalog entry with its price converted for display
+type pricedProduct struct {
+	models.Product
+	DisplayPrice models.Money
+}
+
+// displayCurrency returns the session's chosen currency, falling back to the
+// base currency if the rate table no longer offers it
+func (s *Server) displayCurrency(sessionID string) string {
+	session, exists := s.Store.GetSession(sessionID)
+	if !exists {
+		return s.Rates.Base
+	}
+	if _, ok := s.Rates.Rate(session.Currency); !ok {
+		return s.Rates.Base
+	}
+	return session.Currency
+}
+
+func (s *Server) displayProducts(currency string) map[string]pricedProduct {
+	products := make(map[string]pricedProduct)
+	for id, product := range s.catalog() {
+		price, _, err := s.Rates.Convert(product.Price, currency)
+		if err != nil {
+			price = product.Price
+		}
+		products[id] = pricedProduct{Product: product, DisplayPrice: price}
+	}
+	return products
+}
+
+// CurrencyHandler stores the visitor's display currency on their session
+func (s *Server) CurrencyHandler(w http.ResponseWriter, r *http.Request) {
+	returnTo := localPath(r.FormValue("return_to"), "/")
+
+	if r.Method != "POST" {
+		http.Redirect(w, r, returnTo, http.StatusSeeOther)
+		return
+	}
+
+	sessionID := s.getOrCreateSession(w, r)
+	currency := r.FormValue("currency")
+	if _, ok := s.Rates.Rate(currency); ok {
+		session, _ := s.Store.GetSession(sessionID)
+		session.Currency = currency
+		s.Store.SetSession(session)
+	}
+
+	http.Redirect(w, r, returnTo, http.StatusSeeOther)
+}
@@ -0,0 +1,164 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"secure-webapp/models"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// firstOrderNumber is where the vulnerable IDOR shop starts counting
+const firstOrderNumber = 1001
+
+// orderNumbers hands out the sequential order IDs the vulnerable IDOR shop
+// uses. The counter picks up after the highest numeric ID in the store, so
+// it survives restarts with a FileStore.
+type orderNumbers struct {
+	mu   sync.Mutex
+	last int
+}
+
+// next returns the next free order number. The first call seeds a few orders
+// from other customers so there is something to find by counting.
+func (n *orderNumbers) next(store models.Store) string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.last == 0 {
+		for _, order := range store.ListOrders() {
+			if number, err := strconv.Atoi(order.ID); err == nil && number > n.last {
+				n.last = number
+			}
+		}
+		if n.last == 0 {
+			n.last = seedOtherCustomersOrders(store)
+		}
+	}
+	n.last++
+	return strconv.Itoa(n.last)
+}
+
+// seedOtherCustomersOrders places a few fulfilled orders for made-up
+// customers and returns the last number used
+func seedOtherCustomersOrders(store models.Store) int {
+	purchases := []struct {
+		productID string
+		quantity  int
+	}{
+		{"1", 1},
+		{"4", 2},
+		{"3", 1},
+	}
+
+	number := firstOrderNumber - 1
+	for i, purchase := range purchases {
+		product, exists := store.GetProduct(purchase.productID)
+		if !exists {
+			continue
+		}
+		total, err := product.Price.Mul(purchase.quantity)
+		if err != nil {
+			continue
+		}
+		number++
+		items := []models.CartItem{{ProductID: product.ID, Quantity: purchase.quantity, Price: product.Price}}
+		order := models.NewOrder(shopVulnerableIDOR, fmt.Sprintf("other-customer-%d", i+1), items, total, "customer")
+		order.ID = strconv.Itoa(number)
+		order.Status = models.StatusFulfilled
+		order.Timestamp = order.Timestamp.Add(-time.Duration(len(purchases)-i) * time.Hour)
+		store.SetOrder(order)
+	}
+	return number
+}
+
+// buyIDOROrder places a one-item order for the visitor, reserving and then
+// committing its stock, and returns it
+func (s *Server) buyIDOROrder(shop, sessionID, orderID string, product models.Product, quantity int) (models.Order, error) {
+	total, err := product.Price.Mul(quantity)
+	if err != nil {
+		return models.Order{}, err
+	}
+	items := []models.CartItem{{ProductID: product.ID, Quantity: quantity, Price: product.Price}}
+	if err := s.Store.ReserveStock(items); err != nil {
+		return models.Order{}, err
+	}
+	s.Store.CommitStock(items)
+
+	session, _ := s.Store.GetSession(sessionID)
+	order := models.NewOrder(shop, session.UserID, items, total, "customer")
+	order.ID = orderID
+	return s.completeInstantOrder(order, "customer"), nil
+}
+
+// renderIDORShop shows the catalog and the visitor's own orders for both
+// IDOR shops
+func (s *Server) renderIDORShop(w http.ResponseWriter, r *http.Request, shop, title, banner string) {
+	sessionID := s.getOrCreateSession(w, r)
+	session, _ := s.Store.GetSession(sessionID)
+
+	data := struct {
+		Shop     string
+		Title    string
+		Banner   string
+		Products map[string]models.Product
+		Orders   []models.Order
+		Error    string
+	}{
+		Shop:     shop,
+		Title:    title,
+		Banner:   banner,
+		Products: s.catalog(),
+		Orders:   s.shopOrdersForUser(shopCart(shop, shopVulnerableIDOR, shopSecureIDOR), session.UserID),
+		Error:    shopMessages[r.URL.Query().Get("error")],
+	}
+
+	s.render(w, r, "idor-shop", data)
+}
+
+// renderIDOROrder shows an order's receipt, including who placed it
+func (s *Server) renderIDOROrder(w http.ResponseWriter, r *http.Request, order models.Order, shop string) {
+	owner := "guest customer " + order.UserID
+	if user, exists := s.Store.GetUser(order.UserID); exists {
+		owner = user.Username
+	}
+
+	items := make([]struct {
+		models.CartItem
+		Name string
+	}, len(order.Items))
+	for i, item := range order.Items {
+		items[i].CartItem = item
+		if product, exists := s.Store.GetProduct(item.ProductID); exists {
+			items[i].Name = product.Name
+		}
+	}
+
+	data := struct {
+		Order models.Order
+		Owner string
+		Items []struct {
+			models.CartItem
+			Name string
+		}
+		Shop string
+	}{
+		Order: order,
+		Owner: owner,
+		Items: items,
+		Shop:  shop,
+	}
+
+	s.render(w, r, "idor-order", data)
+}
+
+// parseIDORPurchase reads the product and quantity from a buy form
+func (s *Server) parseIDORPurchase(r *http.Request) (models.Product, int, bool) {
+	quantity, ok := parseQuantity(r.FormValue("quantity"))
+	if !ok {
+		return models.Product{}, 0, false
+	}
+	product, exists := s.productOnSale(r.FormValue("product_id"))
+	return product, quantity, exists
+}
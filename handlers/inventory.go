@@ -0,0 +1,61 @@
+package handlers
+
+import (
+	"log"
+	"secure-webapp/models"
+	"time"
+)
+
+// reservationTTL is how long a pending order holds its stock before the
+// reaper hands it back
+const reservationTTL = 15 * time.Minute
+
+// shopMessages maps the error codes handlers redirect with to the text
+// shown in the shop
+var shopMessages = map[string]string{
+	"out_of_stock":     "Sorry, there is not enough stock left for that.",
+	"invalid_quantity": "Please enter a whole quantity from 1 to 10.",
+	"not_on_sale":      "Something in your cart is no longer for sale and has been taken out. Please check your cart.",
+
+	"coupon_unknown":        "That coupon code doesn't exist.",
+	"coupon_expired":        "That coupon has expired.",
+	"coupon_min_spend":      "Your cart doesn't reach that coupon's minimum spend.",
+	"coupon_not_applicable": "That coupon doesn't apply to anything in your cart.",
+	"coupon_not_stackable":  "That coupon can't be combined with the others on your cart.",
+	"coupon_already_used":   "That coupon is already on your cart.",
+	"coupon_limit":          "That coupon has been used up.",
+	"coupon_sign_in":        "That coupon is limited per customer - please sign in to use it.",
+}
+
+// ExpireReservations marks unpaid orders whose reservation has lapsed as
+// expired and releases their stock. The transition is atomic, so an order
+// the gateway confirms at the same moment is either paid or expired, never
+// both.
+func (s *Server) ExpireReservations(now time.Time) {
+	for _, order := range s.Store.ListOrders() {
+		if !order.Status.IsOpen() || order.ReservedUntil.IsZero() || now.Before(order.ReservedUntil) {
+			continue
+		}
+		if _, err := s.Store.TransitionOrder(order.ID, models.StatusExpired, "system"); err != nil {
+			continue
+		}
+		if err := s.Store.ReleaseStock(order.Items); err != nil {
+			log.Printf("Releasing stock for expired order %s: %v", order.ID, err)
+		}
+	}
+}
+
+// RunReservationReaper calls ExpireReservations every interval until stop is
+// closed
+func (s *Server) RunReservationReaper(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			s.ExpireReservations(now)
+		case <-stop:
+			return
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package handlers
+
+import (
+	"net/http"
+	"secure-webapp/models"
+)
+
+// Example express-order bodies for the mass-assignment shops. The vulnerable
+// endpoint decodes into models.Order, whose fields have no JSON tags, so its
+// keys are the Go field names.
+var massAssignmentPayloads = map[string]map[string]string{
+	"vulnerable": {
+		"honest": `{"Items": [{"ProductID": "1", "Quantity": 1}]}`,
+		"attack": `{"Items": [{"ProductID": "1", "Quantity": 1}], "Status": "fulfilled", "Total": {"amount": 0, "currency": "USD"}}`,
+	},
+	"secure": {
+		"honest": `{"items": [{"product_id": "1", "quantity": 1}]}`,
+		"attack": `{"items": [{"product_id": "1", "quantity": 1}], "status": "fulfilled", "total": {"amount": 0, "currency": "USD"}}`,
+	},
+}
+
+// renderMassAssignmentShop shows the catalog, an editable request body for
+// /api/v1/{shop}/express-orders and the visitor's orders
+func (s *Server) renderMassAssignmentShop(w http.ResponseWriter, r *http.Request, shop string) {
+	sessionID := s.getOrCreateSession(w, r)
+	session, _ := s.Store.GetSession(sessionID)
+
+	data := struct {
+		Shop     string
+		Endpoint string
+		Products []apiProduct
+		Payloads map[string]string
+		Orders   []models.Order
+	}{
+		Shop:     shop,
+		Endpoint: "/api/v1/" + shop + "/express-orders",
+		Products: s.apiProducts(),
+		Payloads: massAssignmentPayloads[shop],
+		Orders:   s.shopOrdersForUser(shopCart(shop, cartVulnerableAPI, cartSecureAPI), session.UserID),
+	}
+
+	s.render(w, r, "mass-assignment", data)
+}
@@ -0,0 +1,352 @@
+package handlers
+
+import (
+	"net/http"
+	"reflect"
+	"regexp"
+	"secure-webapp/models"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The OpenAPI document for /api/v1 is generated from apiOperations and the
+// Go types the handlers actually decode and encode, so a field added to a
+// DTO shows up in the spec without anyone editing it by hand.
+
+type apiOperation struct {
+	Method  string
+	Path    string
+	Summary string
+	// Request is the JSON body type, nil for operations without a body
+	Request reflect.Type
+	// Status and Response describe the success response
+	Status   int
+	Response reflect.Type
+	// Errors lists the statuses answered with an apiErrorBody
+	Errors []int
+}
+
+func typeOf[T any]() reflect.Type {
+	return reflect.TypeFor[T]()
+}
+
+// apiOperations describes every /api route. The route test in main_test.go
+// fails if main.go or a registered scenario serves an /api route that is
+// missing here.
+var apiOperations = func() []apiOperation {
+	ops := []apiOperation{{
+		Method: "GET", Path: "/api/openapi.json", Summary: "This OpenAPI document",
+		Status: http.StatusOK, Response: typeOf[map[string]any](),
+	}}
+
+	for _, variant := range []string{"vulnerable", "secure"} {
+		prefix := "/api/v1/" + variant
+		itemRequest, payRequest := typeOf[vulnerableAPIItemRequest](), typeOf[vulnerableAPIPayRequest]()
+		expressRequest := typeOf[models.Order]()
+		payStatus, payResponse := http.StatusOK, typeOf[apiOrder]()
+		writeErrors := []int{http.StatusBadRequest}
+		payErrors := []int{http.StatusBadRequest, http.StatusNotFound, http.StatusConflict}
+		if variant == "secure" {
+			itemRequest, payRequest = typeOf[secureAPIItemRequest](), typeOf[secureAPIPayRequest]()
+			expressRequest = typeOf[secureExpressOrderRequest]()
+			payStatus, payResponse = http.StatusAccepted, typeOf[secureAPIPayResponse]()
+			writeErrors = []int{http.StatusBadRequest, http.StatusForbidden, http.StatusUnsupportedMediaType}
+			payErrors = append(writeErrors, http.StatusPaymentRequired, http.StatusNotFound, http.StatusConflict, http.StatusBadGateway)
+		}
+
+		ops = append(ops,
+			apiOperation{
+				Method: "GET", Path: prefix + "/products", Summary: "List products",
+				Status: http.StatusOK, Response: typeOf[[]apiProduct](),
+			},
+			apiOperation{
+				Method: "GET", Path: prefix + "/cart", Summary: "Get the session's cart",
+				Status: http.StatusOK, Response: typeOf[apiCart](),
+			},
+			apiOperation{
+				Method: "DELETE", Path: prefix + "/cart", Summary: "Empty the cart",
+				Status: http.StatusOK, Response: typeOf[apiCart](), Errors: writeErrors,
+			},
+			apiOperation{
+				Method: "POST", Path: prefix + "/cart/items", Summary: "Add a line to the cart",
+				Request: itemRequest, Status: http.StatusCreated, Response: typeOf[apiCart](),
+				Errors: append(writeErrors, http.StatusNotFound, http.StatusConflict),
+			},
+			apiOperation{
+				Method: "GET", Path: prefix + "/orders", Summary: "List the signed-in customer's orders",
+				Status: http.StatusOK, Response: typeOf[[]apiOrder](),
+			},
+			apiOperation{
+				Method: "POST", Path: prefix + "/orders", Summary: "Create an order from the cart",
+				Status: http.StatusCreated, Response: typeOf[apiOrder](),
+				Errors: append(writeErrors, http.StatusNotFound, http.StatusConflict),
+			},
+			apiOperation{
+				Method: "POST", Path: prefix + "/express-orders", Summary: "Create an order for the given items without a cart",
+				Request: expressRequest, Status: http.StatusCreated, Response: typeOf[apiOrder](),
+				Errors: append(writeErrors, http.StatusNotFound, http.StatusConflict),
+			},
+			apiOperation{
+				Method: "GET", Path: prefix + "/orders/{id}", Summary: "Get an order",
+				Status: http.StatusOK, Response: typeOf[apiOrder](), Errors: []int{http.StatusNotFound},
+			},
+			apiOperation{
+				Method: "POST", Path: prefix + "/orders/{id}/pay", Summary: "Pay for an order",
+				Request: payRequest, Status: payStatus, Response: payResponse, Errors: payErrors,
+			},
+		)
+	}
+
+	// Catalog administration, served when an admin account is set up
+	adminErrors := []int{http.StatusUnauthorized, http.StatusForbidden}
+	adminWriteErrors := append(adminErrors, http.StatusBadRequest, http.StatusUnsupportedMediaType)
+	ops = append(ops,
+		apiOperation{
+			Method: "GET", Path: "/api/v1/admin/products", Summary: "List every product, archived ones included",
+			Status: http.StatusOK, Response: typeOf[[]apiAdminProduct](), Errors: adminErrors,
+		},
+		apiOperation{
+			Method: "POST", Path: "/api/v1/admin/products", Summary: "Create a product",
+			Request: typeOf[apiProductRequest](), Status: http.StatusCreated, Response: typeOf[apiAdminProduct](),
+			Errors: adminWriteErrors,
+		},
+		apiOperation{
+			Method: "GET", Path: "/api/v1/admin/products/{id}", Summary: "Get a product",
+			Status: http.StatusOK, Response: typeOf[apiAdminProduct](),
+			Errors: append(adminErrors, http.StatusNotFound),
+		},
+		apiOperation{
+			Method: "PATCH", Path: "/api/v1/admin/products/{id}", Summary: "Edit, reprice, archive or restore a product",
+			Request: typeOf[apiProductRequest](), Status: http.StatusOK, Response: typeOf[apiAdminProduct](),
+			Errors: append(adminWriteErrors, http.StatusNotFound, http.StatusConflict),
+		},
+		apiOperation{
+			Method: "GET", Path: "/api/v1/admin/product-changes", Summary: "The catalog audit log, newest first",
+			Status: http.StatusOK, Response: typeOf[[]apiProductChange](), Errors: adminErrors,
+		},
+	)
+	return ops
+}()
+
+type openAPIDocument struct {
+	OpenAPI    string                                 `json:"openapi"`
+	Info       openAPIInfo                            `json:"info"`
+	Paths      map[string]map[string]openAPIOperation `json:"paths"`
+	Components struct {
+		Schemas map[string]*openAPISchema `json:"schemas"`
+	} `json:"components"`
+}
+
+type openAPIInfo struct {
+	Title   string `json:"title"`
+	Version string `json:"version"`
+}
+
+type openAPIOperation struct {
+	Summary     string                     `json:"summary"`
+	Parameters  []openAPIParameter         `json:"parameters,omitempty"`
+	RequestBody *openAPIBody               `json:"requestBody,omitempty"`
+	Responses   map[string]openAPIResponse `json:"responses"`
+}
+
+type openAPIParameter struct {
+	Name     string         `json:"name"`
+	In       string         `json:"in"`
+	Required bool           `json:"required"`
+	Schema   *openAPISchema `json:"schema"`
+}
+
+type openAPIBody struct {
+	Required bool                      `json:"required"`
+	Content  map[string]openAPIContent `json:"content"`
+}
+
+type openAPIResponse struct {
+	Description string                    `json:"description"`
+	Content     map[string]openAPIContent `json:"content,omitempty"`
+}
+
+type openAPIContent struct {
+	Schema *openAPISchema `json:"schema"`
+}
+
+type openAPISchema struct {
+	Ref                  string                    `json:"$ref,omitempty"`
+	Type                 string                    `json:"type,omitempty"`
+	Format               string                    `json:"format,omitempty"`
+	Properties           map[string]*openAPISchema `json:"properties,omitempty"`
+	Required             []string                  `json:"required,omitempty"`
+	Items                *openAPISchema            `json:"items,omitempty"`
+	AdditionalProperties *openAPISchema            `json:"additionalProperties,omitempty"`
+	Nullable             bool                      `json:"nullable,omitempty"`
+}
+
+var pathParameter = regexp.MustCompile(`\{([^}]+)\}`)
+
+// openAPISpec builds the document once; apiOperations never changes at run
+// time
+var openAPISpec = sync.OnceValue(func() openAPIDocument {
+	doc := openAPIDocument{
+		OpenAPI: "3.0.3",
+		Info:    openAPIInfo{Title: "Secure Webapp Shop API", Version: "v1"},
+		Paths:   map[string]map[string]openAPIOperation{},
+	}
+	schemas := &schemaBuilder{schemas: map[string]*openAPISchema{}, types: map[reflect.Type]string{}}
+
+	for _, op := range apiOperations {
+		operation := openAPIOperation{
+			Summary:   op.Summary,
+			Responses: map[string]openAPIResponse{},
+		}
+		for _, match := range pathParameter.FindAllStringSubmatch(op.Path, -1) {
+			operation.Parameters = append(operation.Parameters, openAPIParameter{
+				Name: match[1], In: "path", Required: true, Schema: &openAPISchema{Type: "string"},
+			})
+		}
+		if op.Request != nil {
+			operation.RequestBody = &openAPIBody{
+				Required: true,
+				Content:  map[string]openAPIContent{"application/json": {Schema: schemas.schemaFor(op.Request)}},
+			}
+		}
+		operation.Responses[statusKey(op.Status)] = openAPIResponse{
+			Description: http.StatusText(op.Status),
+			Content:     map[string]openAPIContent{"application/json": {Schema: schemas.schemaFor(op.Response)}},
+		}
+
+		errorSchema := schemas.schemaFor(typeOf[apiErrorBody]())
+		for _, status := range op.Errors {
+			operation.Responses[statusKey(status)] = openAPIResponse{
+				Description: http.StatusText(status),
+				Content:     map[string]openAPIContent{"application/json": {Schema: errorSchema}},
+			}
+		}
+		operation.Responses[statusKey(http.StatusMethodNotAllowed)] = openAPIResponse{
+			Description: http.StatusText(http.StatusMethodNotAllowed),
+			Content:     map[string]openAPIContent{"application/json": {Schema: errorSchema}},
+		}
+
+		if doc.Paths[op.Path] == nil {
+			doc.Paths[op.Path] = map[string]openAPIOperation{}
+		}
+		doc.Paths[op.Path][strings.ToLower(op.Method)] = operation
+	}
+
+	doc.Components.Schemas = schemas.schemas
+	return doc
+})
+
+func statusKey(status int) string {
+	return strconv.Itoa(status)
+}
+
+var timeType = typeOf[time.Time]()
+
+// schemaBuilder collects the named schemas of a document
+type schemaBuilder struct {
+	schemas map[string]*openAPISchema
+	types   map[reflect.Type]string
+}
+
+// schemaFor describes t following encoding/json's rules. Named structs are
+// added to the components once and referenced from then on; anonymous
+// structs are inlined.
+func (b *schemaBuilder) schemaFor(t reflect.Type) *openAPISchema {
+	if t == timeType {
+		return &openAPISchema{Type: "string", Format: "date-time"}
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		schema := *b.schemaFor(t.Elem())
+		if schema.Ref == "" {
+			// $ref siblings are ignored in OpenAPI 3.0, so only inline
+			// schemas can be marked nullable
+			schema.Nullable = true
+		}
+		return &schema
+	case reflect.String:
+		return &openAPISchema{Type: "string"}
+	case reflect.Bool:
+		return &openAPISchema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return &openAPISchema{Type: "integer", Format: "int32"}
+	case reflect.Int64, reflect.Uint64:
+		return &openAPISchema{Type: "integer", Format: "int64"}
+	case reflect.Float32, reflect.Float64:
+		return &openAPISchema{Type: "number"}
+	case reflect.Slice, reflect.Array:
+		return &openAPISchema{Type: "array", Items: b.schemaFor(t.Elem())}
+	case reflect.Map:
+		if t.Elem().Kind() == reflect.Interface {
+			return &openAPISchema{Type: "object"}
+		}
+		return &openAPISchema{Type: "object", AdditionalProperties: b.schemaFor(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return b.structSchema(t)
+		}
+		name, done := b.types[t]
+		if !done {
+			name = b.schemaName(t)
+			// Register the name first so a self-referencing type terminates
+			b.types[t] = name
+			b.schemas[name] = b.structSchema(t)
+		}
+		return &openAPISchema{Ref: "#/components/schemas/" + name}
+	}
+	return &openAPISchema{}
+}
+
+func (b *schemaBuilder) structSchema(t reflect.Type) *openAPISchema {
+	schema := &openAPISchema{Type: "object", Properties: map[string]*openAPISchema{}}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name, options, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		schema.Properties[name] = b.schemaFor(field.Type)
+		if !strings.Contains(options, "omitempty") && field.Type.Kind() != reflect.Pointer {
+			schema.Required = append(schema.Required, name)
+		}
+	}
+	sort.Strings(schema.Required)
+	return schema
+}
+
+// schemaName turns the Go type name into a schema name, dropping the "api"
+// prefix of the DTOs so apiOrder becomes Order. A type from another package
+// whose name is already taken is qualified with its package: models.Order
+// becomes ModelsOrder.
+func (b *schemaBuilder) schemaName(t reflect.Type) string {
+	name := t.Name()
+	if rest, ok := strings.CutPrefix(name, "api"); ok && rest != "" {
+		name = rest
+	}
+	name = strings.ToUpper(name[:1]) + name[1:]
+	if _, taken := b.schemas[name]; !taken {
+		return name
+	}
+	pkg := t.PkgPath()
+	pkg = pkg[strings.LastIndex(pkg, "/")+1:]
+	return strings.ToUpper(pkg[:1]) + pkg[1:] + name
+}
+
+// OpenAPIHandler serves the generated OpenAPI document for the JSON API
+func (s *Server) OpenAPIHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		methodNotAllowed(w, "GET")
+		return
+	}
+	writeJSON(w, http.StatusOK, openAPISpec())
+}
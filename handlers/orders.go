@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"log"
+	"secure-webapp/models"
+	"sort"
+)
+
+// completeInstantOrder stores an order that was settled at checkout and
+// walks it through payment to fulfilment so its history shows every step
+func (s *Server) completeInstantOrder(order models.Order, actor string) models.Order {
+	s.Store.SetOrder(order)
+	steps := []struct {
+		to    models.OrderStatus
+		actor string
+	}{
+		{models.StatusAwaitingPayment, actor},
+		{models.StatusPaid, actor},
+		{models.StatusFulfilled, "system"},
+	}
+	for _, step := range steps {
+		next, err := s.Store.TransitionOrder(order.ID, step.to, step.actor)
+		if err != nil {
+			log.Printf("Completing order %s: %v", order.ID, err)
+			return order
+		}
+		order = next
+	}
+	return order
+}
+
+// ordersForUser lists a user's orders, newest first
+func (s *Server) ordersForUser(userID string) []models.Order {
+	orders := []models.Order{}
+	for _, order := range s.Store.ListOrders() {
+		if order.UserID == userID {
+			orders = append(orders, order)
+		}
+	}
+	sort.Slice(orders, func(i, j int) bool {
+		return orders[i].Timestamp.After(orders[j].Timestamp)
+	})
+	return orders
+}
+
+// shopOrdersForUser lists the orders a user placed in one shop, newest first
+func (s *Server) shopOrdersForUser(shop, userID string) []models.Order {
+	orders := []models.Order{}
+	for _, order := range s.ordersForUser(userID) {
+		if order.Shop == shop {
+			orders = append(orders, order)
+		}
+	}
+	return orders
+}
+
+// shopOrder looks an order up for a vulnerable shop, which only ever gets to
+// see its own orders: whatever its bugs let a visitor do, they can't reach
+// the orders another shop placed
+func (s *Server) shopOrder(shop, orderID string) (models.Order, bool) {
+	order, exists := s.Store.GetOrder(orderID)
+	if !exists || order.Shop != shop {
+		return models.Order{}, false
+	}
+	return order, true
+}
+
+// ownedOrder looks an order up on behalf of the visitor, for the secure shop
+// that placed it. Someone else's order, or one from another shop, is
+// reported exactly like a missing one so IDs can't be probed and an order
+// priced by a vulnerable shop can't be paid here.
+func (s *Server) ownedOrder(shop, sessionID, orderID string) (models.Order, bool) {
+	order, exists := s.shopOrder(shop, orderID)
+	session, _ := s.Store.GetSession(sessionID)
+	if !exists || session.UserID == "" || order.UserID != session.UserID {
+		return models.Order{}, false
+	}
+	return order, true
+}
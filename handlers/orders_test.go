@@ -0,0 +1,245 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"secure-webapp/gateway"
+	"secure-webapp/models"
+	"testing"
+	"time"
+)
+
+// placeSecureOrder checks a laptop out of the secure order shop and returns
+// the pending order, which holds the laptop until it is paid
+func placeSecureOrder(t *testing.T, s *Server) models.Order {
+	t.Helper()
+	customer := &ctfPlayer{cookies: map[string]*http.Cookie{}}
+	customer.post(s.SecureAddToCartHandler, url.Values{"product_id": {"1"}, "quantity": {"1"}})
+	customer.post(s.SecureCheckoutHandler, nil)
+	for _, order := range s.Store.ListOrders() {
+		if order.Shop == cartSecureOrder {
+			return order
+		}
+	}
+	t.Fatal("the secure checkout placed no order")
+	return models.Order{}
+}
+
+// assertUntouched fails if anything moved the secure order on or freed the
+// laptop it holds
+func assertUntouched(t *testing.T, s *Server, placed models.Order) {
+	t.Helper()
+	order, _ := s.Store.GetOrder(placed.ID)
+	if order.Status != models.StatusPending || len(order.History) != len(placed.History) {
+		t.Errorf("secure order is %s after %d changes, want it still pending", order.Status, len(order.History))
+	}
+	if laptop, _ := s.Store.GetProduct("1"); laptop.Reserved != 1 {
+		t.Errorf("laptop reserved = %d, want the secure order's 1", laptop.Reserved)
+	}
+}
+
+func TestVulnerableOrderShopOnlyReachesItsOwnOrders(t *testing.T) {
+	s := newCTFServer()
+	secure := placeSecureOrder(t, s)
+
+	attacker := &ctfPlayer{cookies: map[string]*http.Cookie{}}
+	form := url.Values{"order_id": {secure.ID}}
+	if rec := attacker.post(s.VulnerablePayHandler, form); rec.Code != http.StatusNotFound {
+		t.Errorf("paying a secure order got %d, want 404", rec.Code)
+	}
+	if rec := attacker.post(s.VulnerableConfirmHandler, form); rec.Code != http.StatusNotFound {
+		t.Errorf("confirming a secure order got %d, want 404", rec.Code)
+	}
+	assertUntouched(t, s, secure)
+
+	// Within its own shop the ownership bug is still there to exploit
+	victim := &ctfPlayer{cookies: map[string]*http.Cookie{}}
+	victim.post(s.VulnerableAddToCartHandler, url.Values{"product_id": {"2"}, "quantity": {"1"}})
+	location, _ := url.Parse(victim.post(s.VulnerableCheckoutHandler, nil).Header().Get("Location"))
+	form = url.Values{"order_id": {location.Query().Get("order_id")}}
+	attacker.post(s.VulnerablePayHandler, form)
+	attacker.post(s.VulnerableConfirmHandler, form)
+	if order, _ := s.Store.GetOrder(form.Get("order_id")); order.Status != models.StatusFulfilled {
+		t.Errorf("another customer's vulnerable order is %s, want it fulfilled by the attacker", order.Status)
+	}
+}
+
+func TestVulnerableAPIOnlyReachesItsOwnOrders(t *testing.T) {
+	s := newCTFServer()
+	secure := placeSecureOrder(t, s)
+	api := http.NewServeMux()
+	api.HandleFunc("/api/v1/vulnerable/orders/{id}", s.VulnerableAPIOrderHandler)
+	api.HandleFunc("/api/v1/vulnerable/orders/{id}/pay", s.VulnerableAPIPayHandler)
+
+	attacker := &ctfPlayer{cookies: map[string]*http.Cookie{}}
+	if rec := attacker.sendJSON(api, "GET", "/api/v1/vulnerable/orders/"+secure.ID, ""); rec.Code != http.StatusNotFound {
+		t.Errorf("reading a secure order got %d, want 404", rec.Code)
+	}
+	if rec := attacker.sendJSON(api, "POST", "/api/v1/vulnerable/orders/"+secure.ID+"/pay", `{"card_number": "4242424242424242"}`); rec.Code != http.StatusNotFound {
+		t.Errorf("paying a secure order got %d, want 404", rec.Code)
+	}
+	assertUntouched(t, s, secure)
+}
+
+func TestSecureAPIOnlyReachesItsOwnOrders(t *testing.T) {
+	s := newCTFServer()
+	api := http.NewServeMux()
+	api.HandleFunc("/api/v1/vulnerable/cart/items", s.VulnerableAPICartItemsHandler)
+	api.HandleFunc("/api/v1/vulnerable/orders", s.VulnerableAPIOrdersHandler)
+	api.HandleFunc("/api/v1/secure/orders", s.SecureAPIOrdersHandler)
+	api.HandleFunc("/api/v1/secure/orders/{id}", s.SecureAPIOrderHandler)
+	api.HandleFunc("/api/v1/secure/orders/{id}/pay", s.SecureAPIPayHandler)
+
+	// The customer's own order, at a price they tampered with in the
+	// vulnerable API
+	customer, _ := signedIn(t, s, "alice")
+	customer.sendJSON(api, "POST", "/api/v1/vulnerable/cart/items", `{"product_id": "1", "quantity": 1, "price": {"amount": 1, "currency": "USD"}}`)
+	var placed apiOrder
+	json.Unmarshal(customer.sendJSON(api, "POST", "/api/v1/vulnerable/orders", "").Body.Bytes(), &placed)
+	if placed.ID == "" {
+		t.Fatal("the vulnerable API placed no order")
+	}
+
+	if rec := customer.sendJSON(api, "GET", "/api/v1/secure/orders/"+placed.ID, ""); rec.Code != http.StatusNotFound {
+		t.Errorf("reading a vulnerable API order through the secure API got %d, want 404", rec.Code)
+	}
+	if rec := customer.sendJSON(api, "POST", "/api/v1/secure/orders/"+placed.ID+"/pay", `{"card_number": "`+gateway.CardApproveBasic+`"}`); rec.Code != http.StatusNotFound {
+		t.Errorf("paying a vulnerable API order through the secure API got %d, want 404", rec.Code)
+	}
+	if order, _ := s.Store.GetOrder(placed.ID); order.Status != models.StatusPending || order.PaymentID != "" {
+		t.Errorf("vulnerable API order is %s with payment %q, want it untouched", order.Status, order.PaymentID)
+	}
+
+	var listed []apiOrder
+	json.Unmarshal(customer.sendJSON(api, "GET", "/api/v1/secure/orders", "").Body.Bytes(), &listed)
+	if len(listed) != 0 {
+		t.Errorf("secure API lists %d orders, want none of the vulnerable API's", len(listed))
+	}
+	json.Unmarshal(customer.sendJSON(api, "GET", "/api/v1/vulnerable/orders", "").Body.Bytes(), &listed)
+	if len(listed) != 1 || listed[0].ID != placed.ID {
+		t.Errorf("vulnerable API lists %+v, want just its own order", listed)
+	}
+}
+
+func TestExpressOrderCannotReplaceExistingOrders(t *testing.T) {
+	s := newCTFServer()
+	secure := placeSecureOrder(t, s)
+
+	attacker := &ctfPlayer{cookies: map[string]*http.Cookie{}}
+	body := `{"ID": "` + secure.ID + `", "Shop": "secure-order", "Items": [{"ProductID": "2", "Quantity": 1}], "Status": "fulfilled", "Total": {"amount": 0, "currency": "USD"}}`
+	rec := attacker.sendJSON(http.HandlerFunc(s.VulnerableExpressOrderHandler), "POST", "/api/v1/vulnerable/express-orders", body)
+	var placed apiOrder
+	json.Unmarshal(rec.Body.Bytes(), &placed)
+	if rec.Code != http.StatusCreated || placed.ID == secure.ID {
+		t.Fatalf("express order got %d with ID %q, want a new order", rec.Code, placed.ID)
+	}
+	assertUntouched(t, s, secure)
+
+	// The mass assignment itself is still there to exploit
+	order, _ := s.Store.GetOrder(placed.ID)
+	if order.Shop != cartVulnerableAPI || order.Status != models.StatusFulfilled || order.Total.Amount != 0 {
+		t.Errorf("express order is a %s order, %s at %s; want a free fulfilled vulnerable-api order", order.Shop, order.Status, order.Total)
+	}
+}
+
+func TestForgedWebhookCannotPaySecureOrders(t *testing.T) {
+	s := newCTFServer()
+	secure := placeSecureOrder(t, s)
+
+	forger := &ctfPlayer{cookies: map[string]*http.Cookie{}}
+	body := `{"order_id": "` + secure.ID + `", "status": "paid"}`
+	if rec := forger.sendJSON(http.HandlerFunc(s.VulnerableWebhookHandler), "POST", "/vulnerable-order/webhook", body); rec.Code != http.StatusNotFound {
+		t.Errorf("forged webhook for a secure order got %d, want 404", rec.Code)
+	}
+	assertUntouched(t, s, secure)
+}
+
+func TestLatePaymentForExpiredOrderIsRefunded(t *testing.T) {
+	s := newCTFServer()
+	receiver := httptest.NewServer(http.HandlerFunc(s.SecureWebhookHandler))
+	defer receiver.Close()
+	s.Gateway.WebhookURL = receiver.URL
+	s.Gateway.WebhookDelay = 0
+
+	placed := placeSecureOrder(t, s)
+	charge, err := s.Gateway.CreateCharge(placed.ID, placed.Total, gateway.CardRequires3DS, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Store.UpdateOrder(placed.ID, func(o *models.Order) error {
+		o.PaymentID = charge.ID
+		return o.Transition(models.StatusAwaitingPayment, "customer")
+	})
+
+	// The customer takes so long over 3-D Secure that the order lapses, then
+	// approves it anyway
+	s.ExpireReservations(time.Now().Add(2 * reservationTTL))
+	s.Gateway.CompleteChallenge(charge.ID, true)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		order, _ := s.Store.GetOrder(placed.ID)
+		charge, _ = s.Gateway.GetCharge(charge.ID)
+		if order.Status == models.StatusRefunded && charge.Status == gateway.ChargeRefunded {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("order is %s and charge is %s, want both refunded", order.Status, charge.Status)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if laptop, _ := s.Store.GetProduct("1"); laptop.Stock != 5 || laptop.Reserved != 0 {
+		t.Errorf("laptop stock %d reserved %d, want the expired order's unit back on sale", laptop.Stock, laptop.Reserved)
+	}
+}
+
+func TestOnlyOneRequestChargesAnOrder(t *testing.T) {
+	s := newCTFServer()
+	placed := placeSecureOrder(t, s)
+
+	// Another request is mid-charge, so this one must not charge the card too
+	s.paying.Store(placed.ID, struct{}{})
+	if _, charge, err := s.payOrder(placed.ID, gateway.CardApproveBasic, ""); !errors.Is(err, errNotPayable) || charge.ID != "" {
+		t.Errorf("paying an order being paid = charge %q, %v; want no charge and errNotPayable", charge.ID, err)
+	}
+	assertUntouched(t, s, placed)
+	s.paying.Delete(placed.ID)
+
+	order, charge, err := s.payOrder(placed.ID, gateway.CardRequires3DS, "")
+	if err != nil || order.Status != models.StatusAwaitingPayment || order.PaymentID != charge.ID {
+		t.Fatalf("payOrder = %s with payment %q, %v; want awaiting payment with charge %s", order.Status, order.PaymentID, err, charge.ID)
+	}
+	if _, again, err := s.payOrder(placed.ID, gateway.CardApproveBasic, ""); !errors.Is(err, errNotPayable) || again.ID != "" {
+		t.Errorf("paying a paid order again = charge %q, %v; want no charge and errNotPayable", again.ID, err)
+	}
+}
+
+func TestStrayChargeIsRefunded(t *testing.T) {
+	s := newCTFServer()
+	receiver := httptest.NewServer(http.HandlerFunc(s.SecureWebhookHandler))
+	defer receiver.Close()
+	s.Gateway.WebhookURL = receiver.URL
+	s.Gateway.WebhookDelay = 0
+
+	// A charge the order never recorded, such as one made while it was being
+	// cancelled
+	placed := placeSecureOrder(t, s)
+	charge, err := s.Gateway.CreateCharge(placed.ID, placed.Total, gateway.CardRequires3DS, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Gateway.CompleteChallenge(charge.ID, true)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for charge.Status != gateway.ChargeRefunded {
+		if time.Now().After(deadline) {
+			t.Fatalf("stray charge is %s, want it refunded", charge.Status)
+		}
+		time.Sleep(10 * time.Millisecond)
+		charge, _ = s.Gateway.GetCharge(charge.ID)
+	}
+	assertUntouched(t, s, placed)
+}
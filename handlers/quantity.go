@@ -0,0 +1,17 @@
+package handlers
+
+import "strconv"
+
+// maxLineQuantity caps a single cart line in the secure shops
+const maxLineQuantity = 10
+
+// parseQuantity accepts only a whole number from 1 to maxLineQuantity.
+// Anything else - empty, fractional, negative, zero or huge - is rejected
+// rather than coerced.
+func parseQuantity(value string) (int, bool) {
+	quantity, err := strconv.Atoi(value)
+	if err != nil || quantity < 1 || quantity > maxLineQuantity {
+		return 0, false
+	}
+	return quantity, true
+}
@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"secure-webapp/models"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// buyConcurrently fires buyers simultaneous purchases of the last laptop and
+// returns how many of them completed
+func buyConcurrently(t *testing.T, buy func(*Server) http.HandlerFunc, buyers int) int {
+	t.Helper()
+
+	store := models.NewMemoryStore()
+	models.InitStores(store)
+	laptop, _ := store.GetProduct("1")
+	laptop.Stock = 1
+	store.SetProduct(laptop)
+	handler := buy(NewServer(store))
+
+	form := url.Values{"product_id": {"1"}, "quantity": {"1"}}.Encode()
+	start := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < buyers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req := httptest.NewRequest("POST", "/buy", strings.NewReader(form))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			<-start
+			handler(httptest.NewRecorder(), req)
+		}()
+	}
+	close(start)
+	wg.Wait()
+
+	sold := 0
+	for _, order := range store.ListOrders() {
+		if order.Status == models.StatusFulfilled {
+			sold += order.Items[0].Quantity
+		}
+	}
+	return sold
+}
+
+func TestRaceShopsLastUnit(t *testing.T) {
+	const buyers = 10
+
+	vulnerable := buyConcurrently(t, func(s *Server) http.HandlerFunc { return s.VulnerableRaceBuyHandler }, buyers)
+	if vulnerable <= 1 {
+		t.Errorf("vulnerable shop sold %d units of a stock of 1; the race should oversell", vulnerable)
+	}
+
+	secure := buyConcurrently(t, func(s *Server) http.HandlerFunc { return s.SecureRaceBuyHandler }, buyers)
+	if secure != 1 {
+		t.Errorf("secure shop sold %d units of a stock of 1, want exactly 1", secure)
+	}
+}
+
+// The vulnerable shop's stale write-back may lose other sales of the same
+// units, but must leave reservations and catalog edits made meanwhile alone
+func TestVulnerableRaceOnlyWritesStock(t *testing.T) {
+	store := models.NewMemoryStore()
+	models.InitStores(store)
+	s := NewServer(store)
+
+	// Park the buyer inside the payment, after the shop has read the laptop
+	paying, resume := make(chan struct{}), make(chan struct{})
+	defer func(original func()) { processPayment = original }(processPayment)
+	processPayment = func() {
+		close(paying)
+		<-resume
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		req := httptest.NewRequest("POST", "/buy", strings.NewReader(url.Values{"product_id": {"1"}, "quantity": {"1"}}.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		s.VulnerableRaceBuyHandler(httptest.NewRecorder(), req)
+	}()
+
+	<-paying
+	if err := store.ReserveStock([]models.CartItem{{ProductID: "1", Quantity: 2}}); err != nil {
+		t.Fatal(err)
+	}
+	store.UpdateProduct("1", "admin", func(p *models.Product) error {
+		p.Price = models.NewMoney(89999, models.BaseCurrency)
+		return nil
+	})
+	close(resume)
+	<-done
+
+	laptop, _ := store.GetProduct("1")
+	if laptop.Stock != 4 || laptop.Reserved != 2 || laptop.Price != models.NewMoney(89999, models.BaseCurrency) {
+		t.Errorf("laptop = %+v, want the sale taken off stock and the reservation and new price kept", laptop)
+	}
+}
@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"fmt"
+	"hash/fnv"
+	"io/fs"
+	"log"
+	"os"
+	"time"
+)
+
+// WatchTemplates switches the server to templates read from dir/templates
+// on disk and re-parses them whenever a file there changes, checking every
+// interval until stop is closed. dir is the handlers package directory. A
+// set that fails to parse is logged and the last good one stays in use.
+// Without it the embedded templates are served.
+func (s *Server) WatchTemplates(dir string, interval time.Duration, stop <-chan struct{}) {
+	fsys := os.DirFS(dir)
+	var last uint64
+	reload := func() {
+		sum, err := templatesFingerprint(fsys)
+		if err != nil {
+			log.Printf("Watching templates: %v", err)
+			return
+		}
+		if sum == last {
+			return
+		}
+		last = sum
+
+		set, err := parsePages(fsys)
+		if err != nil {
+			log.Printf("Reloading templates: %v", err)
+			return
+		}
+		s.devPages.Store(&set)
+		log.Printf("Reloaded templates from %s", dir)
+	}
+
+	reload()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			reload()
+		case <-stop:
+			return
+		}
+	}
+}
+
+// templatesFingerprint hashes the name, size and modification time of every
+// file under templates/, so any edit, addition or removal changes it
+func templatesFingerprint(fsys fs.FS) (uint64, error) {
+	h := fnv.New64a()
+	err := fs.WalkDir(fsys, "templates", func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(h, "%s %d %d\n", path, info.Size(), info.ModTime().UnixNano())
+		return nil
+	})
+	return h.Sum64(), err
+}
@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"log"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// Pages live in templates/pages and are rendered inside the shared layout
+// from templates/layout. Both are embedded in the binary and parsed once.
+//
+//go:embed templates
+var templateFS embed.FS
+
+// pageSet maps a page name, its file name without .html, to the layout
+// parsed together with that page
+type pageSet map[string]*template.Template
+
+var pages = mustParsePages(templateFS)
+
+// templateStubs declares the per-request functions so templates can be
+// parsed before there is a request; render replaces them
+var templateStubs = template.FuncMap{
+	"csrfField":   func() template.HTML { return "" },
+	"currentUser": func() string { return "" },
+	"ctfEnabled":  func() bool { return false },
+	"newFlags":    func() []capturedFlag { return nil },
+}
+
+func parsePages(fsys fs.FS) (pageSet, error) {
+	layout, err := template.New("").Funcs(templateStubs).ParseFS(fsys, "templates/layout/*.html")
+	if err != nil {
+		return nil, err
+	}
+
+	files, err := fs.Glob(fsys, "templates/pages/*.html")
+	if err != nil {
+		return nil, err
+	}
+	set := pageSet{}
+	for _, file := range files {
+		page, err := layout.Clone()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := page.ParseFS(fsys, file); err != nil {
+			return nil, err
+		}
+		set[strings.TrimSuffix(path.Base(file), ".html")] = page
+	}
+	return set, nil
+}
+
+func mustParsePages(fsys fs.FS) pageSet {
+	set, err := parsePages(fsys)
+	if err != nil {
+		panic(fmt.Sprintf("parsing templates: %v", err))
+	}
+	return set
+}
+
+// render writes the named page with a 200 status
+func (s *Server) render(w http.ResponseWriter, r *http.Request, name string, data any) {
+	s.renderStatus(w, r, http.StatusOK, name, data)
+}
+
+// renderStatus executes the named page into a buffer and only writes it out
+// once it rendered completely, so a template error becomes a logged 500
+// rather than half a page
+func (s *Server) renderStatus(w http.ResponseWriter, r *http.Request, status int, name string, data any) {
+	var buf bytes.Buffer
+	if err := s.executePage(&buf, w, r, name, data); err != nil {
+		log.Printf("Rendering %s: %v", name, err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	buf.WriteTo(w)
+}
+
+func (s *Server) executePage(buf *bytes.Buffer, w http.ResponseWriter, r *http.Request, name string, data any) error {
+	return s.executeTemplate(buf, w, r, name, "layout", data)
+}
+
+// executeTemplate runs one template defined by the named page, such as a
+// fragment that is also sent on its own after the page has loaded
+func (s *Server) executeTemplate(buf *bytes.Buffer, w http.ResponseWriter, r *http.Request, name, tmpl string, data any) error {
+	set := pages
+	if dev := s.devPages.Load(); dev != nil {
+		set = *dev
+	}
+	page, ok := set[name]
+	if !ok {
+		return fmt.Errorf("no page named %q", name)
+	}
+	// Clone so this request's functions don't leak into other requests
+	t, err := page.Clone()
+	if err != nil {
+		return err
+	}
+	return t.Funcs(s.pageFuncs(w, r)).ExecuteTemplate(buf, tmpl, data)
+}
+
+// pageFuncs provides the per-request template functions: {{csrfField}},
+// {{currentUser}}, the name the visitor is signed in as, and the CTF ones
+func (s *Server) pageFuncs(w http.ResponseWriter, r *http.Request) template.FuncMap {
+	funcs := s.csrfFuncs(w, r)
+	funcs["currentUser"] = func() string {
+		session, _ := s.currentSession(r)
+		return session.Username
+	}
+	for name, fn := range s.ctfFuncs(r) {
+		funcs[name] = fn
+	}
+	return funcs
+}
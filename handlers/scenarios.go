@@ -0,0 +1,27 @@
+package handlers
+
+import "secure-webapp/scenario"
+
+// registerScenarios adds the built-in vulnerable/secure pairs to the
+// server's registry, in the order the home page lists them. Each pair's
+// definition sits with its vulnerable handlers. Secure routes that change
+// state are wrapped in s.CSRF; the payment webhooks are authenticated by
+// their signature instead.
+func (s *Server) registerScenarios() {
+	for _, define := range []func() scenario.Definition{
+		s.priceScenario,
+		s.quantityScenario,
+		s.currencyScenario,
+		s.couponScenario,
+		s.orderScenario,
+		s.raceScenario,
+		s.webhookScenario,
+		s.sessionScenario,
+		s.idorScenario,
+		s.csrfScenario,
+		s.apiScenario,
+		s.massAssignmentScenario,
+	} {
+		s.Scenarios.MustRegister(define())
+	}
+}
@@ -0,0 +1,234 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"secure-webapp/gateway"
+	"secure-webapp/models"
+)
+
+// Secure API: /api/v1/secure/...
+
+type secureAPIItemRequest struct {
+	ProductID string `json:"product_id"`
+	Quantity  int    `json:"quantity"`
+}
+
+type secureAPIPayRequest struct {
+	CardNumber string `json:"card_number"`
+}
+
+type secureAPIPayResponse struct {
+	Order  apiOrder `json:"order"`
+	Charge struct {
+		ID     string               `json:"id"`
+		Status gateway.ChargeStatus `json:"status"`
+	} `json:"charge"`
+	// NextAction is the 3-D Secure page to send the customer to, if any
+	NextAction string `json:"next_action,omitempty"`
+}
+
+func (s *Server) SecureAPIProductsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		methodNotAllowed(w, "GET")
+		return
+	}
+	writeJSON(w, http.StatusOK, s.apiProducts())
+}
+
+func (s *Server) SecureAPICartHandler(w http.ResponseWriter, r *http.Request) {
+	sessionID := s.getOrCreateSession(w, r)
+
+	switch r.Method {
+	case "GET":
+		writeJSON(w, http.StatusOK, toAPICart(s.Store.GetCart(sessionID, cartSecureAPI)))
+	case "DELETE":
+		if !requireJSON(w, r) {
+			return
+		}
+		s.Store.ClearCart(sessionID, cartSecureAPI)
+		writeJSON(w, http.StatusOK, toAPICart(models.Cart{}))
+	default:
+		methodNotAllowed(w, "GET, DELETE")
+	}
+}
+
+func (s *Server) SecureAPICartItemsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		methodNotAllowed(w, "POST")
+		return
+	}
+	if !requireJSON(w, r) {
+		return
+	}
+
+	sessionID := s.getOrCreateSession(w, r)
+	var req secureAPIItemRequest
+	if !decodeStrictJSON(w, r, &req) {
+		return
+	}
+
+	if req.Quantity < 1 || req.Quantity > maxLineQuantity {
+		writeAPIError(w, http.StatusBadRequest, "invalid_quantity", shopMessages["invalid_quantity"])
+		return
+	}
+
+	// SECURITY: The price always comes from the catalog; the request has no
+	// price field at all
+	product, exists := s.productOnSale(req.ProductID)
+	if !exists {
+		writeAPIError(w, http.StatusNotFound, "product_not_found", "no such product")
+		return
+	}
+	if req.Quantity > product.Available() {
+		writeAPIError(w, http.StatusConflict, "out_of_stock", shopMessages["out_of_stock"])
+		return
+	}
+
+	cart := s.Store.GetCart(sessionID, cartSecureAPI)
+	cart.Items = append(cart.Items, models.CartItem{ProductID: product.ID, Quantity: req.Quantity, Price: product.Price})
+	total, err := models.SumItems(cart.Items)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "total_out_of_range", "cart total out of range")
+		return
+	}
+	cart.Total = total
+
+	s.Store.SetCart(sessionID, cartSecureAPI, cart)
+	writeJSON(w, http.StatusCreated, toAPICart(cart))
+}
+
+func (s *Server) SecureAPIOrdersHandler(w http.ResponseWriter, r *http.Request) {
+	sessionID := s.getOrCreateSession(w, r)
+	session, _ := s.Store.GetSession(sessionID)
+
+	switch r.Method {
+	case "GET":
+		writeJSON(w, http.StatusOK, s.apiOrders(cartSecureAPI, session.UserID))
+
+	case "POST":
+		if !requireJSON(w, r) {
+			return
+		}
+		cart := s.Store.GetCart(sessionID, cartSecureAPI)
+		if len(cart.Items) == 0 {
+			writeAPIError(w, http.StatusConflict, "cart_empty", "cart is empty")
+			return
+		}
+
+		order, ok := s.placeSecureAPIOrder(w, session.UserID, cart.Items)
+		if !ok {
+			return
+		}
+		s.Store.ClearCart(sessionID, cartSecureAPI)
+
+		writeJSON(w, http.StatusCreated, toAPIOrder(order))
+
+	default:
+		methodNotAllowed(w, "GET, POST")
+	}
+}
+
+// placeSecureAPIOrder creates a pending order for items, repricing every
+// line from the catalog and reserving its stock until the order is paid
+func (s *Server) placeSecureAPIOrder(w http.ResponseWriter, userID string, items []models.CartItem) (models.Order, bool) {
+	// SECURITY: Reprice every line from the catalog rather than trusting
+	// what the client or the cart recorded
+	priced := make([]models.CartItem, len(items))
+	for i, item := range items {
+		product, exists := s.productOnSale(item.ProductID)
+		if !exists {
+			writeAPIError(w, http.StatusNotFound, "product_not_found", "no such product")
+			return models.Order{}, false
+		}
+		if item.Quantity < 1 || item.Quantity > maxLineQuantity {
+			writeAPIError(w, http.StatusBadRequest, "invalid_quantity", shopMessages["invalid_quantity"])
+			return models.Order{}, false
+		}
+		priced[i] = models.CartItem{ProductID: item.ProductID, Quantity: item.Quantity, Price: product.Price}
+	}
+	total, err := models.SumItems(priced)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "total_out_of_range", "order total out of range")
+		return models.Order{}, false
+	}
+
+	if err := s.Store.ReserveStock(priced); err != nil {
+		writeAPIError(w, http.StatusConflict, "out_of_stock", shopMessages["out_of_stock"])
+		return models.Order{}, false
+	}
+	order := models.NewOrder(cartSecureAPI, userID, priced, total, "customer")
+	order.ReservedUntil = order.Timestamp.Add(reservationTTL)
+	s.Store.SetOrder(order)
+	return order, true
+}
+
+func (s *Server) SecureAPIOrderHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		methodNotAllowed(w, "GET")
+		return
+	}
+
+	// SECURITY: Only the owner's orders exist as far as the API is concerned
+	sessionID := s.getOrCreateSession(w, r)
+	order, exists := s.ownedOrder(cartSecureAPI, sessionID, r.PathValue("id"))
+	if !exists {
+		writeAPIError(w, http.StatusNotFound, "order_not_found", "no such order")
+		return
+	}
+	writeJSON(w, http.StatusOK, toAPIOrder(order))
+}
+
+func (s *Server) SecureAPIPayHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		methodNotAllowed(w, "POST")
+		return
+	}
+	if !requireJSON(w, r) {
+		return
+	}
+
+	var req secureAPIPayRequest
+	if !decodeStrictJSON(w, r, &req) {
+		return
+	}
+
+	sessionID := s.getOrCreateSession(w, r)
+	order, exists := s.ownedOrder(cartSecureAPI, sessionID, r.PathValue("id"))
+	if !exists {
+		writeAPIError(w, http.StatusNotFound, "order_not_found", "no such order")
+		return
+	}
+	if order.Status != models.StatusPending {
+		writeAPIError(w, http.StatusConflict, "invalid_status", "order is no longer awaiting payment")
+		return
+	}
+
+	// SECURITY: The card is charged through the gateway and the order only
+	// becomes paid when its signed webhook arrives
+	resultURL := "/secure-order/result?order_id=" + order.ID
+	order, charge, err := s.payOrder(order.ID, req.CardNumber, resultURL)
+	switch {
+	case errors.Is(err, errNotPayable):
+		writeAPIError(w, http.StatusConflict, "invalid_status", "order is no longer awaiting payment")
+		return
+	case errors.Is(err, gateway.ErrInvalidCard):
+		writeAPIError(w, http.StatusBadRequest, "invalid_card", err.Error())
+		return
+	case errors.Is(err, gateway.ErrCardDeclined):
+		writeAPIError(w, http.StatusPaymentRequired, "card_declined", err.Error())
+		return
+	case err != nil:
+		writeAPIError(w, http.StatusBadGateway, "payment_failed", err.Error())
+		return
+	}
+
+	var resp secureAPIPayResponse
+	resp.Order = toAPIOrder(order)
+	resp.Charge.ID = charge.ID
+	resp.Charge.Status = charge.Status
+	if charge.Status == gateway.ChargeRequiresAction {
+		resp.NextAction = "/gateway/3ds?charge_id=" + charge.ID
+	}
+	writeJSON(w, http.StatusAccepted, resp)
+}
@@ -0,0 +1,217 @@
+package handlers
+
+import (
+	"net/http"
+	"secure-webapp/models"
+	"time"
+)
+
+func (s *Server) SecureCouponHandler(w http.ResponseWriter, r *http.Request) {
+	s.renderCouponShop(w, r, "secure", "Secure Coupon Shop",
+		"Coupons are re-validated at checkout, follow the stacking rules and their limits are enforced atomically!")
+}
+
+// priceSecureCart looks the cart's coupons up again and reprices it under the
+// stacking rules
+func (s *Server) priceSecureCart(cart *models.Cart, now time.Time) error {
+	coupons := make([]models.Coupon, len(cart.Coupons))
+	for i, code := range cart.Coupons {
+		coupon, exists := s.Store.GetCoupon(code)
+		if !exists {
+			return models.ErrCouponUnknown
+		}
+		coupons[i] = coupon
+	}
+	_, discount, total, err := models.PriceCart(cart.Items, coupons, now)
+	if err != nil {
+		return err
+	}
+	cart.Discount = discount
+	cart.Total = total
+	return nil
+}
+
+// needsAccount reports whether any of codes is limited per customer while
+// the session is anonymous. An anonymous UserID is thrown away with the
+// cookie, so only an account can carry a per-user limit.
+func (s *Server) needsAccount(session models.Session, codes []string) bool {
+	if session.Username != "" {
+		return false
+	}
+	for _, code := range codes {
+		if coupon, exists := s.Store.GetCoupon(code); exists && coupon.PerUserLimit > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Server) SecureCouponAddToCartHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Redirect(w, r, "/secure-coupon", http.StatusSeeOther)
+		return
+	}
+
+	sessionID := s.getOrCreateSession(w, r)
+	quantity, ok := parseQuantity(r.FormValue("quantity"))
+	if !ok {
+		http.Redirect(w, r, "/secure-coupon?error=invalid_quantity", http.StatusSeeOther)
+		return
+	}
+	product, exists := s.productOnSale(r.FormValue("product_id"))
+	if !exists {
+		http.Redirect(w, r, "/secure-coupon", http.StatusSeeOther)
+		return
+	}
+
+	cart := s.Store.GetCart(sessionID, cartSecureCoupon)
+	cart.Items = append(cart.Items, models.CartItem{ProductID: product.ID, Quantity: quantity, Price: product.Price})
+	if err := s.priceSecureCart(&cart, time.Now()); err != nil {
+		// A coupon that no longer applies is dropped rather than kept stale
+		cart.Coupons = nil
+		if err := s.priceSecureCart(&cart, time.Now()); err != nil {
+			http.Error(w, "Cart total out of range", http.StatusBadRequest)
+			return
+		}
+	}
+
+	s.Store.SetCart(sessionID, cartSecureCoupon, cart)
+	http.Redirect(w, r, "/secure-coupon", http.StatusSeeOther)
+}
+
+func (s *Server) SecureCouponApplyHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Redirect(w, r, "/secure-coupon", http.StatusSeeOther)
+		return
+	}
+
+	sessionID := s.getOrCreateSession(w, r)
+	session, _ := s.Store.GetSession(sessionID)
+	coupon, exists := s.Store.GetCoupon(normalizeCouponCode(r.FormValue("code")))
+	if !exists {
+		http.Redirect(w, r, "/secure-coupon?error=coupon_unknown", http.StatusSeeOther)
+		return
+	}
+
+	// SECURITY: Per-customer limits are counted against the account
+	if s.needsAccount(session, []string{coupon.Code}) {
+		http.Redirect(w, r, "/secure-coupon?error=coupon_sign_in", http.StatusSeeOther)
+		return
+	}
+
+	// Tell the customer now if the coupon is used up; the binding check
+	// happens at checkout
+	used, usedByUser := s.Store.CouponUsage(cartSecureCoupon, coupon.Code, session.UserID)
+	if (coupon.GlobalLimit > 0 && used >= coupon.GlobalLimit) || (coupon.PerUserLimit > 0 && usedByUser >= coupon.PerUserLimit) {
+		http.Redirect(w, r, "/secure-coupon?error=coupon_limit", http.StatusSeeOther)
+		return
+	}
+
+	// SECURITY: The whole cart is repriced with the new coupon, which
+	// enforces expiry, minimum spend, duplicates and stacking rules
+	cart := s.Store.GetCart(sessionID, cartSecureCoupon)
+	cart.Coupons = append(append([]string(nil), cart.Coupons...), coupon.Code)
+	if err := s.priceSecureCart(&cart, time.Now()); err != nil {
+		http.Redirect(w, r, "/secure-coupon?error="+couponErrorCode(err), http.StatusSeeOther)
+		return
+	}
+
+	s.Store.SetCart(sessionID, cartSecureCoupon, cart)
+	http.Redirect(w, r, "/secure-coupon", http.StatusSeeOther)
+}
+
+func (s *Server) SecureCouponRemoveHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Redirect(w, r, "/secure-coupon", http.StatusSeeOther)
+		return
+	}
+
+	sessionID := s.getOrCreateSession(w, r)
+	cart := s.Store.GetCart(sessionID, cartSecureCoupon)
+	cart.Coupons = nil
+	if err := s.priceSecureCart(&cart, time.Now()); err != nil {
+		http.Error(w, "Cart total out of range", http.StatusBadRequest)
+		return
+	}
+
+	s.Store.SetCart(sessionID, cartSecureCoupon, cart)
+	http.Redirect(w, r, "/secure-coupon", http.StatusSeeOther)
+}
+
+func (s *Server) SecureCouponCheckoutHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Redirect(w, r, "/secure-coupon", http.StatusSeeOther)
+		return
+	}
+
+	sessionID := s.getOrCreateSession(w, r)
+	cart := s.Store.GetCart(sessionID, cartSecureCoupon)
+	if len(cart.Items) == 0 {
+		http.Redirect(w, r, "/secure-coupon", http.StatusSeeOther)
+		return
+	}
+
+	// SECURITY: Reprice from scratch at today's catalog prices - a product
+	// may have been repriced or archived, or a coupon expired, since it went
+	// in the cart, and the stored total is never trusted
+	now := time.Now()
+	items, onSale := s.atCatalogPrices(cart.Items)
+	cart.Items = items
+	if !onSale {
+		if err := s.priceSecureCart(&cart, now); err != nil {
+			cart.Coupons = nil
+			if err := s.priceSecureCart(&cart, now); err != nil {
+				http.Error(w, "Cart total out of range", http.StatusBadRequest)
+				return
+			}
+		}
+		s.Store.SetCart(sessionID, cartSecureCoupon, cart)
+		http.Redirect(w, r, "/secure-coupon?error=not_on_sale", http.StatusSeeOther)
+		return
+	}
+	if err := s.priceSecureCart(&cart, now); err != nil {
+		http.Redirect(w, r, "/secure-coupon?error="+couponErrorCode(err), http.StatusSeeOther)
+		return
+	}
+
+	session, _ := s.Store.GetSession(sessionID)
+	if s.needsAccount(session, cart.Coupons) {
+		http.Redirect(w, r, "/secure-coupon?error=coupon_sign_in", http.StatusSeeOther)
+		return
+	}
+
+	if err := s.Store.ReserveStock(cart.Items); err != nil {
+		http.Redirect(w, r, "/secure-coupon?error=out_of_stock", http.StatusSeeOther)
+		return
+	}
+
+	order := models.NewOrder(cartSecureCoupon, session.UserID, cart.Items, cart.Total, "customer")
+	order.Coupons = cart.Coupons
+	order.Discount = cart.Discount
+
+	// SECURITY: Limits are checked and the redemptions recorded in one
+	// atomic step, so two checkouts can't both take the last use
+	if err := s.Store.RedeemCoupons(cartSecureCoupon, cart.Coupons, session.UserID, order.ID, now); err != nil {
+		s.Store.ReleaseStock(cart.Items)
+		http.Redirect(w, r, "/secure-coupon?error="+couponErrorCode(err), http.StatusSeeOther)
+		return
+	}
+	s.Store.CommitStock(cart.Items)
+
+	order = s.completeInstantOrder(order, "customer")
+	s.Store.ClearCart(sessionID, cartSecureCoupon)
+
+	http.Redirect(w, r, "/secure-coupon/order?order_id="+order.ID, http.StatusSeeOther)
+}
+
+func (s *Server) SecureCouponOrderHandler(w http.ResponseWriter, r *http.Request) {
+	sessionID := s.getOrCreateSession(w, r)
+
+	order, exists := s.ownedOrder(cartSecureCoupon, sessionID, r.URL.Query().Get("order_id"))
+	if !exists {
+		http.Error(w, "Order not found", http.StatusNotFound)
+		return
+	}
+
+	s.renderCouponOrder(w, r, order, "secure")
+}
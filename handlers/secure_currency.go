@@ -0,0 +1,132 @@
+package handlers
+
+import (
+	"net/http"
+	"secure-webapp/models"
+)
+
+func (s *Server) SecureCurrencyHandler(w http.ResponseWriter, r *http.Request) {
+	sessionID := s.getOrCreateSession(w, r)
+	cart := s.Store.GetCart(sessionID, cartSecureCurrency)
+	currency := s.displayCurrency(sessionID)
+
+	displayTotal, _, _ := s.Rates.Convert(cart.Total, currency)
+
+	data := struct {
+		Products     map[string]pricedProduct
+		Cart         models.Cart
+		Currencies   []string
+		Currency     string
+		DisplayTotal models.Money
+		Error        string
+	}{
+		Products:     s.displayProducts(currency),
+		Cart:         cart,
+		Currencies:   s.Rates.Currencies(),
+		Currency:     currency,
+		DisplayTotal: displayTotal,
+		Error:        shopMessages[r.URL.Query().Get("error")],
+	}
+
+	s.render(w, r, "secure-currency", data)
+}
+
+func (s *Server) SecureCurrencyAddToCartHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Redirect(w, r, "/secure-currency", http.StatusSeeOther)
+		return
+	}
+
+	sessionID := s.getOrCreateSession(w, r)
+	productID := r.FormValue("product_id")
+	quantity, ok := parseQuantity(r.FormValue("quantity"))
+	if !ok {
+		http.Redirect(w, r, "/secure-currency?error=invalid_quantity", http.StatusSeeOther)
+		return
+	}
+
+	product, exists := s.productOnSale(productID)
+	if !exists {
+		http.Redirect(w, r, "/secure-currency", http.StatusSeeOther)
+		return
+	}
+
+	cart := s.Store.GetCart(sessionID, cartSecureCurrency)
+	cart.Items = append(cart.Items, models.CartItem{
+		ProductID: productID,
+		Quantity:  quantity,
+		Price:     product.Price,
+	})
+
+	// Recalculate total
+	total, err := models.SumItems(cart.Items)
+	if err != nil {
+		http.Error(w, "Cart total out of range", http.StatusBadRequest)
+		return
+	}
+	cart.Total = total
+
+	s.Store.SetCart(sessionID, cartSecureCurrency, cart)
+	http.Redirect(w, r, "/secure-currency", http.StatusSeeOther)
+}
+
+func (s *Server) SecureCurrencyCheckoutHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Redirect(w, r, "/secure-currency", http.StatusSeeOther)
+		return
+	}
+
+	sessionID := s.getOrCreateSession(w, r)
+	cart := s.Store.GetCart(sessionID, cartSecureCurrency)
+
+	if len(cart.Items) == 0 {
+		http.Redirect(w, r, "/secure-currency", http.StatusSeeOther)
+		return
+	}
+
+	// Re-price every line from the catalog in the base currency
+	validatedItems, onSale := s.atCatalogPrices(cart.Items)
+	baseTotal, err := models.SumItems(validatedItems)
+	if err != nil {
+		http.Error(w, "Order total out of range", http.StatusBadRequest)
+		return
+	}
+	if !onSale {
+		cart.Items, cart.Total = validatedItems, baseTotal
+		s.Store.SetCart(sessionID, cartSecureCurrency, cart)
+		http.Redirect(w, r, "/secure-currency?error=not_on_sale", http.StatusSeeOther)
+		return
+	}
+
+	// SECURITY: Currency comes from the session and the rate from the server's
+	// table; the whole order is converted and rounded exactly once
+	currency := s.displayCurrency(sessionID)
+	charged, rate, err := s.Rates.Convert(baseTotal, currency)
+	if err != nil {
+		http.Error(w, "Unsupported currency", http.StatusBadRequest)
+		return
+	}
+
+	if err := s.Store.ReserveStock(validatedItems); err != nil {
+		http.Redirect(w, r, "/secure-currency?error=out_of_stock", http.StatusSeeOther)
+		return
+	}
+	s.Store.CommitStock(validatedItems)
+
+	session, _ := s.Store.GetSession(sessionID)
+	order := models.NewOrder(cartSecureCurrency, session.UserID, validatedItems, charged, "customer")
+	order.BaseTotal = baseTotal
+	order.ExchangeRate = models.FormatRate(rate)
+	order = s.completeInstantOrder(order, "customer")
+
+	// Clear cart after checkout
+	s.Store.ClearCart(sessionID, cartSecureCurrency)
+
+	data := struct {
+		Order models.Order
+	}{
+		Order: order,
+	}
+
+	s.render(w, r, "secure-currency-checkout", data)
+}
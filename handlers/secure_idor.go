@@ -0,0 +1,47 @@
+package handlers
+
+import (
+	"net/http"
+	"secure-webapp/models"
+)
+
+func (s *Server) SecureIDORHandler(w http.ResponseWriter, r *http.Request) {
+	s.renderIDORShop(w, r, "secure", "Secure Order Lookup Shop",
+		"Orders have unguessable IDs and are only shown to the customer who placed them!")
+}
+
+func (s *Server) SecureIDORBuyHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Redirect(w, r, "/secure-idor", http.StatusSeeOther)
+		return
+	}
+
+	sessionID := s.getOrCreateSession(w, r)
+	product, quantity, ok := s.parseIDORPurchase(r)
+	if !ok {
+		http.Redirect(w, r, "/secure-idor", http.StatusSeeOther)
+		return
+	}
+
+	order, err := s.buyIDOROrder(shopSecureIDOR, sessionID, models.GenerateID(), product, quantity)
+	if err != nil {
+		http.Redirect(w, r, "/secure-idor?error=out_of_stock", http.StatusSeeOther)
+		return
+	}
+
+	http.Redirect(w, r, "/secure-idor/order?id="+order.ID, http.StatusSeeOther)
+}
+
+func (s *Server) SecureIDOROrderHandler(w http.ResponseWriter, r *http.Request) {
+	sessionID := s.getOrCreateSession(w, r)
+
+	// SECURITY: The ownership check is what protects the order; random IDs
+	// only make guessing harder
+	order, exists := s.ownedOrder(shopSecureIDOR, sessionID, r.URL.Query().Get("id"))
+	if !exists {
+		http.Error(w, "Order not found", http.StatusNotFound)
+		return
+	}
+
+	s.renderIDOROrder(w, r, order, "secure")
+}
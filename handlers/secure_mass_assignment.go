@@ -0,0 +1,52 @@
+package handlers
+
+import (
+	"net/http"
+	"secure-webapp/models"
+)
+
+// secureExpressOrderRequest is everything a client may say about a new
+// order. Status, totals, prices and ownership are the server's business.
+type secureExpressOrderRequest struct {
+	Items []secureAPIItemRequest `json:"items"`
+}
+
+func (s *Server) SecureMassAssignmentHandler(w http.ResponseWriter, r *http.Request) {
+	s.renderMassAssignmentShop(w, r, "secure")
+}
+
+func (s *Server) SecureExpressOrderHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		methodNotAllowed(w, "POST")
+		return
+	}
+	if !requireJSON(w, r) {
+		return
+	}
+
+	// SECURITY: The body is decoded into an input DTO that only has items,
+	// and a field it doesn't declare fails the request instead of being
+	// dropped silently
+	var req secureExpressOrderRequest
+	if !decodeStrictJSON(w, r, &req) {
+		return
+	}
+	if len(req.Items) == 0 {
+		writeAPIError(w, http.StatusBadRequest, "no_items", "order has no items")
+		return
+	}
+
+	sessionID := s.getOrCreateSession(w, r)
+	session, _ := s.Store.GetSession(sessionID)
+
+	items := make([]models.CartItem, len(req.Items))
+	for i, item := range req.Items {
+		items[i] = models.CartItem{ProductID: item.ProductID, Quantity: item.Quantity}
+	}
+	order, ok := s.placeSecureAPIOrder(w, session.UserID, items)
+	if !ok {
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, toAPIOrder(order))
+}
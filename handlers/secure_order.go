@@ -1,96 +1,58 @@
 package handlers
 
 import (
+	"errors"
 	"fmt"
-	"html/template"
+	"log"
 	"net/http"
+	"secure-webapp/gateway"
 	"secure-webapp/models"
-	"strconv"
-	"time"
 )
 
-func SecureOrderHandler(w http.ResponseWriter, r *http.Request) {
-	sessionID := getOrCreateSession(w, r)
-	cart := models.GetCart(sessionID)
-
-	tmpl := `
-<!DOCTYPE html>
-<html>
-<head>
-    <title>Secure Order Shop</title>
-    <link rel="stylesheet" href="/static/style.css">
-</head>
-<body>
-    <div class="container">
-        <h1>Secure Order Processing Shop</h1>
-		<p class="success">Orders are confirmed only after payment is completed and the payment gateway sends a confirmation webhook!</p>
-
-        <div class="products">
-            <h2>Products</h2>
-            {{range $id, $product := .Products}}
-            <div class="product">
-                <h3>{{$product.Name}}</h3>
-                <p>Price: ${{printf "%.2f" $product.Price}}</p>
-                <form method="POST" action="/secure-order/add-to-cart">
-                    <input type="hidden" name="product_id" value="{{$product.ID}}">
-                    <input type="number" name="quantity" value="1" min="1">
-                    <button type="submit">Add to Cart</button>
-                </form>
-            </div>
-            {{end}}
-        </div>
-
-        <div class="cart">
-            <h2>Cart</h2>
-            {{if .Cart.Items}}
-                {{range .Cart.Items}}
-                <div class="cart-item">
-                    <p>Product ID: {{.ProductID}} - Quantity: {{.Quantity}} - Price: ${{printf "%.2f" .Price}}</p>
-                </div>
-                {{end}}
-                <p><strong>Total: ${{printf "%.2f" .Cart.Total}}</strong></p>
-                <form method="POST" action="/secure-order/checkout">
-                    <button type="submit">Checkout</button>
-                </form>
-            {{else}}
-                <p>Cart is empty</p>
-            {{end}}
-        </div>
-        
-        <a href="/">Back to Home</a>
-    </div>
-</body>
-</html>`
+func (s *Server) SecureOrderHandler(w http.ResponseWriter, r *http.Request) {
+	sessionID := s.getOrCreateSession(w, r)
+	cart := s.Store.GetCart(sessionID, cartSecureOrder)
 
 	data := struct {
 		Products map[string]models.Product
 		Cart     models.Cart
+		Error    string
 	}{
-		Products: models.Products,
+		Products: s.catalog(),
 		Cart:     cart,
+		Error:    shopMessages[r.URL.Query().Get("error")],
 	}
 
-	t, _ := template.New("secure-order").Parse(tmpl)
-	t.Execute(w, data)
+	s.render(w, r, "secure-order", data)
 }
 
-func SecureAddToCartHandler(w http.ResponseWriter, r *http.Request) {
+func (s *Server) SecureAddToCartHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != "POST" {
 		http.Redirect(w, r, "/secure-order", http.StatusSeeOther)
 		return
 	}
 
-	sessionID := getOrCreateSession(w, r)
+	sessionID := s.getOrCreateSession(w, r)
 	productID := r.FormValue("product_id")
-	quantity, _ := strconv.Atoi(r.FormValue("quantity"))
+	// SECURITY: Only whole quantities from 1 to 10; negative or huge values
+	// would produce negative or overflowing totals
+	quantity, ok := parseQuantity(r.FormValue("quantity"))
+	if !ok {
+		http.Redirect(w, r, "/secure-order?error=invalid_quantity", http.StatusSeeOther)
+		return
+	}
 
-	product, exists := models.GetProduct(productID)
+	product, exists := s.productOnSale(productID)
 	if !exists {
 		http.Redirect(w, r, "/secure-order", http.StatusSeeOther)
 		return
 	}
+	if quantity > product.Available() {
+		http.Redirect(w, r, "/secure-order?error=out_of_stock", http.StatusSeeOther)
+		return
+	}
 
-	cart := models.GetCart(sessionID)
+	cart := s.Store.GetCart(sessionID, cartSecureOrder)
 	cart.Items = append(cart.Items, models.CartItem{
 		ProductID: productID,
 		Quantity:  quantity,
@@ -98,50 +60,77 @@ func SecureAddToCartHandler(w http.ResponseWriter, r *http.Request) {
 	})
 
 	// Recalculate total
-	cart.Total = 0
-	for _, item := range cart.Items {
-		cart.Total += item.Price * float64(item.Quantity)
+	total, err := models.SumItems(cart.Items)
+	if err != nil {
+		http.Error(w, "Cart total out of range", http.StatusBadRequest)
+		return
 	}
+	cart.Total = total
 
-	models.SetCart(sessionID, cart)
+	s.Store.SetCart(sessionID, cartSecureOrder, cart)
 	http.Redirect(w, r, "/secure-order", http.StatusSeeOther)
 }
 
-func SecureCheckoutHandler(w http.ResponseWriter, r *http.Request) {
+func (s *Server) SecureCheckoutHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != "POST" {
 		http.Redirect(w, r, "/secure-order", http.StatusSeeOther)
 		return
 	}
 
-	sessionID := getOrCreateSession(w, r)
-	cart := models.GetCart(sessionID)
+	sessionID := s.getOrCreateSession(w, r)
+	cart := s.Store.GetCart(sessionID, cartSecureOrder)
 
 	if len(cart.Items) == 0 {
 		http.Redirect(w, r, "/secure-order", http.StatusSeeOther)
 		return
 	}
 
-	// Create order
-	orderID := models.GenerateID()
-	userID, _ := models.GetSession(sessionID)
-
-	order := models.Order{
-		ID:        orderID,
-		UserID:    userID,
-		Items:     cart.Items,
-		Total:     cart.Total,
-		Status:    "pending",
-		Timestamp: time.Now(),
+	// SECURITY: Charge what the catalog asks now, not what the cart saved
+	items, onSale := s.atCatalogPrices(cart.Items)
+	total, err := models.SumItems(items)
+	if err != nil {
+		http.Error(w, "Cart total out of range", http.StatusBadRequest)
+		return
+	}
+	if !onSale {
+		cart.Items, cart.Total = items, total
+		s.Store.SetCart(sessionID, cartSecureOrder, cart)
+		http.Redirect(w, r, "/secure-order?error=not_on_sale", http.StatusSeeOther)
+		return
 	}
 
-	models.SetOrder(order)
+	// Hold the stock for the whole order until it is paid, cancelled or expires
+	if err := s.Store.ReserveStock(items); err != nil {
+		http.Redirect(w, r, "/secure-order?error=out_of_stock", http.StatusSeeOther)
+		return
+	}
+
+	// Create order
+	session, _ := s.Store.GetSession(sessionID)
+	order := models.NewOrder(cartSecureOrder, session.UserID, items, total, "customer")
+	order.ReservedUntil = order.Timestamp.Add(reservationTTL)
+
+	s.Store.SetOrder(order)
 
 	// Redirect to payment page
-	http.Redirect(w, r, fmt.Sprintf("/secure-order/pay?order_id=%s", orderID), http.StatusSeeOther)
+	http.Redirect(w, r, fmt.Sprintf("/secure-order/pay?order_id=%s", order.ID), http.StatusSeeOther)
+}
+
+type testCard struct {
+	Number  string
+	Outcome string
+}
+
+// testCards are listed on the payment page so the gateway outcomes can be tried
+var testCards = []testCard{
+	{gateway.CardApproveBasic, "approved"},
+	{gateway.CardDecline, "declined"},
+	{gateway.CardRequires3DS, "requires 3-D Secure"},
+	{gateway.CardTimeout, "gateway never answers"},
 }
 
 // Payment page - shows form and handles POST
-func SecurePayHandler(w http.ResponseWriter, r *http.Request) {
+func (s *Server) SecurePayHandler(w http.ResponseWriter, r *http.Request) {
 	var orderID string
 
 	if r.Method == "POST" {
@@ -157,129 +146,152 @@ func SecurePayHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	order, exists := models.GetOrder(orderID)
+	// SECURITY: Only the customer who placed the order can pay for it
+	sessionID := s.getOrCreateSession(w, r)
+	order, exists := s.ownedOrder(cartSecureOrder, sessionID, orderID)
 	if !exists {
 		http.Error(w, "Order not found", http.StatusNotFound)
 		return
 	}
 
-	if r.Method == "POST" {
+	// Only an order nobody has tried to pay yet can be paid; cancelled and
+	// expired orders have already given their stock back
+	if order.Status != models.StatusPending {
+		http.Error(w, "Order is no longer awaiting payment", http.StatusConflict)
+		return
+	}
 
-		go func(orderID string) {
-			time.Sleep(3 * time.Second)
-			order, exists := models.GetOrder(orderID)
-			if exists {
-				order.Status = "completed"
-				models.SetOrder(order)
+	var paymentError string
+	if r.Method == "POST" {
+		resultURL := fmt.Sprintf("/secure-order/result?order_id=%s", orderID)
+
+		// SECURITY: The card is charged through the gateway; the order is only
+		// marked paid when the gateway's signed webhook says so
+		_, charge, err := s.payOrder(orderID, r.FormValue("card_number"), resultURL)
+		if errors.Is(err, errNotPayable) {
+			http.Error(w, "Order is no longer awaiting payment", http.StatusConflict)
+			return
+		}
+		if err == nil {
+			s.Store.ClearCart(sessionID, cartSecureOrder)
+
+			if charge.Status == gateway.ChargeRequiresAction {
+				http.Redirect(w, r, "/gateway/3ds?charge_id="+charge.ID, http.StatusSeeOther)
+				return
 			}
-		}(orderID)
+			http.Redirect(w, r, resultURL, http.StatusSeeOther)
+			return
+		}
 
-		sessionID := getOrCreateSession(w, r)
-		models.ClearCart(sessionID)
-
-		http.Redirect(w, r, fmt.Sprintf("/secure-order/result?order_id=%s", orderID), http.StatusSeeOther)
-		return
+		// Declined or invalid cards leave the order pending so another card
+		// can be tried
+		paymentError = "Payment failed: " + err.Error()
 	}
 
 	// Show payment form (GET request)
-	tmpl := `
-<!DOCTYPE html>
-<html>
-<head>
-    <title>Payment - Secure Shop</title>
-    <link rel="stylesheet" href="/static/style.css">
-</head>
-<body>
-    <div class="container">
-        <h1>Payment Page</h1>
-        <p>Order ID: {{.OrderID}}</p>
-        <p>Total: ${{printf "%.2f" .Total}}</p>
-        
-        <form method="POST" action="/secure-order/pay">
-            <input type="hidden" name="order_id" value="{{.OrderID}}">
-            <h3>Payment Details</h3>
-            <div>
-                <label>Card Number:</label>
-                <input type="text" name="card_number" placeholder="1234-5678-9012-3456" required>
-            </div>
-            <div>
-                <label>CVV:</label>
-                <input type="text" name="cvv" placeholder="123" required>
-            </div>
-            <button type="submit">Pay Now</button>
-        </form>
-    </div>
-</body>
-</html>`
-
 	data := struct {
 		OrderID string
-		Total   float64
+		Total   models.Money
+		Error   string
+		Cards   []testCard
 	}{
 		OrderID: orderID,
 		Total:   order.Total,
+		Error:   paymentError,
+		Cards:   testCards,
 	}
 
-	t, err := template.New("secure-payment").Parse(tmpl)
-	if err != nil {
-		http.Error(w, "Template error", http.StatusInternalServerError)
-		return
+	s.render(w, r, "secure-payment", data)
+}
+
+// errNotPayable means the order has moved past pending or another request
+// is already paying it
+var errNotPayable = errors.New("order is no longer awaiting payment")
+
+// payOrder charges a card for a pending order and records the charge on it.
+// SECURITY: Only one request at a time may pay an order, and the order is
+// read again once that request holds it, so two customers racing to pay
+// can't both charge a card.
+func (s *Server) payOrder(orderID, cardNumber, returnURL string) (models.Order, gateway.Charge, error) {
+	if _, busy := s.paying.LoadOrStore(orderID, struct{}{}); busy {
+		return models.Order{}, gateway.Charge{}, errNotPayable
+	}
+	defer s.paying.Delete(orderID)
+
+	order, exists := s.Store.GetOrder(orderID)
+	if !exists || order.Status != models.StatusPending {
+		return order, gateway.Charge{}, errNotPayable
 	}
 
-	err = t.Execute(w, data)
+	charge, err := s.Gateway.CreateCharge(orderID, order.Total, cardNumber, returnURL)
 	if err != nil {
-		http.Error(w, "Template execution error", http.StatusInternalServerError)
-		return
+		return order, charge, err
+	}
+	order, err = s.Store.UpdateOrder(orderID, func(o *models.Order) error {
+		if err := o.Transition(models.StatusAwaitingPayment, "customer"); err != nil {
+			return err
+		}
+		o.PaymentID = charge.ID
+		return nil
+	})
+	if err != nil {
+		// Cancelled or expired while the card was charged; the charge belongs
+		// to no order, so the webhook refunds it once it settles
+		return order, charge, errNotPayable
 	}
+	return order, charge, nil
 }
 
-func SecureOrderResultHandler(w http.ResponseWriter, r *http.Request) {
+func (s *Server) SecureOrderResultHandler(w http.ResponseWriter, r *http.Request) {
+	sessionID := s.getOrCreateSession(w, r)
 	orderID := r.URL.Query().Get("order_id")
 
-	// VULNERABILITY: Anyone can view any order by guessing order_id
-	order, exists := models.GetOrder(orderID)
+	// SECURITY: Customers can only see their own orders. The gateway sends
+	// secure API payments back here too.
+	order, exists := s.ownedOrder(cartSecureOrder, sessionID, orderID)
+	if !exists {
+		order, exists = s.ownedOrder(cartSecureAPI, sessionID, orderID)
+	}
 	if !exists {
 		http.Error(w, "Order not found", http.StatusNotFound)
 		return
 	}
 
-	tmpl := `
-<!DOCTYPE html>
-<html>
-<head>
-    <title>Order Result - Secure Shop</title>
-    <link rel="stylesheet" href="/static/style.css">
-</head>
-<body>
-    <div class="container">
-        <h1>Order Complete</h1>
-        <p>Order ID: {{.Order.ID}}</p>
-        <p>Status: {{.Order.Status}}</p>
-        {{if eq .Order.Status "pending"}}
-        <p><em>Order will be completed in a few seconds after payment...</em></p>
-        {{end}}
-        <p>Total: ${{printf "%.2f" .Order.Total}}</p>
-        <p>Date: {{.Order.Timestamp.Format "2006-01-02 15:04:05"}}</p>
-        
-        <h3>Items:</h3>
-        {{range .Order.Items}}
-        <div class="order-item">
-            <p>Product ID: {{.ProductID}} - Quantity: {{.Quantity}} - Price: ${{printf "%.2f" .Price}}</p>
-        </div>
-        {{end}}
-        
-        <a href="/secure-order">Back to Shop</a>
-        <a href="/">Home</a>
-    </div>
-</body>
-</html>`
-
 	data := struct {
 		Order models.Order
 	}{
 		Order: order,
 	}
 
-	t, _ := template.New("secure-result").Parse(tmpl)
-	t.Execute(w, data)
+	s.render(w, r, "secure-result", data)
+}
+
+// Cancel an unpaid order and give its reserved stock back
+func (s *Server) SecureCancelHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Redirect(w, r, "/secure-order", http.StatusSeeOther)
+		return
+	}
+
+	sessionID := s.getOrCreateSession(w, r)
+	orderID := r.FormValue("order_id")
+
+	order, exists := s.ownedOrder(cartSecureOrder, sessionID, orderID)
+	if !exists {
+		http.Error(w, "Order not found", http.StatusNotFound)
+		return
+	}
+
+	if _, err := s.Store.TransitionOrder(orderID, models.StatusCancelled, "customer"); err != nil {
+		http.Error(w, "Only unpaid orders can be cancelled", http.StatusConflict)
+		return
+	}
+	// Only an order that still holds a reservation has stock to give back
+	if !order.ReservedUntil.IsZero() {
+		if err := s.Store.ReleaseStock(order.Items); err != nil {
+			log.Printf("Releasing stock for cancelled order %s: %v", orderID, err)
+		}
+	}
+
+	http.Redirect(w, r, fmt.Sprintf("/secure-order/result?order_id=%s", orderID), http.StatusSeeOther)
 }
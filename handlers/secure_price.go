@@ -1,102 +1,49 @@
 package handlers
 
 import (
-	"html/template"
 	"net/http"
 	"secure-webapp/models"
-	"strconv"
 )
 
-func SecurePriceHandler(w http.ResponseWriter, r *http.Request) {
-	sessionID := getOrCreateSession(w, r)
-	cart := models.GetCart(sessionID)
-
-	tmpl := `
-<!DOCTYPE html>
-<html>
-<head>
-    <title>Secure Price Shop</title>
-    <link rel="stylesheet" href="/static/style.css">
-</head>
-<body>
-    <div class="container">
-        <h1>Secure Price Manipulation Shop</h1>
-        <p class="success">This shop validates all prices server-side!</p>
-        
-        <div class="products">
-            <h2>Products</h2>
-            {{range $id, $product := .Products}}
-            <div class="product">
-                <h3>{{$product.Name}}</h3>
-                <p>Price: ${{printf "%.2f" $product.Price}}</p>
-                <form method="POST" action="/secure-price/add-to-cart">
-                    <input type="hidden" name="product_id" value="{{$product.ID}}">
-                    <!-- NO PRICE FIELD - Server will look up the price -->
-                    <input type="number" name="quantity" value="1" min="1" max="10">
-                    <button type="submit">Add to Cart</button>
-                </form>
-            </div>
-            {{end}}
-        </div>
-
-        <div class="cart">
-            <h2>Cart</h2>
-            {{if .Cart.Items}}
-                {{range .Cart.Items}}
-                <div class="cart-item">
-                    <p>Product ID: {{.ProductID}} - Quantity: {{.Quantity}} - Price: ${{printf "%.2f" .Price}}</p>
-                </div>
-                {{end}}
-                <p><strong>Total: ${{printf "%.2f" .Cart.Total}}</strong></p>
-                <form method="POST" action="/secure-price/checkout">
-                    <button type="submit">Checkout</button>
-                </form>
-            {{else}}
-                <p>Cart is empty</p>
-            {{end}}
-        </div>
-        
-        <a href="/">Back to Home</a>
-    </div>
-</body>
-</html>`
+func (s *Server) SecurePriceHandler(w http.ResponseWriter, r *http.Request) {
+	sessionID := s.getOrCreateSession(w, r)
+	cart := s.Store.GetCart(sessionID, cartSecurePrice)
 
 	data := struct {
 		Products map[string]models.Product
 		Cart     models.Cart
+		Error    string
 	}{
-		Products: models.Products,
+		Products: s.catalog(),
 		Cart:     cart,
+		Error:    shopMessages[r.URL.Query().Get("error")],
 	}
 
-	t, _ := template.New("secure-price").Parse(tmpl)
-	t.Execute(w, data)
+	s.render(w, r, "secure-price", data)
 }
 
-func SecurePriceAddToCartHandler(w http.ResponseWriter, r *http.Request) {
+func (s *Server) SecurePriceAddToCartHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != "POST" {
 		http.Redirect(w, r, "/secure-price", http.StatusSeeOther)
 		return
 	}
 
-	sessionID := getOrCreateSession(w, r)
+	sessionID := s.getOrCreateSession(w, r)
 	productID := r.FormValue("product_id")
-	quantity, err := strconv.Atoi(r.FormValue("quantity"))
-
-	// Validate quantity
-	if err != nil || quantity < 1 || quantity > 10 {
-		http.Redirect(w, r, "/secure-price", http.StatusSeeOther)
+	quantity, ok := parseQuantity(r.FormValue("quantity"))
+	if !ok {
+		http.Redirect(w, r, "/secure-price?error=invalid_quantity", http.StatusSeeOther)
 		return
 	}
 
 	// SECURITY: Only use server-side price lookup - no client price input at all
-	product, exists := models.GetProduct(productID)
+	product, exists := s.productOnSale(productID)
 	if !exists {
 		http.Redirect(w, r, "/secure-price", http.StatusSeeOther)
 		return
 	}
 
-	cart := models.GetCart(sessionID)
+	cart := s.Store.GetCart(sessionID, cartSecurePrice)
 	cart.Items = append(cart.Items, models.CartItem{
 		ProductID: productID,
 		Quantity:  quantity,
@@ -104,27 +51,34 @@ func SecurePriceAddToCartHandler(w http.ResponseWriter, r *http.Request) {
 	})
 
 	// Recalculate total using server-side prices only
-	cart.Total = 0
+	cart.Total = models.NewMoney(0, models.BaseCurrency)
 	for _, item := range cart.Items {
-		serverProduct, exists := models.GetProduct(item.ProductID)
+		serverProduct, exists := s.productOnSale(item.ProductID)
 		if exists {
 			// Update the item price to ensure consistency
-			cart.Total += serverProduct.Price * float64(item.Quantity)
+			line, err := serverProduct.Price.Mul(item.Quantity)
+			if err == nil {
+				cart.Total, err = cart.Total.Add(line)
+			}
+			if err != nil {
+				http.Error(w, "Cart total out of range", http.StatusBadRequest)
+				return
+			}
 		}
 	}
 
-	models.SetCart(sessionID, cart)
+	s.Store.SetCart(sessionID, cartSecurePrice, cart)
 	http.Redirect(w, r, "/secure-price", http.StatusSeeOther)
 }
 
-func SecurePriceCheckoutHandler(w http.ResponseWriter, r *http.Request) {
+func (s *Server) SecurePriceCheckoutHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != "POST" {
 		http.Redirect(w, r, "/secure-price", http.StatusSeeOther)
 		return
 	}
 
-	sessionID := getOrCreateSession(w, r)
-	cart := models.GetCart(sessionID)
+	sessionID := s.getOrCreateSession(w, r)
+	cart := s.Store.GetCart(sessionID, cartSecurePrice)
 
 	if len(cart.Items) == 0 {
 		http.Redirect(w, r, "/secure-price", http.StatusSeeOther)
@@ -132,11 +86,11 @@ func SecurePriceCheckoutHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Double-check all prices server-side before processing
-	serverTotal := 0.0
+	serverTotal := models.NewMoney(0, models.BaseCurrency)
 	validatedItems := []models.CartItem{}
 
 	for _, item := range cart.Items {
-		product, exists := models.GetProduct(item.ProductID)
+		product, exists := s.productOnSale(item.ProductID)
 		if exists {
 			validatedItem := models.CartItem{
 				ProductID: item.ProductID,
@@ -144,49 +98,27 @@ func SecurePriceCheckoutHandler(w http.ResponseWriter, r *http.Request) {
 				Price:     product.Price, // Always enforce server price
 			}
 			validatedItems = append(validatedItems, validatedItem)
-			serverTotal += product.Price * float64(item.Quantity)
+			line, err := validatedItem.LineTotal()
+			if err == nil {
+				serverTotal, err = serverTotal.Add(line)
+			}
+			if err != nil {
+				http.Error(w, "Order total out of range", http.StatusBadRequest)
+				return
+			}
 		}
 	}
 
-	tmpl := `
-<!DOCTYPE html>
-<html>
-<head>
-    <title>Checkout - Secure Price Shop</title>
-    <link rel="stylesheet" href="/static/style.css">
-</head>
-<body>
-    <div class="container">
-        <h1>Checkout Complete</h1>
-        <p class="success">Order processed with server-validated prices!</p>
-        
-        <h3>Order Summary:</h3>
-        {{range .Items}}
-        <div class="order-item">
-            <p>Product ID: {{.ProductID}} - Quantity: {{.Quantity}} - Price: ${{printf "%.2f" .Price}}</p>
-        </div>
-        {{end}}
-        
-        <p><strong>Total Paid: ${{printf "%.2f" .Total}}</strong></p>
-        <p><small>All prices were looked up server-side - no client input trusted!</small></p>
-        
-        <a href="/secure-price">Back to Shop</a>
-        <a href="/">Home</a>
-    </div>
-</body>
-</html>`
-
 	// Clear cart after checkout
-	models.ClearCart(sessionID)
+	s.Store.ClearCart(sessionID, cartSecurePrice)
 
 	data := struct {
 		Items []models.CartItem
-		Total float64
+		Total models.Money
 	}{
 		Items: validatedItems,
 		Total: serverTotal,
 	}
 
-	t, _ := template.New("secure-checkout").Parse(tmpl)
-	t.Execute(w, data)
+	s.render(w, r, "secure-checkout", data)
 }
@@ -0,0 +1,105 @@
+package handlers
+
+import (
+	"net/http"
+	"secure-webapp/models"
+)
+
+func (s *Server) SecureQuantityHandler(w http.ResponseWriter, r *http.Request) {
+	s.renderQuantityShop(w, r, "secure", "Secure Quantity Shop",
+		"Quantities must be whole numbers from 1 to 10 and totals are overflow-checked!")
+}
+
+func (s *Server) SecureQuantityAddToCartHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Redirect(w, r, "/secure-quantity", http.StatusSeeOther)
+		return
+	}
+
+	sessionID := s.getOrCreateSession(w, r)
+	productID := r.FormValue("product_id")
+
+	// SECURITY: Reject anything but a whole quantity in range instead of
+	// coercing it
+	quantity, ok := parseQuantity(r.FormValue("quantity"))
+	if !ok {
+		http.Redirect(w, r, "/secure-quantity?error=invalid_quantity", http.StatusSeeOther)
+		return
+	}
+
+	product, exists := s.productOnSale(productID)
+	if !exists {
+		http.Redirect(w, r, "/secure-quantity", http.StatusSeeOther)
+		return
+	}
+
+	cart := s.Store.GetCart(sessionID, cartSecureQuantity)
+	cart.Items = append(cart.Items, models.CartItem{
+		ProductID: productID,
+		Quantity:  quantity,
+		Price:     product.Price,
+	})
+
+	// SECURITY: SumItems fails instead of wrapping around
+	total, err := models.SumItems(cart.Items)
+	if err != nil {
+		http.Error(w, "Cart total out of range", http.StatusBadRequest)
+		return
+	}
+	cart.Total = total
+
+	s.Store.SetCart(sessionID, cartSecureQuantity, cart)
+	http.Redirect(w, r, "/secure-quantity", http.StatusSeeOther)
+}
+
+func (s *Server) SecureQuantityCheckoutHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Redirect(w, r, "/secure-quantity", http.StatusSeeOther)
+		return
+	}
+
+	sessionID := s.getOrCreateSession(w, r)
+	cart := s.Store.GetCart(sessionID, cartSecureQuantity)
+	if len(cart.Items) == 0 {
+		http.Redirect(w, r, "/secure-quantity", http.StatusSeeOther)
+		return
+	}
+
+	// SECURITY: Re-check every line and recompute the total at today's
+	// catalog prices, since the cart may have been filled by another shop or
+	// before the catalog changed
+	for _, item := range cart.Items {
+		if item.Quantity < 1 || item.Quantity > maxLineQuantity {
+			http.Redirect(w, r, "/secure-quantity?error=invalid_quantity", http.StatusSeeOther)
+			return
+		}
+	}
+	items, onSale := s.atCatalogPrices(cart.Items)
+	total, err := models.SumItems(items)
+	if err != nil {
+		http.Error(w, "Cart total out of range", http.StatusBadRequest)
+		return
+	}
+	if !onSale {
+		cart.Items, cart.Total = items, total
+		s.Store.SetCart(sessionID, cartSecureQuantity, cart)
+		http.Redirect(w, r, "/secure-quantity?error=not_on_sale", http.StatusSeeOther)
+		return
+	}
+	if total.Amount <= 0 {
+		http.Error(w, "Cart total out of range", http.StatusBadRequest)
+		return
+	}
+
+	if err := s.Store.ReserveStock(items); err != nil {
+		http.Redirect(w, r, "/secure-quantity?error=out_of_stock", http.StatusSeeOther)
+		return
+	}
+	s.Store.CommitStock(items)
+
+	session, _ := s.Store.GetSession(sessionID)
+	order := s.completeInstantOrder(models.NewOrder(cartSecureQuantity, session.UserID, items, total, "customer"), "customer")
+	s.Store.ClearCart(sessionID, cartSecureQuantity)
+
+	s.renderQuantityReceipt(w, r, order, "secure")
+}
@@ -0,0 +1,56 @@
+package handlers
+
+import (
+	"net/http"
+	"secure-webapp/models"
+)
+
+func (s *Server) SecureRaceHandler(w http.ResponseWriter, r *http.Request) {
+	s.getOrCreateSession(w, r)
+
+	data := struct {
+		Products map[string]models.Product
+		Error    string
+	}{
+		Products: s.catalog(),
+		Error:    shopMessages[r.URL.Query().Get("error")],
+	}
+
+	s.render(w, r, "secure-race", data)
+}
+
+func (s *Server) SecureRaceBuyHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Redirect(w, r, "/secure-race", http.StatusSeeOther)
+		return
+	}
+
+	sessionID := s.getOrCreateSession(w, r)
+	productID := r.FormValue("product_id")
+	quantity, ok := parseQuantity(r.FormValue("quantity"))
+	if !ok {
+		http.Redirect(w, r, "/secure-race?error=invalid_quantity", http.StatusSeeOther)
+		return
+	}
+
+	product, exists := s.productOnSale(productID)
+	if !exists {
+		http.Redirect(w, r, "/secure-race", http.StatusSeeOther)
+		return
+	}
+
+	// SECURITY: Check and reserve happen under a single lock acquisition, so
+	// concurrent buyers cannot both pass the check for the same units
+	items := []models.CartItem{{ProductID: productID, Quantity: quantity, Price: product.Price}}
+	if err := s.Store.ReserveStock(items); err != nil {
+		http.Redirect(w, r, "/secure-race?error=out_of_stock", http.StatusSeeOther)
+		return
+	}
+
+	processPayment()
+
+	// The reservation already holds the units; committing only converts it
+	s.Store.CommitStock(items)
+
+	s.renderRacePurchase(w, r, sessionID, product, quantity, "secure")
+}
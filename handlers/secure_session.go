@@ -0,0 +1,27 @@
+package handlers
+
+import (
+	"net/http"
+	"secure-webapp/models"
+	"time"
+)
+
+func (s *Server) SecureSessionHandler(w http.ResponseWriter, r *http.Request) {
+	// SECURITY: A ?sid= parameter is ignored; IDs only come from the server
+	sessionID := s.getOrCreateSession(w, r)
+	session, _ := s.Store.GetSession(sessionID)
+
+	data := struct {
+		Session   models.Session
+		Prefix    string
+		Idle      time.Duration
+		ExpiresAt time.Time
+	}{
+		Session:   session,
+		Prefix:    session.ID[:8],
+		Idle:      sessionIdleTimeout,
+		ExpiresAt: session.CreatedAt.Add(sessionAbsoluteTimeout),
+	}
+
+	s.render(w, r, "secure-session", data)
+}
@@ -0,0 +1,143 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"secure-webapp/gateway"
+	"secure-webapp/models"
+	"time"
+)
+
+// webhookTolerance is how far a webhook's signed timestamp may be from our
+// clock before the delivery is rejected. Event IDs are remembered for twice
+// as long, which covers every timestamp that could still pass the check.
+const webhookTolerance = 5 * time.Minute
+
+// Receives the payment gateway's signed charge notifications
+func (s *Server) SecureWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, 64<<10))
+	if err != nil {
+		http.Error(w, "Could not read body", http.StatusBadRequest)
+		return
+	}
+
+	// SECURITY: Only the gateway knows the secret, so only it can produce a
+	// valid signature over this exact body, and only recently
+	if _, err := gateway.VerifySignature(s.WebhookSecret, r.Header.Get(gateway.SignatureHeader), body, webhookTolerance, time.Now()); err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	var event gateway.Event
+	if err := json.Unmarshal(body, &event); err != nil || event.ID == "" {
+		http.Error(w, "Invalid payload", http.StatusBadRequest)
+		return
+	}
+
+	order, exists := s.Store.GetOrder(event.OrderID)
+	if !exists {
+		http.Error(w, "Order not found", http.StatusNotFound)
+		return
+	}
+
+	// SECURITY: The event must be about the charge we created for this order
+	// and for exactly the amount the order costs
+	if order.PaymentID == "" || order.PaymentID != event.ChargeID {
+		if event.Type == gateway.EventChargeSucceeded {
+			// The gateway signed it, so the card really was charged, but the
+			// order never recorded the charge; don't keep the money
+			s.refundStrayCharge(order, event)
+		}
+		http.Error(w, "Charge does not belong to this order", http.StatusBadRequest)
+		return
+	}
+	if event.Amount != order.Total {
+		http.Error(w, "Charged amount does not match order total", http.StatusBadRequest)
+		return
+	}
+
+	// SECURITY: Each event is acted on once; a captured delivery replayed
+	// within the timestamp tolerance is refused
+	if !s.Store.ClaimNonce("webhook:"+event.ID, time.Now().Add(2*webhookTolerance)) {
+		http.Error(w, "Webhook already processed", http.StatusConflict)
+		return
+	}
+
+	switch event.Type {
+	case gateway.EventChargeSucceeded:
+		paid, err := s.Store.TransitionOrder(order.ID, models.StatusPaid, "payment-gateway")
+		if err == nil {
+			if err := s.Store.CommitStock(paid.Items); err != nil {
+				log.Printf("Committing stock for paid order %s: %v", order.ID, err)
+			}
+			s.Store.TransitionOrder(order.ID, models.StatusFulfilled, "system")
+			break
+		}
+		if current, _ := s.Store.GetOrder(order.ID); current.Status == models.StatusCancelled || current.Status == models.StatusExpired {
+			// SECURITY: The order gave its stock up before the money arrived,
+			// so the customer would be charged for nothing; give it back
+			s.refundLatePayment(current, event)
+			break
+		}
+		// Already settled meanwhile; acknowledge so the gateway stops retrying
+		log.Printf("Webhook %s for order %s ignored: %v", event.ID, order.ID, err)
+
+	case gateway.EventChargeFailed:
+		if _, err := s.Store.TransitionOrder(order.ID, models.StatusCancelled, "payment-gateway"); err != nil {
+			log.Printf("Webhook %s for order %s ignored: %v", event.ID, order.ID, err)
+			break
+		}
+		if err := s.Store.ReleaseStock(order.Items); err != nil {
+			log.Printf("Releasing stock for failed order %s: %v", order.ID, err)
+		}
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// refundLatePayment refunds a charge that succeeded after its order was
+// cancelled or expired, and marks the order refunded so the customer can see
+// where their money went
+func (s *Server) refundLatePayment(order models.Order, event gateway.Event) {
+	if _, err := s.Gateway.Refund(event.ChargeID); err != nil {
+		log.Printf("Refunding charge %s for %s order %s: %v", event.ChargeID, order.Status, order.ID, err)
+		return
+	}
+	if _, err := s.Store.TransitionOrder(order.ID, models.StatusRefunded, "system"); err != nil {
+		log.Printf("Marking order %s refunded: %v", order.ID, err)
+	}
+}
+
+// refundStrayCharge refunds a charge that succeeded for an order without
+// being the order's payment, such as one made while the order was cancelled
+func (s *Server) refundStrayCharge(order models.Order, event gateway.Event) {
+	if _, err := s.Gateway.Refund(event.ChargeID); err != nil && !errors.Is(err, gateway.ErrNotRefundable) {
+		log.Printf("Refunding stray charge %s for order %s: %v", event.ChargeID, order.ID, err)
+	}
+}
+
+// Explains the secure receiver and lets visitors try to forge a delivery
+func (s *Server) SecureWebhookDemoHandler(w http.ResponseWriter, r *http.Request) {
+	sessionID := s.getOrCreateSession(w, r)
+	session, _ := s.Store.GetSession(sessionID)
+
+	data := struct {
+		Orders          []models.Order
+		TimeoutCard     string
+		SignatureHeader string
+	}{
+		Orders:          s.shopOrdersForUser(cartSecureOrder, session.UserID),
+		TimeoutCard:     gateway.CardTimeout,
+		SignatureHeader: gateway.SignatureHeader,
+	}
+
+	s.render(w, r, "secure-webhook", data)
+}
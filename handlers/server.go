@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"secure-webapp/gateway"
+	"secure-webapp/models"
+	"secure-webapp/scenario"
+	"sync"
+	"sync/atomic"
+)
+
+// Server carries the dependencies shared by all shop handlers
+type Server struct {
+	Store models.Store
+	Rates *models.RateTable
+
+	// Gateway is the payment provider the secure shop charges cards through;
+	// WebhookSecret is the key its webhooks are signed with
+	Gateway       *gateway.Gateway
+	WebhookSecret []byte
+
+	// SecureCookies marks the session cookie Secure even on plain HTTP
+	// requests, for deployments behind a TLS-terminating proxy
+	SecureCookies bool
+
+	// Scenarios holds the vulnerable/secure pairs; main mounts their routes
+	// and the home page lists them
+	Scenarios *scenario.Registry
+
+	// Admins are the user IDs allowed on the instructor dashboard; it is
+	// only served when there is at least one
+	Admins []string
+
+	// CTFSecret turns on capture-the-flag mode and derives each team's
+	// flags; it is empty unless main's -ctf flag is set
+	CTFSecret []byte
+	// fixationSignIns maps a fixation demo session to the real session of
+	// the browser that signed in to it, so CTF mode can tell when another
+	// browser is using it. ExpireSessions drops an entry with its session.
+	fixationSignIns sync.Map
+
+	// paying holds the IDs of the orders a request is charging a card for
+	paying sync.Map
+
+	orderNumbers orderNumbers
+
+	// activity holds the exploit attempts the dashboard streams
+	activity activityFeed
+
+	// devPages replaces the embedded templates once WatchTemplates has
+	// loaded them from disk
+	devPages atomic.Pointer[pageSet]
+}
+
+// NewServer starts with a rate table holding only the base currency and a
+// gateway that calls back localhost:8080 with a random secret; main replaces
+// them from its flags
+func NewServer(store models.Store) *Server {
+	rates, _ := models.NewRateTable(models.BaseCurrency, nil)
+	secret := []byte(models.GenerateID())
+	s := &Server{
+		Store:         store,
+		Rates:         rates,
+		Gateway:       gateway.New(secret, "http://localhost:8080/secure-order/webhook"),
+		WebhookSecret: secret,
+		Scenarios:     scenario.NewRegistry(),
+	}
+	s.registerScenarios()
+	return s
+}
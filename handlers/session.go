@@ -0,0 +1,180 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"secure-webapp/models"
+	"time"
+)
+
+const (
+	sessionCookieName = "session_id"
+
+	// A session ends after sessionIdleTimeout without a request, and
+	// sessionAbsoluteTimeout after it was created however busy it is
+	sessionIdleTimeout     = 30 * time.Minute
+	sessionAbsoluteTimeout = 12 * time.Hour
+
+	// LastSeen is only written back this often so every request does not
+	// turn into a store write
+	sessionTouchInterval = time.Minute
+)
+
+// newSessionID returns 256 random bits, hex encoded. Only IDs of exactly this
+// shape are ever looked up, so values planted by the vulnerable fixation demo
+// can never be used with the rest of the site.
+func newSessionID() string {
+	bytes := make([]byte, 32)
+	rand.Read(bytes)
+	return hex.EncodeToString(bytes)
+}
+
+func validSessionID(id string) bool {
+	if len(id) != 64 {
+		return false
+	}
+	_, err := hex.DecodeString(id)
+	return err == nil
+}
+
+func sessionExpired(session models.Session, now time.Time) bool {
+	return now.Sub(session.LastSeen) > sessionIdleTimeout || now.Sub(session.CreatedAt) > sessionAbsoluteTimeout
+}
+
+// setSessionCookie sends the session cookie with HttpOnly and SameSite=Lax so
+// scripts cannot read it and other sites cannot ride it on POSTs; Secure is
+// added when the request came over TLS or the server is told it sits behind
+// HTTPS. A negative maxAge deletes the cookie.
+func (s *Server) setSessionCookie(w http.ResponseWriter, r *http.Request, value string, maxAge int) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    value,
+		Path:     "/",
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   s.SecureCookies || r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// newSession registers a fresh anonymous session and sends its cookie
+func (s *Server) newSession(w http.ResponseWriter, r *http.Request) models.Session {
+	now := time.Now()
+	session := models.Session{
+		ID:        newSessionID(),
+		UserID:    models.GenerateID(),
+		Currency:  models.BaseCurrency,
+		CSRFToken: newSessionID(),
+		CreatedAt: now,
+		LastSeen:  now,
+	}
+	s.Store.SetSession(session)
+	s.setSessionCookie(w, r, session.ID, int(sessionAbsoluteTimeout.Seconds()))
+	return session
+}
+
+// currentSession returns the live session named by the request's cookie, if
+// there is one, and refreshes its idle timer
+func (s *Server) currentSession(r *http.Request) (models.Session, bool) {
+	cookie, err := r.Cookie(sessionCookieName)
+	if err != nil || !validSessionID(cookie.Value) {
+		return models.Session{}, false
+	}
+	session, exists := s.Store.GetSession(cookie.Value)
+	if !exists {
+		return models.Session{}, false
+	}
+
+	now := time.Now()
+	if sessionExpired(session, now) {
+		s.Store.DeleteSession(session.ID)
+		s.Store.DeleteCarts(session.ID)
+		return models.Session{}, false
+	}
+	if now.Sub(session.LastSeen) > sessionTouchInterval {
+		session.LastSeen = now
+		s.Store.SetSession(session)
+	}
+	return session, true
+}
+
+// getOrCreateSession returns the visitor's session ID, starting a new
+// session when the cookie is missing, unknown or expired
+func (s *Server) getOrCreateSession(w http.ResponseWriter, r *http.Request) string {
+	// SECURITY: Session IDs are only ever issued by the server; an unknown
+	// ID in the cookie is replaced, never adopted
+	if session, ok := s.currentSession(r); ok {
+		return session.ID
+	}
+	return s.newSession(w, r).ID
+}
+
+// rotateSession moves the visitor's session, carts included, to a fresh ID
+// and retires the old one. Call it whenever the session gains or loses
+// privileges, so an ID an attacker planted or observed beforehand is useless
+// afterwards. update is applied to the session before it is stored.
+func (s *Server) rotateSession(w http.ResponseWriter, r *http.Request, update func(*models.Session)) models.Session {
+	session, ok := s.currentSession(r)
+	if !ok {
+		session = s.newSession(w, r)
+	}
+	oldID := session.ID
+
+	now := time.Now()
+	session.ID = newSessionID()
+	session.CSRFToken = newSessionID()
+	session.CreatedAt = now
+	session.LastSeen = now
+	if update != nil {
+		update(&session)
+	}
+	s.Store.SetSession(session)
+
+	for scenario, cart := range s.Store.ListCarts(oldID) {
+		if len(cart.Items) > 0 {
+			s.Store.SetCart(session.ID, scenario, cart)
+		}
+	}
+	s.Store.DeleteCarts(oldID)
+	s.Store.DeleteSession(oldID)
+
+	s.setSessionCookie(w, r, session.ID, int(sessionAbsoluteTimeout.Seconds()))
+	return session
+}
+
+// endSession deletes the visitor's session and its carts and clears the cookie
+func (s *Server) endSession(w http.ResponseWriter, r *http.Request) {
+	if session, ok := s.currentSession(r); ok {
+		s.Store.DeleteCarts(session.ID)
+		s.Store.DeleteSession(session.ID)
+	}
+	s.setSessionCookie(w, r, "", -1)
+}
+
+// ExpireSessions deletes sessions past their idle or absolute lifetime,
+// together with their carts and any fixation demo sign-in recorded for them
+func (s *Server) ExpireSessions(now time.Time) {
+	for id, session := range s.Store.ListSessions() {
+		if !sessionExpired(session, now) {
+			continue
+		}
+		s.Store.DeleteSession(id)
+		s.Store.DeleteCarts(id)
+		s.fixationSignIns.Delete(id)
+	}
+}
+
+// RunSessionReaper calls ExpireSessions every interval until stop is closed
+func (s *Server) RunSessionReaper(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			s.ExpireSessions(now)
+		case <-stop:
+			return
+		}
+	}
+}
@@ -1,37 +1,22 @@
 package handlers
 
 import (
+	"encoding/json"
 	"net/http"
-	"secure-webapp/models"
+	"strings"
 )
 
-// Helper function for session management
-func getOrCreateSession(w http.ResponseWriter, r *http.Request) string {
-	cookie, err := r.Cookie("session_id")
-	if err != nil {
-		// Create new session
-		sessionID := models.GenerateID()
-		userID := models.GenerateID()
-
-		models.SetSession(sessionID, userID)
-
-		http.SetCookie(w, &http.Cookie{
-			Name:  "session_id",
-			Value: sessionID,
-			Path:  "/",
-		})
-
-		return sessionID
-	}
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
 
-	// Validate existing session
-	sessionID := cookie.Value
-	_, exists := models.GetSession(sessionID)
-	if !exists {
-		// Session invalid, create new one
-		userID := models.GenerateID()
-		models.SetSession(sessionID, userID)
+// localPath returns target if it is a path on this site and fallback
+// otherwise, so redirect parameters can't be used as open redirects
+func localPath(target, fallback string) string {
+	if !strings.HasPrefix(target, "/") || strings.HasPrefix(target, "//") || strings.HasPrefix(target, "/\\") {
+		return fallback
 	}
-
-	return sessionID
+	return target
 }
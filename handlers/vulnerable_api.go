@@ -0,0 +1,197 @@
+package handlers
+
+import (
+	"net/http"
+	"secure-webapp/models"
+	"secure-webapp/scenario"
+)
+
+// apiScenario pairs the two versions of the JSON API under /api/v1
+func (s *Server) apiScenario() scenario.Definition {
+	return scenario.Definition{
+		Title:   "JSON API",
+		Group:   "APIs",
+		Summary: "The versioned JSON API trusts client prices and lets anyone read and pay any order.",
+		Level:   scenario.Intermediate,
+		Clues: []string{
+			"The OpenAPI document at /api/openapi.json lists every field the API accepts.",
+			"Try fetching an order that isn't yours.",
+		},
+		VulnerableSet: scenario.HandlerSet{
+			Summary: "Client prices, any order readable and payable",
+			Landing: "/api/v1/vulnerable/products",
+			Prefix:  "/api/v1/vulnerable",
+			Routes: []scenario.Route{
+				{Path: "/products", Handler: s.VulnerableAPIProductsHandler},
+				{Path: "/cart", Handler: s.VulnerableAPICartHandler},
+				{Path: "/cart/items", Handler: s.VulnerableAPICartItemsHandler},
+				{Path: "/orders", Handler: s.VulnerableAPIOrdersHandler},
+				{Path: "/orders/{id}", Handler: s.VulnerableAPIOrderHandler},
+				{Path: "/orders/{id}/pay", Handler: s.VulnerableAPIPayHandler},
+			},
+		},
+		SecureSet: scenario.HandlerSet{
+			Summary: "Catalog prices, owned orders and gateway payments",
+			Landing: "/api/v1/secure/products",
+			Prefix:  "/api/v1/secure",
+			Routes: []scenario.Route{
+				{Path: "/products", Handler: s.SecureAPIProductsHandler},
+				{Path: "/cart", Handler: s.SecureAPICartHandler},
+				{Path: "/cart/items", Handler: s.SecureAPICartItemsHandler},
+				{Path: "/orders", Handler: s.SecureAPIOrdersHandler},
+				{Path: "/orders/{id}", Handler: s.SecureAPIOrderHandler},
+				{Path: "/orders/{id}/pay", Handler: s.SecureAPIPayHandler},
+			},
+		},
+	}
+}
+
+// Vulnerable API: /api/v1/vulnerable/...
+
+type vulnerableAPIItemRequest struct {
+	ProductID string        `json:"product_id"`
+	Quantity  int           `json:"quantity"`
+	Price     *models.Money `json:"price"`
+}
+
+type vulnerableAPIPayRequest struct {
+	CardNumber string `json:"card_number"`
+}
+
+func (s *Server) VulnerableAPIProductsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		methodNotAllowed(w, "GET")
+		return
+	}
+	writeJSON(w, http.StatusOK, s.apiProducts())
+}
+
+func (s *Server) VulnerableAPICartHandler(w http.ResponseWriter, r *http.Request) {
+	sessionID := s.getOrCreateSession(w, r)
+
+	switch r.Method {
+	case "GET":
+		writeJSON(w, http.StatusOK, toAPICart(s.Store.GetCart(sessionID, cartVulnerableAPI)))
+	case "DELETE":
+		s.Store.ClearCart(sessionID, cartVulnerableAPI)
+		writeJSON(w, http.StatusOK, toAPICart(models.Cart{}))
+	default:
+		methodNotAllowed(w, "GET, DELETE")
+	}
+}
+
+func (s *Server) VulnerableAPICartItemsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		methodNotAllowed(w, "POST")
+		return
+	}
+
+	sessionID := s.getOrCreateSession(w, r)
+	var req vulnerableAPIItemRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	product, exists := s.productOnSale(req.ProductID)
+	if !exists {
+		writeAPIError(w, http.StatusNotFound, "product_not_found", "no such product")
+		return
+	}
+
+	// VULNERABILITY: A price in the request body overrides the catalog price,
+	// and the quantity is not validated at all
+	price := product.Price
+	if req.Price != nil {
+		price = *req.Price
+		s.checkClientPrice(r, "JSON API", product, price)
+	}
+
+	cart := s.Store.GetCart(sessionID, cartVulnerableAPI)
+	cart.Items = append(cart.Items, models.CartItem{ProductID: product.ID, Quantity: req.Quantity, Price: price})
+	total, err := models.SumItems(cart.Items)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "total_out_of_range", "cart total out of range")
+		return
+	}
+	cart.Total = total
+
+	s.Store.SetCart(sessionID, cartVulnerableAPI, cart)
+	writeJSON(w, http.StatusCreated, toAPICart(cart))
+}
+
+func (s *Server) VulnerableAPIOrdersHandler(w http.ResponseWriter, r *http.Request) {
+	sessionID := s.getOrCreateSession(w, r)
+	session, _ := s.Store.GetSession(sessionID)
+
+	switch r.Method {
+	case "GET":
+		writeJSON(w, http.StatusOK, s.apiOrders(cartVulnerableAPI, session.UserID))
+
+	case "POST":
+		cart := s.Store.GetCart(sessionID, cartVulnerableAPI)
+		if len(cart.Items) == 0 {
+			writeAPIError(w, http.StatusConflict, "cart_empty", "cart is empty")
+			return
+		}
+
+		// VULNERABILITY: The cart's stored total is trusted and no stock is
+		// reserved
+		order := models.NewOrder(cartVulnerableAPI, session.UserID, cart.Items, cart.Total, "customer")
+		s.Store.SetOrder(order)
+		s.Store.ClearCart(sessionID, cartVulnerableAPI)
+		if s.underpriced(cart.Items, order.Total) {
+			s.exploited(r, "api")
+		}
+
+		writeJSON(w, http.StatusCreated, toAPIOrder(order))
+
+	default:
+		methodNotAllowed(w, "GET, POST")
+	}
+}
+
+func (s *Server) VulnerableAPIOrderHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		methodNotAllowed(w, "GET")
+		return
+	}
+
+	// VULNERABILITY: Any of the API shop's orders can be fetched by its ID
+	order, exists := s.shopOrder(cartVulnerableAPI, r.PathValue("id"))
+	if !exists {
+		writeAPIError(w, http.StatusNotFound, "order_not_found", "no such order")
+		return
+	}
+	writeJSON(w, http.StatusOK, toAPIOrder(order))
+}
+
+func (s *Server) VulnerableAPIPayHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		methodNotAllowed(w, "POST")
+		return
+	}
+
+	var req vulnerableAPIPayRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	order, exists := s.shopOrder(cartVulnerableAPI, r.PathValue("id"))
+	if !exists {
+		writeAPIError(w, http.StatusNotFound, "order_not_found", "no such order")
+		return
+	}
+
+	// VULNERABILITY: Nothing is charged and nobody checks whose order this
+	// is - any card number marks any of the shop's orders paid
+	if order.Status == models.StatusPending {
+		s.Store.TransitionOrder(order.ID, models.StatusAwaitingPayment, "customer")
+	}
+	if _, err := s.Store.TransitionOrder(order.ID, models.StatusPaid, "customer"); err != nil {
+		writeAPIError(w, http.StatusConflict, "invalid_status", err.Error())
+		return
+	}
+	order, _ = s.Store.TransitionOrder(order.ID, models.StatusFulfilled, "system")
+
+	writeJSON(w, http.StatusOK, toAPIOrder(order))
+}
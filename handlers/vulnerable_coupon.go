@@ -0,0 +1,247 @@
+package handlers
+
+import (
+	"net/http"
+	"secure-webapp/models"
+	"secure-webapp/scenario"
+	"time"
+)
+
+// couponScenario pairs the shop whose coupons ignore their own rules with the one
+// that enforces stacking and redemption limits
+func (s *Server) couponScenario() scenario.Definition {
+	return scenario.Definition{
+		Title:   "Coupon Abuse",
+		Group:   "Pricing",
+		Summary: "Coupons can be stacked, reused past their limits and applied to orders that were already paid.",
+		Level:   scenario.Intermediate,
+		Clues: []string{
+			"Does applying the same code twice do anything?",
+			"Read the promotion rules, then break every one of them.",
+			"Look for a way to add a coupon after checkout.",
+		},
+		VulnerableSet: scenario.HandlerSet{
+			Summary: "Reusable, stackable and retroactive coupons",
+			Landing: "/vulnerable-coupon",
+			Prefix:  "/vulnerable-coupon",
+			Routes: []scenario.Route{
+				{Path: "", Handler: s.VulnerableCouponHandler},
+				{Path: "/add-to-cart", Handler: s.VulnerableCouponAddToCartHandler},
+				{Path: "/apply", Handler: s.VulnerableCouponApplyHandler},
+				{Path: "/checkout", Handler: s.VulnerableCouponCheckoutHandler},
+				{Path: "/order", Handler: s.VulnerableCouponOrderHandler},
+				{Path: "/order/apply", Handler: s.VulnerableCouponOrderApplyHandler},
+			},
+		},
+		SecureSet: scenario.HandlerSet{
+			Summary: "Stacking rules and atomic redemption limits",
+			Landing: "/secure-coupon",
+			Prefix:  "/secure-coupon",
+			Routes: []scenario.Route{
+				{Path: "", Handler: s.SecureCouponHandler},
+				{Path: "/add-to-cart", Handler: s.CSRF(s.SecureCouponAddToCartHandler)},
+				{Path: "/apply", Handler: s.CSRF(s.SecureCouponApplyHandler)},
+				{Path: "/remove", Handler: s.CSRF(s.SecureCouponRemoveHandler)},
+				{Path: "/checkout", Handler: s.CSRF(s.SecureCouponCheckoutHandler)},
+				{Path: "/order", Handler: s.SecureCouponOrderHandler},
+			},
+		},
+	}
+}
+
+func (s *Server) VulnerableCouponHandler(w http.ResponseWriter, r *http.Request) {
+	s.renderCouponShop(w, r, "vulnerable", "Vulnerable Coupon Shop",
+		"Warning: Coupons can be reused, stacked without limit and even applied to orders that were already paid!")
+}
+
+// repriceVulnerableCart recomputes the cart total from its lines, keeping
+// whatever discount was recorded when coupons were applied
+func repriceVulnerableCart(cart *models.Cart) error {
+	subtotal, err := models.SumItems(cart.Items)
+	if err != nil {
+		return err
+	}
+	// VULNERABILITY: The discount is not capped, so the total can go negative
+	cart.Total, err = subtotal.Sub(cart.Discount)
+	return err
+}
+
+func (s *Server) VulnerableCouponAddToCartHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Redirect(w, r, "/vulnerable-coupon", http.StatusSeeOther)
+		return
+	}
+
+	sessionID := s.getOrCreateSession(w, r)
+	quantity, ok := parseQuantity(r.FormValue("quantity"))
+	if !ok {
+		http.Redirect(w, r, "/vulnerable-coupon?error=invalid_quantity", http.StatusSeeOther)
+		return
+	}
+	product, exists := s.productOnSale(r.FormValue("product_id"))
+	if !exists {
+		http.Redirect(w, r, "/vulnerable-coupon", http.StatusSeeOther)
+		return
+	}
+
+	cart := s.Store.GetCart(sessionID, cartVulnerableCoupon)
+	cart.Items = append(cart.Items, models.CartItem{ProductID: product.ID, Quantity: quantity, Price: product.Price})
+	if err := repriceVulnerableCart(&cart); err != nil {
+		http.Error(w, "Cart total out of range", http.StatusBadRequest)
+		return
+	}
+
+	s.Store.SetCart(sessionID, cartVulnerableCoupon, cart)
+	http.Redirect(w, r, "/vulnerable-coupon", http.StatusSeeOther)
+}
+
+func (s *Server) VulnerableCouponApplyHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Redirect(w, r, "/vulnerable-coupon", http.StatusSeeOther)
+		return
+	}
+
+	sessionID := s.getOrCreateSession(w, r)
+	coupon, exists := s.Store.GetCoupon(normalizeCouponCode(r.FormValue("code")))
+	if !exists {
+		http.Redirect(w, r, "/vulnerable-coupon?error=coupon_unknown", http.StatusSeeOther)
+		return
+	}
+
+	cart := s.Store.GetCart(sessionID, cartVulnerableCoupon)
+	subtotal, err := models.SumItems(cart.Items)
+	if err != nil {
+		http.Error(w, "Cart total out of range", http.StatusBadRequest)
+		return
+	}
+
+	// VULNERABILITY: Redemption limits, stacking rules and codes already on
+	// the cart are never checked - the same code can be applied again and
+	// again, each time taking the full discount off
+	discount, err := coupon.Discount(cart.Items, subtotal, time.Now())
+	if err != nil {
+		http.Redirect(w, r, "/vulnerable-coupon?error="+couponErrorCode(err), http.StatusSeeOther)
+		return
+	}
+	cart.Coupons = append(cart.Coupons, coupon.Code)
+	if cart.Discount, err = cart.Discount.Add(discount); err != nil {
+		http.Error(w, "Discount out of range", http.StatusBadRequest)
+		return
+	}
+	if err := repriceVulnerableCart(&cart); err != nil {
+		http.Error(w, "Cart total out of range", http.StatusBadRequest)
+		return
+	}
+
+	s.Store.SetCart(sessionID, cartVulnerableCoupon, cart)
+	http.Redirect(w, r, "/vulnerable-coupon", http.StatusSeeOther)
+}
+
+func (s *Server) VulnerableCouponCheckoutHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Redirect(w, r, "/vulnerable-coupon", http.StatusSeeOther)
+		return
+	}
+
+	sessionID := s.getOrCreateSession(w, r)
+	cart := s.Store.GetCart(sessionID, cartVulnerableCoupon)
+	if len(cart.Items) == 0 {
+		http.Redirect(w, r, "/vulnerable-coupon", http.StatusSeeOther)
+		return
+	}
+
+	if err := s.Store.ReserveStock(cart.Items); err != nil {
+		http.Redirect(w, r, "/vulnerable-coupon?error=out_of_stock", http.StatusSeeOther)
+		return
+	}
+	s.Store.CommitStock(cart.Items)
+
+	// VULNERABILITY: The cart's stored total and discount are trusted and the
+	// redemptions are recorded without checking any limit. They go in this
+	// shop's own ledger, so the secure shop's limits aren't used up.
+	session, _ := s.Store.GetSession(sessionID)
+	order := models.NewOrder(cartVulnerableCoupon, session.UserID, cart.Items, cart.Total, "customer")
+	order.Coupons = cart.Coupons
+	order.Discount = cart.Discount
+	if s.couponRulesBroken(cart, session.UserID) {
+		s.exploited(r, "coupon")
+	}
+
+	now := time.Now()
+	redemptions := make([]models.Redemption, len(cart.Coupons))
+	for i, code := range cart.Coupons {
+		redemptions[i] = models.Redemption{Shop: cartVulnerableCoupon, Code: code, UserID: session.UserID, OrderID: order.ID, At: now}
+	}
+	s.Store.RecordRedemptions(redemptions)
+
+	order = s.completeInstantOrder(order, "customer")
+	s.Store.ClearCart(sessionID, cartVulnerableCoupon)
+
+	http.Redirect(w, r, "/vulnerable-coupon/order?order_id="+order.ID, http.StatusSeeOther)
+}
+
+func (s *Server) VulnerableCouponOrderHandler(w http.ResponseWriter, r *http.Request) {
+	s.getOrCreateSession(w, r)
+
+	order, exists := s.shopOrder(cartVulnerableCoupon, r.URL.Query().Get("order_id"))
+	if !exists {
+		http.Error(w, "Order not found", http.StatusNotFound)
+		return
+	}
+
+	s.renderCouponOrder(w, r, order, "vulnerable")
+}
+
+// VulnerableCouponOrderApplyHandler takes a coupon off an order that has
+// already been placed and paid
+func (s *Server) VulnerableCouponOrderApplyHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Redirect(w, r, "/vulnerable-coupon", http.StatusSeeOther)
+		return
+	}
+
+	s.getOrCreateSession(w, r)
+	orderID := r.FormValue("order_id")
+	resultURL := "/vulnerable-coupon/order?order_id=" + orderID
+
+	coupon, exists := s.Store.GetCoupon(normalizeCouponCode(r.FormValue("code")))
+	if !exists {
+		http.Redirect(w, r, resultURL+"&error=coupon_unknown", http.StatusSeeOther)
+		return
+	}
+
+	if _, exists := s.shopOrder(cartVulnerableCoupon, orderID); !exists {
+		http.Error(w, "Order not found", http.StatusNotFound)
+		return
+	}
+
+	// VULNERABILITY: The order's status is ignored, so a paid order's total
+	// is lowered after the fact - and nothing refunds or re-charges anyone
+	order, err := s.Store.UpdateOrder(orderID, func(o *models.Order) error {
+		subtotal, err := models.SumItems(o.Items)
+		if err != nil {
+			return err
+		}
+		discount, err := coupon.Discount(o.Items, subtotal, time.Now())
+		if err != nil {
+			return err
+		}
+		if o.Total, err = o.Total.Sub(discount); err != nil {
+			return err
+		}
+		if o.Discount, err = o.Discount.Add(discount); err != nil {
+			return err
+		}
+		o.Coupons = append(append([]string(nil), o.Coupons...), coupon.Code)
+		return nil
+	})
+	if err != nil {
+		http.Redirect(w, r, resultURL+"&error="+couponErrorCode(err), http.StatusSeeOther)
+		return
+	}
+	if order.Status != models.StatusPending {
+		s.exploited(r, "coupon")
+	}
+
+	http.Redirect(w, r, resultURL, http.StatusSeeOther)
+}
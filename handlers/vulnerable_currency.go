@@ -0,0 +1,169 @@
+package handlers
+
+import (
+	"math/big"
+	"net/http"
+	"secure-webapp/models"
+	"secure-webapp/scenario"
+	"strconv"
+)
+
+// currencyScenario pairs the shop that converts with the browser's exchange rate
+// with the one that uses the server's rates
+func (s *Server) currencyScenario() scenario.Definition {
+	return scenario.Definition{
+		Title:   "Currency Conversion",
+		Group:   "Pricing",
+		Summary: "Checkout trusts an exchange rate posted by the browser and rounds every line separately.",
+		Level:   scenario.Intermediate,
+		Clues: []string{
+			"Where does the checkout get its exchange rate from?",
+			"Rounding each cheap line down adds up over a large order.",
+		},
+		VulnerableSet: scenario.HandlerSet{
+			Summary: "Client-supplied exchange rate & per-item rounding",
+			Landing: "/vulnerable-currency",
+			Prefix:  "/vulnerable-currency",
+			Routes: []scenario.Route{
+				{Path: "", Handler: s.VulnerableCurrencyHandler},
+				{Path: "/add-to-cart", Handler: s.VulnerableCurrencyAddToCartHandler},
+				{Path: "/checkout", Handler: s.VulnerableCurrencyCheckoutHandler},
+			},
+		},
+		SecureSet: scenario.HandlerSet{
+			Summary: "Server-side rates, rounded once per order",
+			Landing: "/secure-currency",
+			Prefix:  "/secure-currency",
+			Routes: []scenario.Route{
+				{Path: "", Handler: s.SecureCurrencyHandler},
+				{Path: "/add-to-cart", Handler: s.CSRF(s.SecureCurrencyAddToCartHandler)},
+				{Path: "/checkout", Handler: s.CSRF(s.SecureCurrencyCheckoutHandler)},
+			},
+		},
+	}
+}
+
+func (s *Server) VulnerableCurrencyHandler(w http.ResponseWriter, r *http.Request) {
+	sessionID := s.getOrCreateSession(w, r)
+	cart := s.Store.GetCart(sessionID, cartVulnerableCurrency)
+	currency := s.displayCurrency(sessionID)
+
+	rate, _ := s.Rates.Rate(currency)
+	displayTotal, _, _ := s.Rates.Convert(cart.Total, currency)
+
+	data := struct {
+		Products     map[string]pricedProduct
+		Cart         models.Cart
+		Currencies   []string
+		Currency     string
+		Rate         string
+		DisplayTotal models.Money
+	}{
+		Products:     s.displayProducts(currency),
+		Cart:         cart,
+		Currencies:   s.Rates.Currencies(),
+		Currency:     currency,
+		Rate:         models.FormatRate(rate),
+		DisplayTotal: displayTotal,
+	}
+
+	s.render(w, r, "vulnerable-currency", data)
+}
+
+func (s *Server) VulnerableCurrencyAddToCartHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Redirect(w, r, "/vulnerable-currency", http.StatusSeeOther)
+		return
+	}
+
+	sessionID := s.getOrCreateSession(w, r)
+	productID := r.FormValue("product_id")
+	quantity, _ := strconv.Atoi(r.FormValue("quantity"))
+
+	product, exists := s.productOnSale(productID)
+	if !exists {
+		http.Redirect(w, r, "/vulnerable-currency", http.StatusSeeOther)
+		return
+	}
+
+	cart := s.Store.GetCart(sessionID, cartVulnerableCurrency)
+	cart.Items = append(cart.Items, models.CartItem{
+		ProductID: productID,
+		Quantity:  quantity,
+		Price:     product.Price,
+	})
+
+	// Recalculate total
+	total, err := models.SumItems(cart.Items)
+	if err != nil {
+		http.Error(w, "Cart total out of range", http.StatusBadRequest)
+		return
+	}
+	cart.Total = total
+
+	s.Store.SetCart(sessionID, cartVulnerableCurrency, cart)
+	http.Redirect(w, r, "/vulnerable-currency", http.StatusSeeOther)
+}
+
+func (s *Server) VulnerableCurrencyCheckoutHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Redirect(w, r, "/vulnerable-currency", http.StatusSeeOther)
+		return
+	}
+
+	sessionID := s.getOrCreateSession(w, r)
+	cart := s.Store.GetCart(sessionID, cartVulnerableCurrency)
+
+	if len(cart.Items) == 0 {
+		http.Redirect(w, r, "/vulnerable-currency", http.StatusSeeOther)
+		return
+	}
+
+	// VULNERABILITY: Currency and rate both come from the client
+	currency := r.FormValue("currency")
+	rate, ok := new(big.Rat).SetString(r.FormValue("rate"))
+	if !ok || rate.Sign() < 0 {
+		rate = big.NewRat(1, 1)
+	}
+
+	// VULNERABILITY: Each unit price is converted and truncated separately,
+	// so sub-unit remainders are dropped once per item instead of once per order
+	charged := models.NewMoney(0, currency)
+	for _, item := range cart.Items {
+		unit, err := models.ConvertAt(item.Price, currency, rate, models.RoundDown)
+		if err != nil {
+			http.Error(w, "Unsupported currency", http.StatusBadRequest)
+			return
+		}
+		line, err := unit.Mul(item.Quantity)
+		if err == nil {
+			charged, err = charged.Add(line)
+		}
+		if err != nil {
+			http.Error(w, "Order total out of range", http.StatusBadRequest)
+			return
+		}
+	}
+
+	session, _ := s.Store.GetSession(sessionID)
+	order := models.NewOrder(cartVulnerableCurrency, session.UserID, cart.Items, charged, "customer")
+	order.BaseTotal = cart.Total
+	order.ExchangeRate = models.FormatRate(rate)
+	order = s.completeInstantOrder(order, "customer")
+	if base, err := s.catalogTotal(cart.Items); err == nil {
+		if fair, _, err := s.Rates.Convert(base, currency); err == nil && charged.Cmp(fair) < 0 {
+			s.exploited(r, "currency")
+		}
+	}
+
+	// Clear cart after checkout
+	s.Store.ClearCart(sessionID, cartVulnerableCurrency)
+
+	data := struct {
+		Order models.Order
+	}{
+		Order: order,
+	}
+
+	s.render(w, r, "vulnerable-currency-checkout", data)
+}
@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"net/http"
+	"secure-webapp/scenario"
+)
+
+// idorScenario pairs the order lookup that trusts any order number with the one
+// scoped to the order's owner
+func (s *Server) idorScenario() scenario.Definition {
+	return scenario.Definition{
+		Title:   "Insecure Direct Object Reference",
+		Group:   "Sessions & Access Control",
+		Summary: "Orders have sequential numbers and anyone who knows one can read it.",
+		Level:   scenario.Beginner,
+		Clues: []string{
+			"Look at your order number. What would the previous one be?",
+		},
+		VulnerableSet: scenario.HandlerSet{
+			Summary: "Sequential order IDs, no ownership check",
+			Landing: "/vulnerable-idor",
+			Prefix:  "/vulnerable-idor",
+			Routes: []scenario.Route{
+				{Path: "", Handler: s.VulnerableIDORHandler},
+				{Path: "/buy", Handler: s.VulnerableIDORBuyHandler},
+				{Path: "/order", Handler: s.VulnerableIDOROrderHandler},
+			},
+		},
+		SecureSet: scenario.HandlerSet{
+			Summary: "Orders scoped to their owner",
+			Landing: "/secure-idor",
+			Prefix:  "/secure-idor",
+			Routes: []scenario.Route{
+				{Path: "", Handler: s.SecureIDORHandler},
+				{Path: "/buy", Handler: s.CSRF(s.SecureIDORBuyHandler)},
+				{Path: "/order", Handler: s.SecureIDOROrderHandler},
+			},
+		},
+	}
+}
+
+func (s *Server) VulnerableIDORHandler(w http.ResponseWriter, r *http.Request) {
+	s.renderIDORShop(w, r, "vulnerable", "Vulnerable Order Lookup Shop",
+		"Warning: Order numbers count up by one and any order can be opened by its number!")
+}
+
+func (s *Server) VulnerableIDORBuyHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Redirect(w, r, "/vulnerable-idor", http.StatusSeeOther)
+		return
+	}
+
+	sessionID := s.getOrCreateSession(w, r)
+	product, quantity, ok := s.parseIDORPurchase(r)
+	if !ok {
+		http.Redirect(w, r, "/vulnerable-idor", http.StatusSeeOther)
+		return
+	}
+
+	// VULNERABILITY: Order IDs are sequential, so every other order's ID is
+	// one subtraction away
+	order, err := s.buyIDOROrder(shopVulnerableIDOR, sessionID, s.orderNumbers.next(s.Store), product, quantity)
+	if err != nil {
+		http.Redirect(w, r, "/vulnerable-idor?error=out_of_stock", http.StatusSeeOther)
+		return
+	}
+
+	http.Redirect(w, r, "/vulnerable-idor/order?id="+order.ID, http.StatusSeeOther)
+}
+
+func (s *Server) VulnerableIDOROrderHandler(w http.ResponseWriter, r *http.Request) {
+	s.getOrCreateSession(w, r)
+
+	// VULNERABILITY: The order is looked up by ID alone - nothing checks it
+	// belongs to the visitor
+	order, exists := s.Store.GetOrder(r.URL.Query().Get("id"))
+	if !exists {
+		http.Error(w, "Order not found", http.StatusNotFound)
+		return
+	}
+	if session, _ := s.currentSession(r); order.UserID != session.UserID {
+		s.exploited(r, "idor")
+	}
+
+	s.renderIDOROrder(w, r, order, "vulnerable")
+}
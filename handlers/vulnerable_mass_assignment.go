@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"secure-webapp/models"
+	"secure-webapp/scenario"
+	"time"
+)
+
+// massAssignmentScenario pairs the express order endpoint that decodes into the stored
+// order with the one that decodes into a DTO
+func (s *Server) massAssignmentScenario() scenario.Definition {
+	return scenario.Definition{
+		Title:   "Mass Assignment",
+		Group:   "APIs",
+		Summary: "The express order endpoint decodes the request straight into the stored order, so clients can set its status and total.",
+		Level:   scenario.Beginner,
+		Clues: []string{
+			"The order has more fields than the items you send.",
+			"What happens if you send a Status?",
+		},
+		// The demo page and the endpoint it calls live under different
+		// prefixes, so the routes carry full paths
+		VulnerableSet: scenario.HandlerSet{
+			Summary: "JSON decoded straight into the order model",
+			Landing: "/vulnerable-mass-assignment",
+			Routes: []scenario.Route{
+				{Path: "/vulnerable-mass-assignment", Handler: s.VulnerableMassAssignmentHandler},
+				{Path: "/api/v1/vulnerable/express-orders", Handler: s.VulnerableExpressOrderHandler},
+			},
+		},
+		SecureSet: scenario.HandlerSet{
+			Summary: "Input DTO that rejects unknown fields",
+			Landing: "/secure-mass-assignment",
+			Routes: []scenario.Route{
+				{Path: "/secure-mass-assignment", Handler: s.SecureMassAssignmentHandler},
+				{Path: "/api/v1/secure/express-orders", Handler: s.SecureExpressOrderHandler},
+			},
+		},
+	}
+}
+
+// Express orders buy a list of items straight away, without a cart
+
+func (s *Server) VulnerableMassAssignmentHandler(w http.ResponseWriter, r *http.Request) {
+	s.renderMassAssignmentShop(w, r, "vulnerable")
+}
+
+func (s *Server) VulnerableExpressOrderHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		methodNotAllowed(w, "POST")
+		return
+	}
+
+	sessionID := s.getOrCreateSession(w, r)
+	session, _ := s.Store.GetSession(sessionID)
+
+	// VULNERABILITY: The body is decoded straight into the stored model, so
+	// the client can set Status, Total, UserID, History - almost every field
+	// the order has, not just the items it meant to send
+	var order models.Order
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxAPIBodySize)).Decode(&order); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid_json", "request body is not valid JSON for this endpoint")
+		return
+	}
+	if len(order.Items) == 0 {
+		writeAPIError(w, http.StatusBadRequest, "no_items", "order has no items")
+		return
+	}
+
+	// Fill in whatever the client left out
+	for i, item := range order.Items {
+		product, exists := s.productOnSale(item.ProductID)
+		if !exists {
+			writeAPIError(w, http.StatusNotFound, "product_not_found", "no such product")
+			return
+		}
+		if item.Price.Currency == "" {
+			order.Items[i].Price = product.Price
+		} else {
+			s.checkClientPrice(r, "Mass Assignment", product, item.Price)
+		}
+	}
+	if order.Total.Currency == "" {
+		total, err := models.SumItems(order.Items)
+		if err != nil {
+			writeAPIError(w, http.StatusBadRequest, "total_out_of_range", "order total out of range")
+			return
+		}
+		order.Total = total
+	}
+	// The ID and shop are always the server's, so an express order can only
+	// ever add a new order to this shop, never replace an existing one
+	order.ID = models.GenerateID()
+	order.Shop = cartVulnerableAPI
+	if order.UserID == "" {
+		order.UserID = session.UserID
+	}
+	if order.Status == "" {
+		order.Status = models.StatusPending
+	}
+	if order.Timestamp.IsZero() {
+		order.Timestamp = time.Now()
+	}
+	if len(order.History) == 0 {
+		order.History = []models.StatusChange{{To: order.Status, At: order.Timestamp, Actor: "customer"}}
+	}
+
+	s.Store.SetOrder(order)
+	if fair, err := s.catalogTotal(order.Items); err != nil || order.Total.Cmp(fair) != 0 || order.Status != models.StatusPending {
+		s.exploited(r, "mass-assignment")
+	}
+	writeJSON(w, http.StatusCreated, toAPIOrder(order))
+}
@@ -1,101 +1,86 @@
 package handlers
 
 import (
-	"html/template"
 	"net/http"
 	"secure-webapp/models"
+	"secure-webapp/scenario"
 	"strconv"
 )
 
-func VulnerablePriceHandler(w http.ResponseWriter, r *http.Request) {
-	sessionID := getOrCreateSession(w, r)
-	cart := models.GetCart(sessionID)
-
-	tmpl := `
-<!DOCTYPE html>
-<html>
-<head>
-    <title>Vulnerable Price Shop</title>
-    <link rel="stylesheet" href="/static/style.css">
-</head>
-<body>
-    <div class="container">
-        <h1>Vulnerable Price Manipulation Shop</h1>
-        <p class="warning">Warning: Prices can be manipulated using browser inspector!</p>
-        
-        <div class="products">
-            <h2>Products</h2>
-            {{range $id, $product := .Products}}
-            <div class="product">
-                <h3>{{$product.Name}}</h3>
-                <p>Price: ${{printf "%.2f" $product.Price}}</p>
-                <form method="POST" action="/vulnerable-price/add-to-cart">
-                    <input type="hidden" name="product_id" value="{{$product.ID}}">
-                    <input type="hidden" name="price" value="{{$product.Price}}" id="price_{{$product.ID}}">
-                    <input type="number" name="quantity" value="1" min="1">
-                    <button type="submit">Add to Cart</button>
-                </form>
-            </div>
-            {{end}}
-        </div>
-
-        <div class="cart">
-            <h2>Cart</h2>
-            {{if .Cart.Items}}
-                {{range .Cart.Items}}
-                <div class="cart-item">
-                    <p>Product ID: {{.ProductID}} - Quantity: {{.Quantity}} - Price: ${{printf "%.2f" .Price}}</p>
-                </div>
-                {{end}}
-                <p><strong>Total: ${{printf "%.2f" .Cart.Total}}</strong></p>
-                <form method="POST" action="/vulnerable-price/checkout">
-                    <button type="submit">Checkout</button>
-                </form>
-            {{else}}
-                <p>Cart is empty</p>
-            {{end}}
-        </div>
-        
-        <a href="/">Back to Home</a>
-    </div>
-</body>
-</html>`
+// priceScenario pairs the shop that prices the cart from a hidden form field with
+// the one that looks prices up in the catalog
+func (s *Server) priceScenario() scenario.Definition {
+	return scenario.Definition{
+		Title:   "Price Manipulation",
+		Group:   "Pricing",
+		Summary: "The cart takes the price from a hidden form field, so the buyer names their own price.",
+		Level:   scenario.Beginner,
+		Clues: []string{
+			"Look at what the add-to-cart form sends besides the product and quantity.",
+			"Hidden fields are just as editable as visible ones.",
+		},
+		VulnerableSet: scenario.HandlerSet{
+			Summary: "Client-side price manipulation",
+			Landing: "/vulnerable-price",
+			Prefix:  "/vulnerable-price",
+			Routes: []scenario.Route{
+				{Path: "", Handler: s.VulnerablePriceHandler},
+				{Path: "/add-to-cart", Handler: s.VulnerablePriceAddToCartHandler},
+				{Path: "/checkout", Handler: s.VulnerablePriceCheckoutHandler},
+			},
+		},
+		SecureSet: scenario.HandlerSet{
+			Summary: "Server-side price validation",
+			Landing: "/secure-price",
+			Prefix:  "/secure-price",
+			Routes: []scenario.Route{
+				{Path: "", Handler: s.SecurePriceHandler},
+				{Path: "/add-to-cart", Handler: s.CSRF(s.SecurePriceAddToCartHandler)},
+				{Path: "/checkout", Handler: s.CSRF(s.SecurePriceCheckoutHandler)},
+			},
+		},
+	}
+}
+
+func (s *Server) VulnerablePriceHandler(w http.ResponseWriter, r *http.Request) {
+	sessionID := s.getOrCreateSession(w, r)
+	cart := s.Store.GetCart(sessionID, cartVulnerablePrice)
 
 	data := struct {
 		Products map[string]models.Product
 		Cart     models.Cart
 	}{
-		Products: models.Products,
+		Products: s.catalog(),
 		Cart:     cart,
 	}
 
-	t, _ := template.New("vulnerable-price").Parse(tmpl)
-	t.Execute(w, data)
+	s.render(w, r, "vulnerable-price", data)
 }
 
-func VulnerablePriceAddToCartHandler(w http.ResponseWriter, r *http.Request) {
+func (s *Server) VulnerablePriceAddToCartHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != "POST" {
 		http.Redirect(w, r, "/vulnerable-price", http.StatusSeeOther)
 		return
 	}
 
-	sessionID := getOrCreateSession(w, r)
+	sessionID := s.getOrCreateSession(w, r)
 	productID := r.FormValue("product_id")
 	quantity, _ := strconv.Atoi(r.FormValue("quantity"))
 
 	// VULNERABILITY: Trust client-side price input
-	clientPrice, err := strconv.ParseFloat(r.FormValue("price"), 64)
+	clientPrice, err := models.ParseMoney(r.FormValue("price"), models.BaseCurrency)
 	if err != nil {
-		clientPrice = 0.0 // Default to 0 if invalid
+		clientPrice = models.NewMoney(0, models.BaseCurrency) // Default to 0 if invalid
 	}
 
-	_, exists := models.GetProduct(productID)
+	product, exists := s.productOnSale(productID)
 	if !exists {
 		http.Redirect(w, r, "/vulnerable-price", http.StatusSeeOther)
 		return
 	}
+	s.checkClientPrice(r, "Price Manipulation", product, clientPrice)
 
-	cart := models.GetCart(sessionID)
+	cart := s.Store.GetCart(sessionID, cartVulnerablePrice)
 	cart.Items = append(cart.Items, models.CartItem{
 		ProductID: productID,
 		Quantity:  quantity,
@@ -103,59 +88,37 @@ func VulnerablePriceAddToCartHandler(w http.ResponseWriter, r *http.Request) {
 	})
 
 	// Recalculate total using manipulated prices
-	cart.Total = 0
-	for _, item := range cart.Items {
-		cart.Total += item.Price * float64(item.Quantity)
+	total, err := models.SumItems(cart.Items)
+	if err != nil {
+		http.Error(w, "Cart total out of range", http.StatusBadRequest)
+		return
 	}
+	cart.Total = total
 
-	models.SetCart(sessionID, cart)
+	s.Store.SetCart(sessionID, cartVulnerablePrice, cart)
 	http.Redirect(w, r, "/vulnerable-price", http.StatusSeeOther)
 }
 
-func VulnerablePriceCheckoutHandler(w http.ResponseWriter, r *http.Request) {
+func (s *Server) VulnerablePriceCheckoutHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != "POST" {
 		http.Redirect(w, r, "/vulnerable-price", http.StatusSeeOther)
 		return
 	}
 
-	sessionID := getOrCreateSession(w, r)
-	cart := models.GetCart(sessionID)
+	sessionID := s.getOrCreateSession(w, r)
+	cart := s.Store.GetCart(sessionID, cartVulnerablePrice)
 
 	if len(cart.Items) == 0 {
 		http.Redirect(w, r, "/vulnerable-price", http.StatusSeeOther)
 		return
 	}
 
-	tmpl := `
-<!DOCTYPE html>
-<html>
-<head>
-    <title>Checkout - Vulnerable Price Shop</title>
-    <link rel="stylesheet" href="/static/style.css">
-</head>
-<body>
-    <div class="container">
-        <h1>Checkout Complete</h1>
-        <p class="warning">Order processed with manipulated prices!</p>
-        
-        <h3>Order Summary:</h3>
-        {{range .Cart.Items}}
-        <div class="order-item">
-            <p>Product ID: {{.ProductID}} - Quantity: {{.Quantity}} - Price: ${{printf "%.2f" .Price}}</p>
-        </div>
-        {{end}}
-        
-        <p><strong>Total Paid: ${{printf "%.2f" .Cart.Total}}</strong></p>
-        <p><small>You successfully manipulated the prices!</small></p>
-        
-        <a href="/vulnerable-price">Back to Shop</a>
-        <a href="/">Home</a>
-    </div>
-</body>
-</html>`
+	if s.underpriced(cart.Items, cart.Total) {
+		s.exploited(r, "price")
+	}
 
 	// Clear cart after checkout
-	models.ClearCart(sessionID)
+	s.Store.ClearCart(sessionID, cartVulnerablePrice)
 
 	data := struct {
 		Cart models.Cart
@@ -163,6 +126,5 @@ func VulnerablePriceCheckoutHandler(w http.ResponseWriter, r *http.Request) {
 		Cart: cart,
 	}
 
-	t, _ := template.New("vulnerable-checkout").Parse(tmpl)
-	t.Execute(w, data)
+	s.render(w, r, "vulnerable-checkout", data)
 }
@@ -0,0 +1,171 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"secure-webapp/models"
+	"secure-webapp/scenario"
+	"strconv"
+)
+
+// quantityScenario pairs the shop that takes quantities at face value with the one
+// that only accepts whole numbers from 1 to 10
+func (s *Server) quantityScenario() scenario.Definition {
+	return scenario.Definition{
+		Title:   "Quantity Tampering",
+		Group:   "Pricing",
+		Summary: "Quantities are taken at face value, so negative, fractional and huge ones turn into refunds and wrapped-around totals.",
+		Level:   scenario.Beginner,
+		Clues: []string{
+			"The quantity box has a minimum in the browser only.",
+			"What does a line cost if its quantity is below zero?",
+			"Totals are 64-bit integers of cents; try the probe values.",
+		},
+		VulnerableSet: scenario.HandlerSet{
+			Summary: "Negative, fractional and overflowing quantities",
+			Landing: "/vulnerable-quantity",
+			Prefix:  "/vulnerable-quantity",
+			Routes: []scenario.Route{
+				{Path: "", Handler: s.VulnerableQuantityHandler},
+				{Path: "/add-to-cart", Handler: s.VulnerableQuantityAddToCartHandler},
+				{Path: "/checkout", Handler: s.VulnerableQuantityCheckoutHandler},
+			},
+		},
+		SecureSet: scenario.HandlerSet{
+			Summary: "Strict 1-10 quantities and checked totals",
+			Landing: "/secure-quantity",
+			Prefix:  "/secure-quantity",
+			Routes: []scenario.Route{
+				{Path: "", Handler: s.SecureQuantityHandler},
+				{Path: "/add-to-cart", Handler: s.CSRF(s.SecureQuantityAddToCartHandler)},
+				{Path: "/checkout", Handler: s.CSRF(s.SecureQuantityCheckoutHandler)},
+			},
+		},
+	}
+}
+
+type quantityProbe struct {
+	Value  string
+	Effect string
+}
+
+// quantityProbes are suggested on both quantity shop pages
+var quantityProbes = []quantityProbe{
+	{"-1", "negative line total"},
+	{"0", "empty line"},
+	{"1.5", "not a whole number"},
+	{"abc", "not a number at all"},
+	{"7023852512030769181", "one Laptop line overflows to $0.03"},
+}
+
+func (s *Server) VulnerableQuantityHandler(w http.ResponseWriter, r *http.Request) {
+	s.renderQuantityShop(w, r, "vulnerable", "Vulnerable Quantity Shop",
+		"Warning: Quantities are not validated and totals are computed with plain integer arithmetic!")
+}
+
+func (s *Server) VulnerableQuantityAddToCartHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Redirect(w, r, "/vulnerable-quantity", http.StatusSeeOther)
+		return
+	}
+
+	sessionID := s.getOrCreateSession(w, r)
+	productID := r.FormValue("product_id")
+
+	// VULNERABILITY: The parse error is ignored, so "1.5" or "abc" become 0,
+	// and negative or enormous values are accepted as they are
+	quantity, _ := strconv.Atoi(r.FormValue("quantity"))
+
+	product, exists := s.productOnSale(productID)
+	if !exists {
+		http.Redirect(w, r, "/vulnerable-quantity", http.StatusSeeOther)
+		return
+	}
+
+	cart := s.Store.GetCart(sessionID, cartVulnerableQuantity)
+	cart.Items = append(cart.Items, models.CartItem{
+		ProductID: productID,
+		Quantity:  quantity,
+		Price:     product.Price,
+	})
+
+	// VULNERABILITY: int64 multiplication and addition wrap around silently
+	cart.Total = models.NewMoney(0, models.BaseCurrency)
+	for _, item := range cart.Items {
+		cart.Total.Amount += item.Price.Amount * int64(item.Quantity)
+	}
+
+	s.Store.SetCart(sessionID, cartVulnerableQuantity, cart)
+	http.Redirect(w, r, "/vulnerable-quantity", http.StatusSeeOther)
+}
+
+func (s *Server) VulnerableQuantityCheckoutHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Redirect(w, r, "/vulnerable-quantity", http.StatusSeeOther)
+		return
+	}
+
+	sessionID := s.getOrCreateSession(w, r)
+	cart := s.Store.GetCart(sessionID, cartVulnerableQuantity)
+	if len(cart.Items) == 0 {
+		http.Redirect(w, r, "/vulnerable-quantity", http.StatusSeeOther)
+		return
+	}
+
+	// VULNERABILITY: Whatever the cart total came to - negative, zero or
+	// wrapped around - is what the customer is charged
+	session, _ := s.Store.GetSession(sessionID)
+	order := s.completeInstantOrder(models.NewOrder(cartVulnerableQuantity, session.UserID, cart.Items, cart.Total, "customer"), "customer")
+	s.Store.ClearCart(sessionID, cartVulnerableQuantity)
+	if s.underpriced(cart.Items, order.Total) {
+		s.exploited(r, "quantity")
+	}
+
+	s.renderQuantityReceipt(w, r, order, "vulnerable")
+}
+
+// renderQuantityShop shows the catalog and cart for both quantity shops
+func (s *Server) renderQuantityShop(w http.ResponseWriter, r *http.Request, shop, title, banner string) {
+	sessionID := s.getOrCreateSession(w, r)
+	cart := s.Store.GetCart(sessionID, shopCart(shop, cartVulnerableQuantity, cartSecureQuantity))
+
+	data := struct {
+		Shop     string
+		Title    string
+		Banner   string
+		Products map[string]models.Product
+		Cart     models.Cart
+		Probes   []quantityProbe
+		Error    string
+	}{
+		Shop:     shop,
+		Title:    title,
+		Banner:   banner,
+		Products: s.catalog(),
+		Cart:     cart,
+		Probes:   quantityProbes,
+		Error:    shopMessages[r.URL.Query().Get("error")],
+	}
+
+	s.render(w, r, "quantity-shop", data)
+}
+
+// renderQuantityReceipt shows the charged order for both quantity shops
+func (s *Server) renderQuantityReceipt(w http.ResponseWriter, r *http.Request, order models.Order, shop string) {
+	var note string
+	if order.Total.Amount <= 0 {
+		note = fmt.Sprintf("The shop owes you %s for this order!", models.NewMoney(-order.Total.Amount, order.Total.Currency))
+	}
+
+	data := struct {
+		Order models.Order
+		Note  string
+		Shop  string
+	}{
+		Order: order,
+		Note:  note,
+		Shop:  shop,
+	}
+
+	s.render(w, r, "quantity-receipt", data)
+}
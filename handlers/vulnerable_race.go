@@ -0,0 +1,133 @@
+package handlers
+
+import (
+	"net/http"
+	"secure-webapp/models"
+	"secure-webapp/scenario"
+	"strconv"
+	"time"
+)
+
+// raceScenario pairs the check-then-act stock shop with the one that reserves
+// stock atomically
+func (s *Server) raceScenario() scenario.Definition {
+	return scenario.Definition{
+		Title:   "Race Conditions",
+		Group:   "Order Flow",
+		Summary: "Stock is checked and decremented in separate steps, so simultaneous purchases oversell the last unit.",
+		Level:   scenario.Advanced,
+		Clues: []string{
+			"One laptop is left. What if two people buy it at exactly the same moment?",
+			"Send many purchase requests in parallel rather than one after another.",
+		},
+		VulnerableSet: scenario.HandlerSet{
+			Summary: "Check-then-act stock decrement oversells",
+			Landing: "/vulnerable-race",
+			Prefix:  "/vulnerable-race",
+			Routes: []scenario.Route{
+				{Path: "", Handler: s.VulnerableRaceHandler},
+				{Path: "/buy", Handler: s.VulnerableRaceBuyHandler},
+			},
+		},
+		SecureSet: scenario.HandlerSet{
+			Summary: "Atomic reserve-and-decrement",
+			Landing: "/secure-race",
+			Prefix:  "/secure-race",
+			Routes: []scenario.Route{
+				{Path: "", Handler: s.SecureRaceHandler},
+				{Path: "/buy", Handler: s.CSRF(s.SecureRaceBuyHandler)},
+			},
+		},
+	}
+}
+
+// paymentProcessingDelay is how long a card authorisation takes
+var paymentProcessingDelay = 50 * time.Millisecond
+
+// processPayment stands in for the card authorisation that happens between
+// checking stock and recording the sale, which is where the race window
+// lives. Tests replace it to hold a buyer inside the window.
+var processPayment = func() { time.Sleep(paymentProcessingDelay) }
+
+func (s *Server) VulnerableRaceHandler(w http.ResponseWriter, r *http.Request) {
+	s.getOrCreateSession(w, r)
+
+	data := struct {
+		Products map[string]models.Product
+		Error    string
+	}{
+		Products: s.catalog(),
+		Error:    shopMessages[r.URL.Query().Get("error")],
+	}
+
+	s.render(w, r, "vulnerable-race", data)
+}
+
+func (s *Server) VulnerableRaceBuyHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Redirect(w, r, "/vulnerable-race", http.StatusSeeOther)
+		return
+	}
+
+	sessionID := s.getOrCreateSession(w, r)
+	productID := r.FormValue("product_id")
+	quantity, err := strconv.Atoi(r.FormValue("quantity"))
+	if err != nil || quantity < 1 {
+		http.Redirect(w, r, "/vulnerable-race", http.StatusSeeOther)
+		return
+	}
+
+	// VULNERABILITY: Time of check - stock is read under one lock acquisition...
+	product, exists := s.productOnSale(productID)
+	if !exists {
+		http.Redirect(w, r, "/vulnerable-race", http.StatusSeeOther)
+		return
+	}
+	if product.Available() < quantity {
+		http.Redirect(w, r, "/vulnerable-race?error=out_of_stock", http.StatusSeeOther)
+		return
+	}
+
+	processPayment()
+
+	// ...time of use - and the stock count is written back from the stale
+	// copy under another, so every request that passed the check above sells
+	// the same units
+	if current, _ := s.Store.GetProduct(productID); current.Available() < quantity {
+		s.exploited(r, "race")
+	}
+	if err := s.Store.SetStock(productID, product.Stock-quantity); err != nil {
+		http.Redirect(w, r, "/vulnerable-race", http.StatusSeeOther)
+		return
+	}
+
+	s.renderRacePurchase(w, r, sessionID, product, quantity, "vulnerable")
+}
+
+// renderRacePurchase records the completed order and shows the receipt for
+// both race shops
+func (s *Server) renderRacePurchase(w http.ResponseWriter, r *http.Request, sessionID string, product models.Product, quantity int, shop string) {
+	total, err := product.Price.Mul(quantity)
+	if err != nil {
+		http.Error(w, "Order total out of range", http.StatusBadRequest)
+		return
+	}
+
+	session, _ := s.Store.GetSession(sessionID)
+	items := []models.CartItem{{ProductID: product.ID, Quantity: quantity, Price: product.Price}}
+	order := s.completeInstantOrder(models.NewOrder(shopCart(shop, shopVulnerableRace, shopSecureRace), session.UserID, items, total, "customer"), "customer")
+
+	current, _ := s.Store.GetProduct(product.ID)
+
+	data := struct {
+		Order   models.Order
+		Product models.Product
+		Shop    string
+	}{
+		Order:   order,
+		Product: current,
+		Shop:    shop,
+	}
+
+	s.render(w, r, "race-purchase", data)
+}
@@ -0,0 +1,122 @@
+package handlers
+
+import (
+	"net/http"
+	"secure-webapp/models"
+	"secure-webapp/scenario"
+	"time"
+)
+
+// sessionScenario pairs the sign-in that keeps a planted session ID with the one
+// that rotates it
+func (s *Server) sessionScenario() scenario.Definition {
+	return scenario.Definition{
+		Title:   "Session Fixation",
+		Group:   "Sessions & Access Control",
+		Summary: "A session ID chosen by the visitor is adopted and kept through sign-in, so an attacker can plant one and ride the victim's login.",
+		Level:   scenario.Intermediate,
+		Clues: []string{
+			"Can you choose your own session ID?",
+			"Does the session ID change when you sign in?",
+		},
+		VulnerableSet: scenario.HandlerSet{
+			Summary: "Client-chosen session IDs survive sign-in",
+			Landing: "/vulnerable-session",
+			Prefix:  "/vulnerable-session",
+			Routes: []scenario.Route{
+				{Path: "", Handler: s.VulnerableSessionHandler},
+				{Path: "/login", Handler: s.VulnerableSessionLoginHandler},
+				{Path: "/logout", Handler: s.VulnerableSessionLogoutHandler},
+			},
+		},
+		SecureSet: scenario.HandlerSet{
+			Summary: "Server-issued, rotated and expiring sessions",
+			Landing: "/secure-session",
+			Prefix:  "/secure-session",
+			Routes:  []scenario.Route{{Path: "", Handler: s.SecureSessionHandler}},
+		},
+	}
+}
+
+// The fixation demo keeps its sessions apart from the real ones: a separate
+// cookie, and a store key prefix that can never pass validSessionID
+const (
+	fixationCookieName = "demo_session"
+	fixationKeyPrefix  = "fixation:"
+)
+
+// adoptSession is how session handling used to work: whatever ID the
+// browser presents becomes a session
+func (s *Server) adoptSession(w http.ResponseWriter, r *http.Request) models.Session {
+	var id string
+	if cookie, err := r.Cookie(fixationCookieName); err == nil && cookie.Value != "" {
+		id = cookie.Value
+	} else {
+		id = models.GenerateID()
+		http.SetCookie(w, &http.Cookie{
+			Name:  fixationCookieName,
+			Value: id,
+			Path:  "/",
+		})
+	}
+
+	// VULNERABILITY: An unknown ID is registered as-is instead of replaced
+	session, exists := s.Store.GetSession(fixationKeyPrefix + id)
+	if !exists {
+		now := time.Now()
+		session = models.Session{ID: fixationKeyPrefix + id, UserID: models.GenerateID(), Currency: models.BaseCurrency, CreatedAt: now, LastSeen: now}
+		s.Store.SetSession(session)
+	}
+	return session
+}
+
+func (s *Server) VulnerableSessionHandler(w http.ResponseWriter, r *http.Request) {
+	// VULNERABILITY: The session ID can be set from a link
+	if sid := r.URL.Query().Get("sid"); sid != "" {
+		http.SetCookie(w, &http.Cookie{
+			Name:  fixationCookieName,
+			Value: sid,
+			Path:  "/",
+		})
+		http.Redirect(w, r, "/vulnerable-session", http.StatusSeeOther)
+		return
+	}
+
+	session := s.adoptSession(w, r)
+	if signer, ok := s.fixationSignIns.Load(session.ID); ok && session.Username != "" {
+		if visitor, _ := s.currentSession(r); visitor.ID != signer {
+			s.exploited(r, "session")
+		}
+	}
+
+	s.render(w, r, "vulnerable-session", session)
+}
+
+func (s *Server) VulnerableSessionLoginHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Redirect(w, r, "/vulnerable-session", http.StatusSeeOther)
+		return
+	}
+
+	// VULNERABILITY: Signing in keeps the same session ID
+	session := s.adoptSession(w, r)
+	session.Username = r.FormValue("username")
+	s.Store.SetSession(session)
+	visitor, _ := s.currentSession(r)
+	s.fixationSignIns.Store(session.ID, visitor.ID)
+
+	http.Redirect(w, r, "/vulnerable-session", http.StatusSeeOther)
+}
+
+func (s *Server) VulnerableSessionLogoutHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Redirect(w, r, "/vulnerable-session", http.StatusSeeOther)
+		return
+	}
+
+	session := s.adoptSession(w, r)
+	session.Username = ""
+	s.Store.SetSession(session)
+
+	http.Redirect(w, r, "/vulnerable-session", http.StatusSeeOther)
+}
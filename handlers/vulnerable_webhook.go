@@ -0,0 +1,101 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"secure-webapp/models"
+	"secure-webapp/scenario"
+)
+
+// webhookScenario pairs the demo of the unauthenticated payment webhook with the
+// demo of the signed one
+func (s *Server) webhookScenario() scenario.Definition {
+	return scenario.Definition{
+		Title:   "Webhook Forgery",
+		Group:   "Order Flow",
+		Summary: "The payment webhook is not authenticated, so anyone who can reach it can mark an order paid.",
+		Level:   scenario.Intermediate,
+		Clues: []string{
+			"Find the URL the payment provider calls back.",
+			"What stops you from sending that request yourself?",
+		},
+		VulnerableSet: scenario.HandlerSet{
+			Summary: "Unauthenticated payment webhook",
+			Landing: "/vulnerable-webhook",
+			Prefix:  "/vulnerable-webhook",
+			Routes:  []scenario.Route{{Path: "", Handler: s.VulnerableWebhookDemoHandler}},
+		},
+		SecureSet: scenario.HandlerSet{
+			Summary: "Signed, timestamped, single-use and amount-checked",
+			Landing: "/secure-webhook",
+			Prefix:  "/secure-webhook",
+			Routes:  []scenario.Route{{Path: "", Handler: s.SecureWebhookDemoHandler}},
+		},
+	}
+}
+
+type vulnerableWebhookPayload struct {
+	OrderID string `json:"order_id"`
+	Status  string `json:"status"`
+}
+
+// Payment notifications for the vulnerable order shop
+func (s *Server) VulnerableWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var payload vulnerableWebhookPayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "Invalid payload", http.StatusBadRequest)
+		return
+	}
+
+	// VULNERABILITY: No signature, timestamp, nonce or amount check - anyone
+	// who can reach this URL can mark any of the shop's orders as paid
+	order, exists := s.shopOrder(cartVulnerableOrder, payload.OrderID)
+	if !exists {
+		http.Error(w, "Order not found", http.StatusNotFound)
+		return
+	}
+
+	var err error
+	switch payload.Status {
+	case "paid":
+		if order.Status == models.StatusPending {
+			s.Store.TransitionOrder(order.ID, models.StatusAwaitingPayment, "webhook")
+		}
+		if order, err = s.Store.TransitionOrder(order.ID, models.StatusPaid, "webhook"); err == nil {
+			order, err = s.Store.TransitionOrder(order.ID, models.StatusFulfilled, "system")
+		}
+	case "failed":
+		order, err = s.Store.TransitionOrder(order.ID, models.StatusCancelled, "webhook")
+	default:
+		http.Error(w, "Unknown status", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if order.Status == models.StatusFulfilled {
+		s.exploited(r, "webhook")
+	}
+
+	writeJSON(w, http.StatusOK, map[string]string{"order_id": order.ID, "status": string(order.Status)})
+}
+
+// Explains the vulnerable receiver and lets visitors forge a delivery
+func (s *Server) VulnerableWebhookDemoHandler(w http.ResponseWriter, r *http.Request) {
+	sessionID := s.getOrCreateSession(w, r)
+	session, _ := s.Store.GetSession(sessionID)
+
+	data := struct {
+		Orders []models.Order
+	}{
+		Orders: s.shopOrdersForUser(cartVulnerableOrder, session.UserID),
+	}
+
+	s.render(w, r, "vulnerable-webhook", data)
+}
@@ -1,47 +1,181 @@
 package main
 
 import (
+	"embed"
+	"errors"
+	"flag"
+	"io/fs"
 	"log"
 	"net/http"
+	"secure-webapp/gateway"
 	"secure-webapp/handlers"
 	"secure-webapp/models"
+	"time"
 )
 
+// Stylesheets and other assets served under /static/ outside dev mode
+//
+//go:embed static
+var embeddedStatic embed.FS
+
+// adminUsername is the account -admin-password sets up
+const adminUsername = "admin"
+
 func main() {
+	dataDir := flag.String("data-dir", "", "directory for the durable order/cart/session log (in-memory only if empty)")
+	ratesFile := flag.String("rates", "rates.json", "exchange-rate table for display currencies")
+	publicURL := flag.String("public-url", "http://localhost:8080", "base URL the payment gateway sends webhooks to")
+	webhookSecret := flag.String("webhook-secret", "", "HMAC key for payment gateway webhooks (random if empty)")
+	devMode := flag.Bool("dev", false, "read templates and static/ from disk and reload them when they change (run from the repository root)")
+	secureCookies := flag.Bool("secure-cookies", false, "mark the session cookie Secure (set when serving over HTTPS behind a proxy)")
+	adminPassword := flag.String("admin-password", "", "create the \"admin\" account with this password and serve the instructor dashboard at /admin and the catalog admin at /admin/products")
+	ctf := flag.Bool("ctf", false, "capture-the-flag mode: exploiting a vulnerable shop reveals a flag teams can score")
+	ctfSecret := flag.String("ctf-secret", "", "HMAC key CTF flags are derived from (random if empty; set it to keep flags valid across restarts)")
+	flag.Parse()
+
 	// Initialize data stores
-	models.InitStores()
-
-	// Static files
-	http.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.Dir("static/"))))
-
-	// Routes
-	http.HandleFunc("/", handlers.HomeHandler)
-
-	// Vulnerable Order Processing Shop
-	http.HandleFunc("/vulnerable-order", handlers.VulnerableOrderHandler)
-	http.HandleFunc("/vulnerable-order/add-to-cart", handlers.VulnerableAddToCartHandler)
-	http.HandleFunc("/vulnerable-order/checkout", handlers.VulnerableCheckoutHandler)
-	http.HandleFunc("/vulnerable-order/pay", handlers.VulnerablePayHandler)
-	http.HandleFunc("/vulnerable-order/confirm", handlers.VulnerableConfirmHandler)
-	http.HandleFunc("/vulnerable-order/result", handlers.VulnerableOrderResultHandler)
-
-	// Secure Order Processing Shop
-	http.HandleFunc("/secure-order", handlers.SecureOrderHandler)
-	http.HandleFunc("/secure-order/add-to-cart", handlers.SecureAddToCartHandler)
-	http.HandleFunc("/secure-order/checkout", handlers.SecureCheckoutHandler)
-	http.HandleFunc("/secure-order/pay", handlers.SecurePayHandler)
-	http.HandleFunc("/secure-order/result", handlers.SecureOrderResultHandler)
-
-	// Vulnerable Price Manipulation Shop
-	http.HandleFunc("/vulnerable-price", handlers.VulnerablePriceHandler)
-	http.HandleFunc("/vulnerable-price/add-to-cart", handlers.VulnerablePriceAddToCartHandler)
-	http.HandleFunc("/vulnerable-price/checkout", handlers.VulnerablePriceCheckoutHandler)
-
-	// Secure Price Manipulation Shop
-	http.HandleFunc("/secure-price", handlers.SecurePriceHandler)
-	http.HandleFunc("/secure-price/add-to-cart", handlers.SecurePriceAddToCartHandler)
-	http.HandleFunc("/secure-price/checkout", handlers.SecurePriceCheckoutHandler)
+	var store models.Store
+	if *dataDir == "" {
+		store = models.NewMemoryStore()
+		models.InitStores(store)
+	} else {
+		fileStore, err := models.OpenFileStore(*dataDir)
+		if err != nil {
+			log.Fatalf("Opening data store: %v", err)
+		}
+		models.InitStores(fileStore)
+		if err := fileStore.Recover(); err != nil {
+			log.Fatalf("Recovering data store: %v", err)
+		}
+		store = fileStore
+	}
+	s := handlers.NewServer(store)
+	s.SecureCookies = *secureCookies
+
+	rates, err := models.LoadRates(*ratesFile)
+	if err != nil {
+		log.Fatalf("Loading exchange rates: %v", err)
+	}
+	s.Rates = rates
+
+	// In-process payment gateway simulator
+	if *webhookSecret != "" {
+		s.WebhookSecret = []byte(*webhookSecret)
+	}
+	s.Gateway = gateway.New(s.WebhookSecret, *publicURL+"/secure-order/webhook")
+
+	if *adminPassword != "" {
+		admin, err := ensureAdmin(store, *adminPassword)
+		if err != nil {
+			log.Fatalf("Setting up the admin account: %v", err)
+		}
+		s.Admins = []string{admin.ID}
+		log.Println("Instructor dashboard: sign in as admin at /admin")
+	}
+
+	if *ctf {
+		s.CTFSecret = []byte(*ctfSecret)
+		if *ctfSecret == "" {
+			s.CTFSecret = []byte(models.GenerateID())
+		}
+		log.Println("CTF mode: flags, scoring and the leaderboard are at /ctf")
+	}
+
+	// Release stock held by orders that were never paid
+	go s.RunReservationReaper(time.Minute, nil)
+
+	// Drop sessions past their idle or absolute lifetime
+	go s.RunSessionReaper(time.Minute, nil)
+
+	// Templates and static files are embedded in the binary; dev mode reads
+	// them from the working tree instead so edits show up without a restart
+	var static http.Handler
+	if *devMode {
+		go s.WatchTemplates("handlers", time.Second, nil)
+		static = noStore(http.FileServer(http.Dir("static")))
+		log.Println("Dev mode: serving templates and static files from disk")
+	} else {
+		staticFS, err := fs.Sub(embeddedStatic, "static")
+		if err != nil {
+			log.Fatalf("Loading static files: %v", err)
+		}
+		static = http.FileServer(http.FS(staticFS))
+	}
 
 	log.Println("Server starting on :8080")
-	log.Fatal(http.ListenAndServe(":8080", nil))
+	log.Fatal(http.ListenAndServe(":8080", routes(s, static)))
+}
+
+// routes maps every URL the app serves to its handler
+func routes(s *handlers.Server, static http.Handler) *http.ServeMux {
+	// The vulnerable/secure pairs come from the scenario registry; what is
+	// left here is shared by all of them
+	mux := http.NewServeMux()
+	mux.Handle("/static/", http.StripPrefix("/static/", static))
+	mux.HandleFunc("/", s.HomeHandler)
+	s.Scenarios.Mount(mux)
+
+	// Accounts
+	mux.HandleFunc("/register", s.CSRF(s.RegisterHandler))
+	mux.HandleFunc("/login", s.CSRF(s.LoginHandler))
+	mux.HandleFunc("/logout", s.CSRF(s.LogoutHandler))
+	mux.HandleFunc("/account", s.AccountHandler)
+
+	// Display currency
+	mux.HandleFunc("/currency", s.CSRF(s.CurrencyHandler))
+
+	// JSON API
+	mux.HandleFunc("/api/", s.APINotFoundHandler)
+	mux.HandleFunc("/api/openapi.json", s.OpenAPIHandler)
+
+	// Instructor dashboard and catalog administration
+	if len(s.Admins) > 0 {
+		mux.HandleFunc("/admin", s.Admin(s.AdminHandler))
+		mux.HandleFunc("/admin/events", s.Admin(s.AdminEventsHandler))
+		mux.HandleFunc("/admin/products", s.Admin(s.CSRF(s.AdminProductsHandler)))
+		mux.HandleFunc("/admin/products/update", s.Admin(s.CSRF(s.AdminProductUpdateHandler)))
+		mux.HandleFunc("/api/v1/admin/products", s.AdminAPI(s.AdminAPIProductsHandler))
+		mux.HandleFunc("/api/v1/admin/products/{id}", s.AdminAPI(s.AdminAPIProductHandler))
+		mux.HandleFunc("/api/v1/admin/product-changes", s.AdminAPI(s.AdminAPIProductChangesHandler))
+	}
+
+	// Capture-the-flag mode
+	if len(s.CTFSecret) > 0 {
+		mux.HandleFunc("/ctf", s.CTFHandler)
+		mux.HandleFunc("/ctf/join", s.CSRF(s.CTFJoinHandler))
+		mux.HandleFunc("/ctf/submit", s.CSRF(s.CTFSubmitHandler))
+		mux.HandleFunc("/ctf/leaderboard", s.CTFLeaderboardHandler)
+	}
+
+	// Mock Payment Gateway
+	mux.HandleFunc("/gateway/charges", s.Gateway.ChargesHandler)
+	mux.HandleFunc("/gateway/3ds", s.Gateway.ChallengeHandler)
+
+	return mux
+}
+
+// ensureAdmin creates the admin account, or checks the password of the one a
+// durable store already holds so a changed flag doesn't silently keep the old
+// password working
+func ensureAdmin(store models.Store, password string) (models.User, error) {
+	if user, exists := store.GetUserByUsername(adminUsername); exists {
+		if !models.CheckPassword(user.PasswordHash, password) {
+			return models.User{}, errors.New("the stored admin account has a different password")
+		}
+		return user, nil
+	}
+	user, err := models.NewUser(adminUsername, password)
+	if err != nil {
+		return models.User{}, err
+	}
+	return user, store.CreateUser(user)
+}
+
+// noStore stops browsers caching dev-mode assets, so an edited stylesheet is
+// picked up on the next reload
+func noStore(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "no-store")
+		next.ServeHTTP(w, r)
+	})
 }
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"net/http/httptest"
+	"secure-webapp/handlers"
+	"secure-webapp/models"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// registeredRoutes returns the patterns main.go passes to mux.HandleFunc
+// and mux.Handle, plus those the scenario registry mounts
+func registeredRoutes(t *testing.T) []string {
+	t.Helper()
+
+	file, err := parser.ParseFile(token.NewFileSet(), "main.go", nil, 0)
+	if err != nil {
+		t.Fatalf("parsing main.go: %v", err)
+	}
+
+	var routes []string
+	ast.Inspect(file, func(n ast.Node) bool {
+		call, ok := n.(*ast.CallExpr)
+		if !ok || len(call.Args) == 0 {
+			return true
+		}
+		sel, ok := call.Fun.(*ast.SelectorExpr)
+		if !ok || (sel.Sel.Name != "HandleFunc" && sel.Sel.Name != "Handle") {
+			return true
+		}
+		if recv, ok := sel.X.(*ast.Ident); !ok || recv.Name != "mux" {
+			return true
+		}
+		lit, ok := call.Args[0].(*ast.BasicLit)
+		if !ok || lit.Kind != token.STRING {
+			t.Errorf("route registered with a non-literal pattern; the spec check can't see it")
+			return true
+		}
+		pattern, _ := strconv.Unquote(lit.Value)
+		routes = append(routes, pattern)
+		return true
+	})
+	return append(routes, handlers.NewServer(models.NewMemoryStore()).Scenarios.Patterns()...)
+}
+
+func TestAPIRoutesHaveSpecEntries(t *testing.T) {
+	rec := httptest.NewRecorder()
+	(&handlers.Server{}).OpenAPIHandler(rec, httptest.NewRequest("GET", "/api/openapi.json", nil))
+	if rec.Code != 200 {
+		t.Fatalf("GET /api/openapi.json = %d, want 200", rec.Code)
+	}
+
+	var spec struct {
+		OpenAPI string                    `json:"openapi"`
+		Paths   map[string]map[string]any `json:"paths"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &spec); err != nil {
+		t.Fatalf("decoding spec: %v", err)
+	}
+	if !strings.HasPrefix(spec.OpenAPI, "3.") {
+		t.Errorf("openapi version = %q, want 3.x", spec.OpenAPI)
+	}
+
+	registered := map[string]bool{}
+	for _, route := range registeredRoutes(t) {
+		// "/api/" only catches unknown paths and answers them with a 404
+		if !strings.HasPrefix(route, "/api/") || route == "/api/" {
+			continue
+		}
+		registered[route] = true
+		if len(spec.Paths[route]) == 0 {
+			t.Errorf("%s is registered but the OpenAPI spec has no entry for it", route)
+		}
+	}
+	if len(registered) == 0 {
+		t.Fatal("found no registered /api routes")
+	}
+
+	for path := range spec.Paths {
+		if !registered[path] {
+			t.Errorf("OpenAPI spec documents %s but nothing registers it", path)
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package models
+
+import (
+	"errors"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+var (
+	ErrProductNotFound  = errors.New("product not found")
+	ErrInvalidProduct   = errors.New("product name must be 1-64 characters")
+	ErrInvalidPrice     = errors.New("price must be a positive amount in " + BaseCurrency)
+	ErrInvalidStock     = errors.New("stock can't be negative or below what pending orders hold")
+	ErrNoProductChanges = errors.New("nothing to change")
+)
+
+// ProductAction says what a catalog change did
+type ProductAction string
+
+const (
+	ProductCreated  ProductAction = "created"
+	ProductUpdated  ProductAction = "updated"
+	ProductArchived ProductAction = "archived"
+	ProductRestored ProductAction = "restored"
+)
+
+// ProductChange is one entry in the catalog audit log: who changed which
+// product when, and what it looked like before and after. Old fields are
+// zero for a created product.
+type ProductChange struct {
+	ProductID string
+	Action    ProductAction
+	Actor     string
+	At        time.Time
+	OldName   string
+	NewName   string
+	OldPrice  Money
+	NewPrice  Money
+	OldStock  int
+	NewStock  int
+}
+
+// Validate checks the fields an admin can edit
+func (p Product) Validate() error {
+	if n := utf8.RuneCountInString(p.Name); n < 1 || n > 64 || strings.TrimSpace(p.Name) != p.Name {
+		return ErrInvalidProduct
+	}
+	if p.Price.Currency != BaseCurrency || p.Price.Amount <= 0 {
+		return ErrInvalidPrice
+	}
+	if p.Stock < 0 || p.Stock < p.Reserved {
+		return ErrInvalidStock
+	}
+	return nil
+}
+
+func productChange(action ProductAction, actor string, before, after Product) ProductChange {
+	return ProductChange{
+		ProductID: after.ID,
+		Action:    action,
+		Actor:     actor,
+		At:        time.Now(),
+		OldName:   before.Name,
+		NewName:   after.Name,
+		OldPrice:  before.Price,
+		NewPrice:  after.Price,
+		OldStock:  before.Stock,
+		NewStock:  after.Stock,
+	}
+}
+
+// CreateProduct validates product, gives it the next numeric ID and adds it
+// to the catalog
+func (s *MemoryStore) CreateProduct(product Product, actor string) (Product, error) {
+	product, _, err := s.createProduct(product, actor)
+	return product, err
+}
+
+func (s *MemoryStore) createProduct(product Product, actor string) (Product, ProductChange, error) {
+	s.productsMutex.Lock()
+	defer s.productsMutex.Unlock()
+
+	product.Name = strings.TrimSpace(product.Name)
+	product.Reserved = 0
+	if err := product.Validate(); err != nil {
+		return Product{}, ProductChange{}, err
+	}
+
+	next := 1
+	for id := range s.products {
+		if n, err := strconv.Atoi(id); err == nil && n >= next {
+			next = n + 1
+		}
+	}
+	product.ID = strconv.Itoa(next)
+
+	change := productChange(ProductCreated, actor, Product{}, product)
+	s.products[product.ID] = product
+	s.productChanges = append(s.productChanges, change)
+	return product, change, nil
+}
+
+// UpdateProduct applies update to a product under the catalog lock, so stock
+// reserved meanwhile isn't lost, and records the change. The ID and reserved
+// stock can't be changed, and an update that leaves the product as it was is
+// refused with ErrNoProductChanges.
+func (s *MemoryStore) UpdateProduct(id, actor string, update func(*Product) error) (Product, error) {
+	product, _, err := s.updateProduct(id, actor, update)
+	return product, err
+}
+
+func (s *MemoryStore) updateProduct(id, actor string, update func(*Product) error) (Product, ProductChange, error) {
+	s.productsMutex.Lock()
+	defer s.productsMutex.Unlock()
+
+	before, exists := s.products[id]
+	if !exists {
+		return Product{}, ProductChange{}, ErrProductNotFound
+	}
+	after := before
+	if err := update(&after); err != nil {
+		return before, ProductChange{}, err
+	}
+	after.ID, after.Reserved = before.ID, before.Reserved
+	after.Name = strings.TrimSpace(after.Name)
+	if after == before {
+		return before, ProductChange{}, ErrNoProductChanges
+	}
+	if err := after.Validate(); err != nil {
+		return before, ProductChange{}, err
+	}
+
+	action := ProductUpdated
+	switch {
+	case after.Archived && !before.Archived:
+		action = ProductArchived
+	case !after.Archived && before.Archived:
+		action = ProductRestored
+	}
+	change := productChange(action, actor, before, after)
+	s.products[id] = after
+	s.productChanges = append(s.productChanges, change)
+	return after, change, nil
+}
+
+// ListProductChanges returns the catalog audit log, oldest first
+func (s *MemoryStore) ListProductChanges() []ProductChange {
+	s.productsMutex.RLock()
+	defer s.productsMutex.RUnlock()
+	return append([]ProductChange(nil), s.productChanges...)
+}
+
+// restoreProduct puts a logged catalog change back when the FileStore replays
+// it. The audit entry is skipped if the snapshot already has it.
+func (s *MemoryStore) restoreProduct(product Product, change ProductChange) {
+	s.productsMutex.Lock()
+	defer s.productsMutex.Unlock()
+	s.products[product.ID] = product
+	if !slices.ContainsFunc(s.productChanges, func(c ProductChange) bool {
+		return c.ProductID == change.ProductID && c.Action == change.Action && c.At.Equal(change.At)
+	}) {
+		s.productChanges = append(s.productChanges, change)
+	}
+}
@@ -0,0 +1,272 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+)
+
+type CouponKind string
+
+const (
+	CouponPercent  CouponKind = "percent"     // Percent off the subtotal
+	CouponFixed    CouponKind = "fixed"       // Amount off the subtotal
+	CouponBuyXGetY CouponKind = "buy_x_get_y" // of every Buy+Get units of ProductID, Get are free
+)
+
+var (
+	ErrCouponUnknown       = errors.New("unknown coupon code")
+	ErrCouponExpired       = errors.New("coupon has expired")
+	ErrCouponMinSpend      = errors.New("cart is below the coupon's minimum spend")
+	ErrCouponNotApplicable = errors.New("coupon does not apply to anything in the cart")
+	ErrCouponNotStackable  = errors.New("coupon cannot be combined with other coupons")
+	ErrCouponAlreadyUsed   = errors.New("coupon is already applied")
+	ErrCouponLimit         = errors.New("coupon redemption limit reached")
+)
+
+type Coupon struct {
+	Code string
+	Kind CouponKind
+
+	Percent   int   // CouponPercent: 1-100
+	Amount    Money // CouponFixed
+	ProductID string
+	Buy, Get  int // CouponBuyXGetY
+
+	MinSpend     Money     // zero for no minimum
+	PerUserLimit int       // redemptions per user, 0 for unlimited
+	GlobalLimit  int       // redemptions overall, 0 for unlimited
+	ExpiresAt    time.Time // zero for never
+	// Stackable coupons can be combined with each other; a non-stackable one
+	// must be the only coupon on the cart
+	Stackable bool
+}
+
+// Redemption records a coupon used on a placed order. Each shop keeps its
+// own ledger, so a redemption only counts towards the limits of the Shop
+// that took it.
+type Redemption struct {
+	Shop    string
+	Code    string
+	UserID  string
+	OrderID string
+	At      time.Time
+}
+
+// Describe is a short human-readable summary of the offer
+func (c Coupon) Describe() string {
+	var offer string
+	switch c.Kind {
+	case CouponPercent:
+		offer = fmt.Sprintf("%d%% off", c.Percent)
+	case CouponFixed:
+		offer = c.Amount.String() + " off"
+	case CouponBuyXGetY:
+		offer = fmt.Sprintf("buy %d get %d free on product %s", c.Buy, c.Get, c.ProductID)
+	}
+	if c.MinSpend.Amount > 0 {
+		offer += " when you spend " + c.MinSpend.String()
+	}
+	return offer
+}
+
+// Discount works out what the coupon takes off a cart whose lines add up to
+// subtotal. It checks expiry, minimum spend and applicability, but not
+// redemption limits, which need the store.
+func (c Coupon) Discount(items []CartItem, subtotal Money, now time.Time) (Money, error) {
+	if !c.ExpiresAt.IsZero() && !now.Before(c.ExpiresAt) {
+		return Money{}, ErrCouponExpired
+	}
+	if subtotal.Cmp(c.MinSpend) < 0 {
+		return Money{}, ErrCouponMinSpend
+	}
+
+	var discount Money
+	switch c.Kind {
+	case CouponPercent:
+		scaled, err := subtotal.Mul(c.Percent)
+		if err != nil {
+			return Money{}, err
+		}
+		// Round down, in the customer's disfavour by at most one minor unit
+		discount = Money{Amount: scaled.Amount / 100, Currency: subtotal.Currency}
+	case CouponFixed:
+		discount = c.Amount
+	case CouponBuyXGetY:
+		quantity := 0
+		var price Money
+		for _, item := range items {
+			if item.ProductID == c.ProductID && item.Quantity > 0 {
+				quantity += item.Quantity
+				price = item.Price
+			}
+		}
+		if c.Buy < 1 || c.Get < 1 {
+			return Money{}, ErrCouponNotApplicable
+		}
+		free := quantity / (c.Buy + c.Get) * c.Get
+		if free == 0 {
+			return Money{}, ErrCouponNotApplicable
+		}
+		var err error
+		if discount, err = price.Mul(free); err != nil {
+			return Money{}, err
+		}
+	default:
+		return Money{}, ErrCouponNotApplicable
+	}
+
+	// A coupon never takes off more than the cart is worth
+	if discount.Cmp(subtotal) > 0 {
+		discount = subtotal
+	}
+	return discount, nil
+}
+
+// PriceCart totals items and applies coupons under the stacking rules: any
+// number of stackable coupons, or exactly one that isn't, each code at most
+// once. The total never drops below zero.
+func PriceCart(items []CartItem, coupons []Coupon, now time.Time) (subtotal, discount, total Money, err error) {
+	if subtotal, err = SumItems(items); err != nil {
+		return
+	}
+
+	seen := make(map[string]bool, len(coupons))
+	for _, coupon := range coupons {
+		if seen[coupon.Code] {
+			err = fmt.Errorf("%s: %w", coupon.Code, ErrCouponAlreadyUsed)
+			return
+		}
+		seen[coupon.Code] = true
+		if !coupon.Stackable && len(coupons) > 1 {
+			err = fmt.Errorf("%s: %w", coupon.Code, ErrCouponNotStackable)
+			return
+		}
+
+		var off Money
+		if off, err = coupon.Discount(items, subtotal, now); err != nil {
+			err = fmt.Errorf("%s: %w", coupon.Code, err)
+			return
+		}
+		if discount, err = discount.Add(off); err != nil {
+			return
+		}
+	}
+
+	if discount.Cmp(subtotal) > 0 {
+		discount = subtotal
+	}
+	total, err = subtotal.Sub(discount)
+	return
+}
+
+// SeedCoupons are the promotions InitStores installs
+func SeedCoupons() []Coupon {
+	return []Coupon{
+		{Code: "SAVE10", Kind: CouponPercent, Percent: 10, MinSpend: NewMoney(5000, BaseCurrency), PerUserLimit: 1},
+		{Code: "TAKE5", Kind: CouponFixed, Amount: NewMoney(500, BaseCurrency), PerUserLimit: 1, Stackable: true},
+		{Code: "MOUSE2FOR1", Kind: CouponBuyXGetY, ProductID: "2", Buy: 1, Get: 1, PerUserLimit: 2, Stackable: true},
+		{Code: "LAUNCH50", Kind: CouponPercent, Percent: 50, GlobalLimit: 1},
+		{Code: "SUMMER20", Kind: CouponPercent, Percent: 20, ExpiresAt: time.Date(2024, 9, 1, 0, 0, 0, 0, time.UTC)},
+	}
+}
+
+func (s *MemoryStore) GetCoupon(code string) (Coupon, bool) {
+	s.couponsMutex.RLock()
+	defer s.couponsMutex.RUnlock()
+	coupon, exists := s.coupons[code]
+	return coupon, exists
+}
+
+func (s *MemoryStore) ListCoupons() []Coupon {
+	s.couponsMutex.RLock()
+	defer s.couponsMutex.RUnlock()
+	coupons := make([]Coupon, 0, len(s.coupons))
+	for _, coupon := range s.coupons {
+		coupons = append(coupons, coupon)
+	}
+	return coupons
+}
+
+func (s *MemoryStore) SetCoupon(coupon Coupon) {
+	s.couponsMutex.Lock()
+	defer s.couponsMutex.Unlock()
+	s.coupons[coupon.Code] = coupon
+}
+
+// CouponUsage counts a coupon's redemptions in one shop, overall and by one
+// user
+func (s *MemoryStore) CouponUsage(shop, code, userID string) (total, byUser int) {
+	s.couponsMutex.RLock()
+	defer s.couponsMutex.RUnlock()
+	return s.usageLocked(shop, code, userID)
+}
+
+func (s *MemoryStore) usageLocked(shop, code, userID string) (total, byUser int) {
+	for _, redemption := range s.redemptions {
+		if redemption.Shop != shop || redemption.Code != code {
+			continue
+		}
+		total++
+		if redemption.UserID == userID {
+			byUser++
+		}
+	}
+	return total, byUser
+}
+
+// RecordRedemptions stores redemptions without checking any limits
+func (s *MemoryStore) RecordRedemptions(redemptions []Redemption) {
+	s.couponsMutex.Lock()
+	defer s.couponsMutex.Unlock()
+	s.redemptions = append(s.redemptions, redemptions...)
+}
+
+// RedeemCoupons records the use of every code on one of shop's orders, or
+// none of them if any would go over its global or per-user limit in that
+// shop. Checking and recording happen under one lock, so parallel checkouts
+// can't share the last use.
+func (s *MemoryStore) RedeemCoupons(shop string, codes []string, userID, orderID string, now time.Time) error {
+	s.couponsMutex.Lock()
+	defer s.couponsMutex.Unlock()
+
+	for _, code := range codes {
+		coupon, exists := s.coupons[code]
+		if !exists {
+			return fmt.Errorf("%s: %w", code, ErrCouponUnknown)
+		}
+		total, byUser := s.usageLocked(shop, code, userID)
+		if (coupon.GlobalLimit > 0 && total >= coupon.GlobalLimit) || (coupon.PerUserLimit > 0 && byUser >= coupon.PerUserLimit) {
+			return fmt.Errorf("%s: %w", code, ErrCouponLimit)
+		}
+	}
+
+	for _, code := range codes {
+		s.redemptions = append(s.redemptions, Redemption{Shop: shop, Code: code, UserID: userID, OrderID: orderID, At: now})
+	}
+	return nil
+}
+
+// restoreRedemptions puts back one order's redemptions when the FileStore
+// replays them. An order that already has redemptions is skipped, so a record
+// replayed on top of a snapshot that has it doesn't use the coupons up twice.
+func (s *MemoryStore) restoreRedemptions(redemptions []Redemption) {
+	s.couponsMutex.Lock()
+	defer s.couponsMutex.Unlock()
+
+	if len(redemptions) == 0 {
+		return
+	}
+	orderID := redemptions[0].OrderID
+	if slices.ContainsFunc(s.redemptions, func(r Redemption) bool { return r.OrderID == orderID }) {
+		return
+	}
+	s.redemptions = append(s.redemptions, redemptions...)
+}
+
+func (s *MemoryStore) allRedemptions() []Redemption {
+	s.couponsMutex.RLock()
+	defer s.couponsMutex.RUnlock()
+	return append([]Redemption(nil), s.redemptions...)
+}
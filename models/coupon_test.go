@@ -0,0 +1,163 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// mice is a cart line of n mice at $29.99
+func mice(n int) []CartItem {
+	return []CartItem{{ProductID: "2", Quantity: n, Price: NewMoney(2999, BaseCurrency)}}
+}
+
+func TestCouponDiscount(t *testing.T) {
+	now := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	keyboard := []CartItem{{ProductID: "3", Quantity: 1, Price: NewMoney(7999, BaseCurrency)}}
+	usd := func(n int64) Money { return NewMoney(n, BaseCurrency) }
+	tests := []struct {
+		name   string
+		coupon Coupon
+		items  []CartItem
+		want   int64
+		err    error
+	}{
+		{"percent rounds down", Coupon{Kind: CouponPercent, Percent: 10}, mice(1), 299, nil},
+		{"percent of everything", Coupon{Kind: CouponPercent, Percent: 100}, mice(2), 5998, nil},
+		{"fixed", Coupon{Kind: CouponFixed, Amount: usd(500)}, mice(1), 500, nil},
+		{"fixed never exceeds the cart", Coupon{Kind: CouponFixed, Amount: usd(5000)}, mice(1), 2999, nil},
+		{"min spend reached", Coupon{Kind: CouponFixed, Amount: usd(500), MinSpend: usd(5998)}, mice(2), 500, nil},
+		{"min spend missed", Coupon{Kind: CouponFixed, Amount: usd(500), MinSpend: usd(5999)}, mice(2), 0, ErrCouponMinSpend},
+		{"expires later", Coupon{Kind: CouponFixed, Amount: usd(500), ExpiresAt: now.Add(time.Second)}, mice(1), 500, nil},
+		{"expires now", Coupon{Kind: CouponFixed, Amount: usd(500), ExpiresAt: now}, mice(1), 0, ErrCouponExpired},
+		{"buy one get one", Coupon{Kind: CouponBuyXGetY, ProductID: "2", Buy: 1, Get: 1}, mice(3), 2999, nil},
+		{"buy one get one twice", Coupon{Kind: CouponBuyXGetY, ProductID: "2", Buy: 1, Get: 1}, mice(4), 5998, nil},
+		{"buy two get one short", Coupon{Kind: CouponBuyXGetY, ProductID: "2", Buy: 2, Get: 1}, mice(2), 0, ErrCouponNotApplicable},
+		{"buy one get one elsewhere", Coupon{Kind: CouponBuyXGetY, ProductID: "2", Buy: 1, Get: 1}, keyboard, 0, ErrCouponNotApplicable},
+		{"buy nothing get one", Coupon{Kind: CouponBuyXGetY, ProductID: "2", Buy: 0, Get: 1}, mice(4), 0, ErrCouponNotApplicable},
+		{"unknown kind", Coupon{Kind: "mystery"}, mice(1), 0, ErrCouponNotApplicable},
+	}
+	for _, tt := range tests {
+		subtotal, err := SumItems(tt.items)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := tt.coupon.Discount(tt.items, subtotal, now)
+		if !errors.Is(err, tt.err) || (err == nil && got != usd(tt.want)) {
+			t.Errorf("%s: Discount = %+v, %v; want %d, %v", tt.name, got, err, tt.want, tt.err)
+		}
+	}
+}
+
+func TestPriceCart(t *testing.T) {
+	now := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	usd := func(n int64) Money { return NewMoney(n, BaseCurrency) }
+	save10 := Coupon{Code: "SAVE10", Kind: CouponPercent, Percent: 10, MinSpend: usd(5000)}
+	take5 := Coupon{Code: "TAKE5", Kind: CouponFixed, Amount: usd(500), Stackable: true}
+	mouse2for1 := Coupon{Code: "MOUSE2FOR1", Kind: CouponBuyXGetY, ProductID: "2", Buy: 1, Get: 1, Stackable: true}
+	take100 := Coupon{Code: "TAKE100", Kind: CouponFixed, Amount: usd(10000), Stackable: true}
+	expired := Coupon{Code: "OLD", Kind: CouponFixed, Amount: usd(100), Stackable: true, ExpiresAt: now.Add(-time.Hour)}
+
+	tests := []struct {
+		name            string
+		coupons         []Coupon
+		discount, total int64
+		err             error
+	}{
+		{"no coupons", nil, 0, 5998, nil},
+		{"one non-stackable", []Coupon{save10}, 599, 5399, nil},
+		{"stackable together", []Coupon{take5, mouse2for1}, 3499, 2499, nil},
+		{"stacking order doesn't matter", []Coupon{mouse2for1, take5}, 3499, 2499, nil},
+		{"non-stackable with another", []Coupon{save10, take5}, 0, 0, ErrCouponNotStackable},
+		{"non-stackable second", []Coupon{take5, save10}, 0, 0, ErrCouponNotStackable},
+		{"same code twice", []Coupon{take5, take5}, 0, 0, ErrCouponAlreadyUsed},
+		{"stacked discounts stop at zero", []Coupon{take5, take100}, 5998, 0, nil},
+		{"one bad coupon spoils the cart", []Coupon{take5, expired}, 0, 0, ErrCouponExpired},
+	}
+	for _, tt := range tests {
+		subtotal, discount, total, err := PriceCart(mice(2), tt.coupons, now)
+		if !errors.Is(err, tt.err) {
+			t.Errorf("%s: error = %v, want %v", tt.name, err, tt.err)
+			continue
+		}
+		// With no coupons the discount is the zero Money, which has no currency
+		if err == nil && (subtotal != usd(5998) || discount.Amount != tt.discount || total != usd(tt.total)) {
+			t.Errorf("%s: PriceCart = %s - %s = %s, want $59.98 - %s = %s", tt.name, subtotal, discount, total, usd(tt.discount), usd(tt.total))
+		}
+	}
+}
+
+func TestRedeemCoupons(t *testing.T) {
+	now := time.Now()
+	store := NewMemoryStore()
+	InitStores(store)
+
+	steps := []struct {
+		name   string
+		shop   string
+		codes  []string
+		userID string
+		err    error
+	}{
+		{"first use", "secure", []string{"LAUNCH50"}, "alice", nil},
+		{"global limit", "secure", []string{"LAUNCH50"}, "bob", ErrCouponLimit},
+		{"other shop's ledger", "vulnerable", []string{"LAUNCH50"}, "bob", nil},
+		{"per-user first", "secure", []string{"TAKE5"}, "alice", nil},
+		{"per-user limit", "secure", []string{"TAKE5"}, "alice", ErrCouponLimit},
+		{"per-user limit is per user", "secure", []string{"TAKE5"}, "bob", nil},
+		{"all or nothing", "secure", []string{"MOUSE2FOR1", "TAKE5"}, "bob", ErrCouponLimit},
+		{"unknown code", "secure", []string{"FREE"}, "bob", ErrCouponUnknown},
+		{"per-user limit of two", "secure", []string{"MOUSE2FOR1"}, "bob", nil},
+		{"second of two", "secure", []string{"MOUSE2FOR1"}, "bob", nil},
+		{"third of two", "secure", []string{"MOUSE2FOR1"}, "bob", ErrCouponLimit},
+	}
+	for i, step := range steps {
+		err := store.RedeemCoupons(step.shop, step.codes, step.userID, fmt.Sprint("order-", i), now)
+		if !errors.Is(err, step.err) {
+			t.Errorf("%s: RedeemCoupons = %v, want %v", step.name, err, step.err)
+		}
+	}
+
+	usage := []struct {
+		shop, code, userID string
+		total, byUser      int
+	}{
+		{"secure", "LAUNCH50", "alice", 1, 1},
+		{"vulnerable", "LAUNCH50", "bob", 1, 1},
+		{"secure", "TAKE5", "bob", 2, 1},
+		{"secure", "MOUSE2FOR1", "bob", 2, 2},
+		{"vulnerable", "TAKE5", "alice", 0, 0},
+	}
+	for _, u := range usage {
+		if total, byUser := store.CouponUsage(u.shop, u.code, u.userID); total != u.total || byUser != u.byUser {
+			t.Errorf("CouponUsage(%s, %s, %s) = %d, %d; want %d, %d", u.shop, u.code, u.userID, total, byUser, u.total, u.byUser)
+		}
+	}
+}
+
+func TestRedeemCouponsConcurrently(t *testing.T) {
+	store := NewMemoryStore()
+	InitStores(store)
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	redeemed := 0
+	for i := range 50 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if store.RedeemCoupons("secure", []string{"LAUNCH50"}, fmt.Sprint("user-", i), fmt.Sprint("order-", i), time.Now()) == nil {
+				mu.Lock()
+				redeemed++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if total, _ := store.CouponUsage("secure", "LAUNCH50", ""); redeemed != 1 || total != 1 {
+		t.Errorf("%d checkouts redeemed LAUNCH50 and %d redemptions were recorded, want 1 of each", redeemed, total)
+	}
+}
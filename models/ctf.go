@@ -0,0 +1,157 @@
+package models
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+var (
+	ErrTeamNameTaken   = errors.New("team name already taken")
+	ErrInvalidTeamName = errors.New("team name must be 3-32 characters")
+)
+
+// Team is a group of players in capture-the-flag mode. Players join by
+// name and the team's password; flags are issued to and scored for teams.
+type Team struct {
+	ID           string
+	Name         string
+	PasswordHash string
+	CreatedAt    time.Time
+}
+
+// Solve records a team submitting the flag of a challenge
+type Solve struct {
+	TeamID    string
+	Challenge string
+	Points    int
+	At        time.Time
+}
+
+// TeamScore is a team's line on the leaderboard
+type TeamScore struct {
+	Team      Team
+	Points    int
+	Solves    int
+	LastSolve time.Time
+}
+
+// teamKey makes "Red Team" and "red team " the same team
+func teamKey(name string) string {
+	return strings.ToLower(strings.TrimSpace(name))
+}
+
+// NewTeam validates the name and password and returns a team with a hashed
+// password; it is not stored yet
+func NewTeam(name, password string) (Team, error) {
+	name = strings.TrimSpace(name)
+	if n := utf8.RuneCountInString(name); n < 3 || n > 32 {
+		return Team{}, ErrInvalidTeamName
+	}
+	if len(password) < MinPasswordLength {
+		return Team{}, ErrWeakPassword
+	}
+	hash, err := HashPassword(password)
+	if err != nil {
+		return Team{}, err
+	}
+	return Team{ID: GenerateID(), Name: name, PasswordHash: hash, CreatedAt: time.Now()}, nil
+}
+
+// Leaderboard ranks every team by points, breaking ties in favour of the
+// team that reached its score first
+func Leaderboard(teams []Team, solves []Solve) []TeamScore {
+	scores := make(map[string]*TeamScore, len(teams))
+	board := make([]TeamScore, 0, len(teams))
+	for _, team := range teams {
+		scores[team.ID] = &TeamScore{Team: team}
+	}
+	for _, solve := range solves {
+		score, ok := scores[solve.TeamID]
+		if !ok {
+			continue
+		}
+		score.Points += solve.Points
+		score.Solves++
+		if solve.At.After(score.LastSolve) {
+			score.LastSolve = solve.At
+		}
+	}
+	for _, score := range scores {
+		board = append(board, *score)
+	}
+
+	sort.Slice(board, func(i, j int) bool {
+		a, b := board[i], board[j]
+		if a.Points != b.Points {
+			return a.Points > b.Points
+		}
+		if !a.LastSolve.Equal(b.LastSolve) {
+			return a.LastSolve.Before(b.LastSolve)
+		}
+		return teamKey(a.Team.Name) < teamKey(b.Team.Name)
+	})
+	return board
+}
+
+// CreateTeam stores a new team unless its name is already taken
+func (s *MemoryStore) CreateTeam(team Team) error {
+	s.ctfMutex.Lock()
+	defer s.ctfMutex.Unlock()
+
+	key := teamKey(team.Name)
+	if _, taken := s.teamNames[key]; taken {
+		return ErrTeamNameTaken
+	}
+	s.teams[team.ID] = team
+	s.teamNames[key] = team.ID
+	return nil
+}
+
+func (s *MemoryStore) GetTeam(id string) (Team, bool) {
+	s.ctfMutex.RLock()
+	defer s.ctfMutex.RUnlock()
+	team, exists := s.teams[id]
+	return team, exists
+}
+
+func (s *MemoryStore) GetTeamByName(name string) (Team, bool) {
+	s.ctfMutex.RLock()
+	defer s.ctfMutex.RUnlock()
+	team, exists := s.teams[s.teamNames[teamKey(name)]]
+	return team, exists
+}
+
+func (s *MemoryStore) ListTeams() []Team {
+	s.ctfMutex.RLock()
+	defer s.ctfMutex.RUnlock()
+	teams := make([]Team, 0, len(s.teams))
+	for _, team := range s.teams {
+		teams = append(teams, team)
+	}
+	return teams
+}
+
+// RecordSolve stores a solve unless the team already solved the challenge,
+// and reports whether it did
+func (s *MemoryStore) RecordSolve(solve Solve) bool {
+	s.ctfMutex.Lock()
+	defer s.ctfMutex.Unlock()
+
+	for _, existing := range s.solves {
+		if existing.TeamID == solve.TeamID && existing.Challenge == solve.Challenge {
+			return false
+		}
+	}
+	s.solves = append(s.solves, solve)
+	return true
+}
+
+// ListSolves returns every solve in the order they were recorded
+func (s *MemoryStore) ListSolves() []Solve {
+	s.ctfMutex.RLock()
+	defer s.ctfMutex.RUnlock()
+	return append([]Solve(nil), s.solves...)
+}
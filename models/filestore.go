@@ -0,0 +1,580 @@
+package models
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	logFileName      = "wal.log"
+	snapshotFileName = "snapshot.json"
+
+	// Each log record is framed as a 4-byte length, a 4-byte CRC32 of the
+	// payload and the JSON payload itself
+	recordHeaderSize = 8
+
+	defaultSnapshotEvery = 500
+)
+
+const (
+	opSetOrder      = "set_order"
+	opSetCart       = "set_cart"
+	opClearCart     = "clear_cart"
+	opDeleteCarts   = "delete_carts"
+	opSetSession    = "set_session"
+	opDeleteSession = "delete_session"
+
+	// Stock movements are logged as the products they leave behind
+	opSetStock = "set_stock"
+
+	opClaimNonce = "claim_nonce"
+
+	opCreateUser = "create_user"
+
+	opRedeemCoupons = "redeem_coupons"
+
+	opCreateTeam  = "create_team"
+	opRecordSolve = "record_solve"
+
+	opChangeProduct = "change_product"
+)
+
+type logRecord struct {
+	Op        string       `json:"op"`
+	SessionID string       `json:"session_id,omitempty"`
+	Scenario  string       `json:"scenario,omitempty"`
+	Order     *Order       `json:"order,omitempty"`
+	Cart      *Cart        `json:"cart,omitempty"`
+	Session   *Session     `json:"session,omitempty"`
+	Nonce     string       `json:"nonce,omitempty"`
+	ExpiresAt time.Time    `json:"expires_at,omitempty"`
+	User      *User        `json:"user,omitempty"`
+	Redeemed  []Redemption `json:"redeemed,omitempty"`
+	Team      *Team        `json:"team,omitempty"`
+	Solve     *Solve       `json:"solve,omitempty"`
+	// A catalog edit carries the product as it was saved and its audit entry
+	Product       *Product       `json:"product,omitempty"`
+	ProductChange *ProductChange `json:"product_change,omitempty"`
+	Products      []Product      `json:"products,omitempty"`
+}
+
+type snapshot struct {
+	Products map[string]Product `json:"products"`
+	Orders   map[string]Order   `json:"orders"`
+	// Carts is keyed by session, then scenario
+	Carts    map[string]map[string]Cart `json:"carts"`
+	Sessions map[string]Session         `json:"sessions"`
+	Nonces   map[string]time.Time       `json:"nonces"`
+	Users    map[string]User            `json:"users"`
+	Redeemed []Redemption               `json:"redeemed"`
+	Teams    []Team                     `json:"teams"`
+	Solves   []Solve                    `json:"solves"`
+
+	ProductChanges []ProductChange `json:"product_changes"`
+}
+
+// FileStore is a MemoryStore whose order, cart, session, user, stock,
+// catalog, coupon redemption, team and solve mutations are appended to a
+// write-ahead log before being applied. The log is compacted into a snapshot
+// every SnapshotEvery records. The catalog and coupons themselves are seeded
+// by InitStores on every start, and admin catalog edits, stock movements and
+// redemptions are replayed on top of them.
+type FileStore struct {
+	*MemoryStore
+
+	// SnapshotEvery is the number of log records after which the log is
+	// compacted into a snapshot. Zero disables automatic snapshots.
+	SnapshotEvery int
+
+	dir     string
+	mu      sync.Mutex // serializes log appends with the in-memory apply
+	logFile *os.File
+	records int
+}
+
+// OpenFileStore opens (or creates) the log in dir. Call Recover after
+// InitStores to load the persisted state.
+func OpenFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(filepath.Join(dir, logFileName), os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return &FileStore{
+		MemoryStore:   NewMemoryStore(),
+		SnapshotEvery: defaultSnapshotEvery,
+		dir:           dir,
+		logFile:       f,
+	}, nil
+}
+
+// Recover loads the latest snapshot and replays the log on top of it. A torn
+// or corrupt record at the tail of the log (from a crash mid-write) is
+// discarded and the log is truncated back to the last good record.
+func (s *FileStore) Recover() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.loadSnapshot(); err != nil {
+		return err
+	}
+
+	if _, err := s.logFile.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	reader := bufio.NewReader(s.logFile)
+	var offset int64
+	s.records = 0
+	for {
+		rec, n, err := readRecord(reader)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Printf("filestore: discarding log tail at offset %d: %v", offset, err)
+			if err := s.logFile.Truncate(offset); err != nil {
+				return err
+			}
+			if err := s.logFile.Sync(); err != nil {
+				return err
+			}
+			break
+		}
+		s.apply(rec)
+		offset += n
+		s.records++
+	}
+	return nil
+}
+
+// Snapshot writes the current orders, carts and sessions to disk and empties
+// the log.
+func (s *FileStore) Snapshot() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.snapshotLocked()
+}
+
+func (s *FileStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.logFile.Close()
+}
+
+func (s *FileStore) SetOrder(order Order) {
+	s.write(logRecord{Op: opSetOrder, Order: &order})
+}
+
+func (s *FileStore) SetCart(sessionID, scenario string, cart Cart) {
+	s.write(logRecord{Op: opSetCart, SessionID: sessionID, Scenario: scenario, Cart: &cart})
+}
+
+func (s *FileStore) ClearCart(sessionID, scenario string) {
+	s.write(logRecord{Op: opClearCart, SessionID: sessionID, Scenario: scenario})
+}
+
+func (s *FileStore) DeleteCarts(sessionID string) {
+	s.write(logRecord{Op: opDeleteCarts, SessionID: sessionID})
+}
+
+func (s *FileStore) SetSession(session Session) {
+	s.write(logRecord{Op: opSetSession, Session: &session})
+}
+
+func (s *FileStore) DeleteSession(sessionID string) {
+	s.write(logRecord{Op: opDeleteSession, SessionID: sessionID})
+}
+
+// ReserveStock can fail, so it is checked and applied in memory first and
+// only a successful reservation is logged, as the products it changed
+func (s *FileStore) ReserveStock(items []CartItem) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	products, err := s.MemoryStore.reserveStock(items)
+	if err != nil {
+		return err
+	}
+	s.appendLocked(logRecord{Op: opSetStock, Products: products})
+	s.maybeSnapshotLocked()
+	return nil
+}
+
+// UpdateOrder is applied in memory first; only a successful update is
+// logged, as a plain set_order record
+func (s *FileStore) UpdateOrder(id string, update func(*Order) error) (Order, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	order, err := s.MemoryStore.UpdateOrder(id, update)
+	if err != nil {
+		return order, err
+	}
+	s.appendLocked(logRecord{Op: opSetOrder, Order: &order})
+	s.maybeSnapshotLocked()
+	return order, nil
+}
+
+func (s *FileStore) TransitionOrder(id string, to OrderStatus, actor string) (Order, error) {
+	return s.UpdateOrder(id, func(o *Order) error {
+		return o.Transition(to, actor)
+	})
+}
+
+// ClaimNonce is logged so a replayed webhook is still recognised after a
+// restart
+func (s *FileStore) ClaimNonce(nonce string, expiresAt time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.MemoryStore.ClaimNonce(nonce, expiresAt) {
+		return false
+	}
+	s.appendLocked(logRecord{Op: opClaimNonce, Nonce: nonce, ExpiresAt: expiresAt})
+	s.maybeSnapshotLocked()
+	return true
+}
+
+// CreateUser can fail on a taken username, so only a successful insert is
+// logged
+func (s *FileStore) CreateUser(user User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.MemoryStore.CreateUser(user); err != nil {
+		return err
+	}
+	s.appendLocked(logRecord{Op: opCreateUser, User: &user})
+	s.maybeSnapshotLocked()
+	return nil
+}
+
+// CreateTeam can fail on a taken name, so only a successful insert is
+// logged
+func (s *FileStore) CreateTeam(team Team) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.MemoryStore.CreateTeam(team); err != nil {
+		return err
+	}
+	s.appendLocked(logRecord{Op: opCreateTeam, Team: &team})
+	s.maybeSnapshotLocked()
+	return nil
+}
+
+// RecordSolve logs only first solves; a repeated submission changes nothing
+func (s *FileStore) RecordSolve(solve Solve) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.MemoryStore.RecordSolve(solve) {
+		return false
+	}
+	s.appendLocked(logRecord{Op: opRecordSolve, Solve: &solve})
+	s.maybeSnapshotLocked()
+	return true
+}
+
+// CreateProduct and UpdateProduct can fail validation, so only a saved
+// change is logged, together with its audit entry
+func (s *FileStore) CreateProduct(product Product, actor string) (Product, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	product, change, err := s.MemoryStore.createProduct(product, actor)
+	if err != nil {
+		return product, err
+	}
+	s.appendLocked(logRecord{Op: opChangeProduct, Product: &product, ProductChange: &change})
+	s.maybeSnapshotLocked()
+	return product, nil
+}
+
+func (s *FileStore) UpdateProduct(id, actor string, update func(*Product) error) (Product, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	product, change, err := s.MemoryStore.updateProduct(id, actor, update)
+	if err != nil {
+		return product, err
+	}
+	s.appendLocked(logRecord{Op: opChangeProduct, Product: &product, ProductChange: &change})
+	s.maybeSnapshotLocked()
+	return product, nil
+}
+
+func (s *FileStore) RecordRedemptions(redemptions []Redemption) {
+	s.write(logRecord{Op: opRedeemCoupons, Redeemed: redemptions})
+}
+
+// RedeemCoupons can fail on a limit, so only a successful redemption is
+// logged
+func (s *FileStore) RedeemCoupons(shop string, codes []string, userID, orderID string, now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.MemoryStore.RedeemCoupons(shop, codes, userID, orderID, now); err != nil {
+		return err
+	}
+	redeemed := make([]Redemption, len(codes))
+	for i, code := range codes {
+		redeemed[i] = Redemption{Shop: shop, Code: code, UserID: userID, OrderID: orderID, At: now}
+	}
+	s.appendLocked(logRecord{Op: opRedeemCoupons, Redeemed: redeemed})
+	s.maybeSnapshotLocked()
+	return nil
+}
+
+func (s *FileStore) ReleaseStock(items []CartItem) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	changed, err := s.MemoryStore.releaseStock(items)
+	if err != nil {
+		return err
+	}
+	s.appendLocked(logRecord{Op: opSetStock, Products: changed})
+	s.maybeSnapshotLocked()
+	return nil
+}
+
+func (s *FileStore) CommitStock(items []CartItem) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	changed, err := s.MemoryStore.commitStock(items)
+	if err != nil {
+		return err
+	}
+	s.appendLocked(logRecord{Op: opSetStock, Products: changed})
+	s.maybeSnapshotLocked()
+	return nil
+}
+
+func (s *FileStore) SetStock(id string, stock int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	product, err := s.MemoryStore.setStock(id, stock)
+	if err != nil {
+		return err
+	}
+	s.appendLocked(logRecord{Op: opSetStock, Products: []Product{product}})
+	s.maybeSnapshotLocked()
+	return nil
+}
+
+// write appends rec to the log, syncs it and only then applies it in memory.
+// The Store interface has no error returns, so a failed append is logged and
+// the mutation is still applied to keep the running process consistent.
+func (s *FileStore) write(rec logRecord) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.appendLocked(rec)
+	s.apply(rec)
+	s.maybeSnapshotLocked()
+}
+
+func (s *FileStore) appendLocked(rec logRecord) {
+	if err := s.appendRecord(rec); err != nil {
+		log.Printf("filestore: append %s: %v", rec.Op, err)
+	}
+	s.records++
+}
+
+// maybeSnapshotLocked must run after the latest record has been applied, or
+// the snapshot would miss it while the log that held it is truncated
+func (s *FileStore) maybeSnapshotLocked() {
+	if s.SnapshotEvery > 0 && s.records >= s.SnapshotEvery {
+		if err := s.snapshotLocked(); err != nil {
+			log.Printf("filestore: snapshot: %v", err)
+		}
+	}
+}
+
+func (s *FileStore) apply(rec logRecord) {
+	switch rec.Op {
+	case opSetOrder:
+		s.MemoryStore.SetOrder(*rec.Order)
+	case opSetCart:
+		s.MemoryStore.SetCart(rec.SessionID, rec.Scenario, *rec.Cart)
+	case opClearCart:
+		s.MemoryStore.ClearCart(rec.SessionID, rec.Scenario)
+	case opDeleteCarts:
+		s.MemoryStore.DeleteCarts(rec.SessionID)
+	case opSetSession:
+		s.MemoryStore.SetSession(*rec.Session)
+	case opDeleteSession:
+		s.MemoryStore.DeleteSession(rec.SessionID)
+	case opSetStock:
+		s.MemoryStore.restoreStock(rec.Products)
+	case opClaimNonce:
+		s.MemoryStore.ClaimNonce(rec.Nonce, rec.ExpiresAt)
+	case opCreateUser:
+		s.MemoryStore.CreateUser(*rec.User)
+	case opRedeemCoupons:
+		s.MemoryStore.restoreRedemptions(rec.Redeemed)
+	case opCreateTeam:
+		s.MemoryStore.CreateTeam(*rec.Team)
+	case opRecordSolve:
+		s.MemoryStore.RecordSolve(*rec.Solve)
+	case opChangeProduct:
+		s.MemoryStore.restoreProduct(*rec.Product, *rec.ProductChange)
+	}
+}
+
+func (s *FileStore) appendRecord(rec logRecord) error {
+	payload, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	buf := make([]byte, recordHeaderSize+len(payload))
+	binary.BigEndian.PutUint32(buf[0:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(buf[4:8], crc32.ChecksumIEEE(payload))
+	copy(buf[recordHeaderSize:], payload)
+
+	if _, err := s.logFile.Write(buf); err != nil {
+		return err
+	}
+	return s.logFile.Sync()
+}
+
+// readRecord returns io.EOF only on a clean record boundary; anything else
+// short of a complete, checksummed record is reported as an error.
+func readRecord(r io.Reader) (logRecord, int64, error) {
+	var rec logRecord
+	header := make([]byte, recordHeaderSize)
+	if n, err := io.ReadFull(r, header); err != nil {
+		if err == io.EOF && n == 0 {
+			return rec, 0, io.EOF
+		}
+		return rec, 0, fmt.Errorf("short header: %w", err)
+	}
+	length := binary.BigEndian.Uint32(header[0:4])
+	sum := binary.BigEndian.Uint32(header[4:8])
+
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return rec, 0, fmt.Errorf("short payload: %w", err)
+	}
+	if crc32.ChecksumIEEE(payload) != sum {
+		return rec, 0, errors.New("checksum mismatch")
+	}
+	if err := json.Unmarshal(payload, &rec); err != nil {
+		return rec, 0, err
+	}
+	return rec, int64(recordHeaderSize) + int64(length), nil
+}
+
+func (s *FileStore) loadSnapshot() error {
+	data, err := os.ReadFile(filepath.Join(s.dir, snapshotFileName))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var snap snapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return fmt.Errorf("filestore: corrupt snapshot: %w", err)
+	}
+	for _, product := range snap.Products {
+		s.MemoryStore.SetProduct(product)
+	}
+	for _, order := range snap.Orders {
+		s.MemoryStore.SetOrder(order)
+	}
+	for sessionID, scenarios := range snap.Carts {
+		for scenario, cart := range scenarios {
+			s.MemoryStore.SetCart(sessionID, scenario, cart)
+		}
+	}
+	for _, session := range snap.Sessions {
+		s.MemoryStore.SetSession(session)
+	}
+	for nonce, expiresAt := range snap.Nonces {
+		s.MemoryStore.ClaimNonce(nonce, expiresAt)
+	}
+	for _, user := range snap.Users {
+		s.MemoryStore.CreateUser(user)
+	}
+	s.MemoryStore.RecordRedemptions(snap.Redeemed)
+	for _, team := range snap.Teams {
+		s.MemoryStore.CreateTeam(team)
+	}
+	for _, solve := range snap.Solves {
+		s.MemoryStore.RecordSolve(solve)
+	}
+	s.MemoryStore.productChanges = snap.ProductChanges
+	return nil
+}
+
+// snapshotLocked writes the snapshot atomically (temp file + rename) before
+// truncating the log. A crash between the two leaves records that are already
+// in the snapshot, and Recover replays them on top of it. That is harmless:
+// records overwrite or delete whole values - stock movements are logged as
+// the products they leave behind - and replayed coupon redemptions, solves
+// and catalog audit entries are skipped when the snapshot already has them.
+func (s *FileStore) snapshotLocked() error {
+	snap := snapshot{
+		Products: s.MemoryStore.ListProducts(),
+		Orders:   s.MemoryStore.allOrders(),
+		Carts:    s.MemoryStore.allCarts(),
+		Sessions: s.MemoryStore.ListSessions(),
+		Nonces:   s.MemoryStore.allNonces(),
+		Users:    s.MemoryStore.allUsers(),
+		Redeemed: s.MemoryStore.allRedemptions(),
+		Teams:    s.MemoryStore.ListTeams(),
+		Solves:   s.MemoryStore.ListSolves(),
+
+		ProductChanges: s.MemoryStore.ListProductChanges(),
+	}
+	data, err := json.Marshal(snap)
+	if err != nil {
+		return err
+	}
+
+	tmp := filepath.Join(s.dir, snapshotFileName+".tmp")
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, filepath.Join(s.dir, snapshotFileName)); err != nil {
+		return err
+	}
+	if dir, err := os.Open(s.dir); err == nil {
+		dir.Sync()
+		dir.Close()
+	}
+
+	if err := s.logFile.Truncate(0); err != nil {
+		return err
+	}
+	s.records = 0
+	return s.logFile.Sync()
+}
@@ -0,0 +1,276 @@
+package models
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func openRecovered(t *testing.T, dir string) *FileStore {
+	t.Helper()
+	store, err := OpenFileStore(dir)
+	if err != nil {
+		t.Fatalf("OpenFileStore: %v", err)
+	}
+	InitStores(store)
+	if err := store.Recover(); err != nil {
+		t.Fatalf("Recover: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+func testOrder(id string) Order {
+	return Order{
+		ID:        id,
+		UserID:    "user-" + id,
+		Items:     []CartItem{{ProductID: "1", Quantity: 1, Price: NewMoney(99999, BaseCurrency)}},
+		Total:     NewMoney(99999, BaseCurrency),
+		Status:    StatusPending,
+		Timestamp: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+	}
+}
+
+func TestFileStoreReplaysLog(t *testing.T) {
+	dir := t.TempDir()
+
+	store := openRecovered(t, dir)
+	store.SetSession(Session{ID: "sess", UserID: "user", Currency: "EUR"})
+	store.SetCart("sess", "shop", Cart{Items: []CartItem{{ProductID: "2", Quantity: 3, Price: NewMoney(2999, BaseCurrency)}}, Total: NewMoney(8997, BaseCurrency)})
+	store.SetOrder(testOrder("a"))
+	store.SetCart("other", "shop", Cart{Items: []CartItem{{ProductID: "3", Quantity: 1, Price: NewMoney(7999, BaseCurrency)}}, Total: NewMoney(7999, BaseCurrency)})
+	store.ClearCart("other", "shop")
+	store.CreateUser(User{ID: "u1", Username: "alice", PasswordHash: "hash"})
+	store.CreateTeam(Team{ID: "t1", Name: "Red Team", PasswordHash: "hash"})
+	store.RecordSolve(Solve{TeamID: "t1", Challenge: "price", Points: 100})
+	store.CreateProduct(Product{Name: "Webcam", Price: NewMoney(4999, BaseCurrency), Stock: 8}, "admin")
+	store.UpdateProduct("1", "admin", func(p *Product) error {
+		p.Price = NewMoney(89999, BaseCurrency)
+		return nil
+	})
+	store.UpdateProduct("2", "admin", func(p *Product) error {
+		p.Archived = true
+		return nil
+	})
+	store.SetStock("4", 7)
+	store.Close()
+
+	reopened := openRecovered(t, dir)
+	if session, ok := reopened.GetSession("sess"); !ok || session.UserID != "user" || session.Currency != "EUR" {
+		t.Errorf("session = %+v, %v", session, ok)
+	}
+	if cart := reopened.GetCart("sess", "shop"); len(cart.Items) != 1 || cart.Total != NewMoney(8997, BaseCurrency) {
+		t.Errorf("cart = %+v", cart)
+	}
+	if cart := reopened.GetCart("sess", "other-shop"); len(cart.Items) != 0 {
+		t.Errorf("cart leaked into another scenario: %+v", cart)
+	}
+	if cart := reopened.GetCart("other", "shop"); len(cart.Items) != 0 {
+		t.Errorf("cleared cart came back: %+v", cart)
+	}
+	order, ok := reopened.GetOrder("a")
+	if !ok || order.Total != NewMoney(99999, BaseCurrency) || !order.Timestamp.Equal(testOrder("a").Timestamp) {
+		t.Errorf("order = %+v, %v", order, ok)
+	}
+	if _, ok := reopened.GetProduct("1"); !ok {
+		t.Error("products should still be seeded by InitStores")
+	}
+	if user, ok := reopened.GetUserByUsername("alice"); !ok || user.ID != "u1" {
+		t.Errorf("user = %+v, %v", user, ok)
+	}
+	if err := reopened.CreateUser(User{ID: "u2", Username: "alice"}); err != ErrUsernameTaken {
+		t.Errorf("duplicate username after replay: err = %v", err)
+	}
+	if team, ok := reopened.GetTeamByName("red team"); !ok || team.ID != "t1" {
+		t.Errorf("team = %+v, %v", team, ok)
+	}
+	if solves := reopened.ListSolves(); len(solves) != 1 || solves[0].Points != 100 {
+		t.Errorf("solves = %+v", solves)
+	}
+	if reopened.RecordSolve(Solve{TeamID: "t1", Challenge: "price", Points: 100}) {
+		t.Error("the same flag scored twice after replay")
+	}
+	if product, ok := reopened.GetProduct("5"); !ok || product.Name != "Webcam" {
+		t.Errorf("created product = %+v, %v", product, ok)
+	}
+	if product, _ := reopened.GetProduct("1"); product.Price != NewMoney(89999, BaseCurrency) {
+		t.Errorf("repriced laptop came back at %s", product.Price)
+	}
+	if product, _ := reopened.GetProduct("2"); !product.Archived {
+		t.Error("archived mouse came back on sale")
+	}
+	if product, _ := reopened.GetProduct("4"); product.Stock != 7 {
+		t.Errorf("monitor stock = %d, want 7", product.Stock)
+	}
+	changes := reopened.ListProductChanges()
+	if len(changes) != 3 || changes[1].OldPrice != NewMoney(99999, BaseCurrency) || changes[1].Actor != "admin" || changes[2].Action != ProductArchived {
+		t.Errorf("product changes = %+v", changes)
+	}
+}
+
+func TestFileStoreTruncatedRecord(t *testing.T) {
+	dir := t.TempDir()
+	logPath := filepath.Join(dir, logFileName)
+
+	store := openRecovered(t, dir)
+	store.SetOrder(testOrder("a"))
+	store.SetOrder(testOrder("b"))
+	info, err := os.Stat(logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	goodSize := info.Size()
+	store.SetOrder(testOrder("c"))
+	store.Close()
+
+	full, err := os.ReadFile(logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Cut the last record at every possible byte: the header, the payload
+	// and the final byte must all be handled as a torn write.
+	for cut := goodSize; cut < int64(len(full)); cut++ {
+		if err := os.WriteFile(logPath, full[:cut], 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		recovered := openRecovered(t, dir)
+		if _, ok := recovered.GetOrder("a"); !ok {
+			t.Fatalf("cut %d: lost order a", cut)
+		}
+		if _, ok := recovered.GetOrder("b"); !ok {
+			t.Fatalf("cut %d: lost order b", cut)
+		}
+		if _, ok := recovered.GetOrder("c"); ok {
+			t.Fatalf("cut %d: torn order c was applied", cut)
+		}
+		info, err := os.Stat(logPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Size() != goodSize {
+			t.Fatalf("cut %d: log not truncated to last good record: size %d, want %d", cut, info.Size(), goodSize)
+		}
+
+		// Appends after recovery must land on a clean boundary.
+		recovered.SetOrder(testOrder("d"))
+		recovered.Close()
+		again := openRecovered(t, dir)
+		if _, ok := again.GetOrder("d"); !ok {
+			t.Fatalf("cut %d: order written after recovery was lost", cut)
+		}
+		again.Close()
+	}
+}
+
+func TestFileStoreCorruptRecord(t *testing.T) {
+	dir := t.TempDir()
+	logPath := filepath.Join(dir, logFileName)
+
+	store := openRecovered(t, dir)
+	store.SetOrder(testOrder("a"))
+	store.SetOrder(testOrder("b"))
+	store.Close()
+
+	data, err := os.ReadFile(logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[len(data)-2] ^= 0xff
+	if err := os.WriteFile(logPath, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	recovered := openRecovered(t, dir)
+	if _, ok := recovered.GetOrder("a"); !ok {
+		t.Error("lost order a")
+	}
+	if _, ok := recovered.GetOrder("b"); ok {
+		t.Error("record with bad checksum was applied")
+	}
+}
+
+func TestFileStoreSnapshot(t *testing.T) {
+	dir := t.TempDir()
+
+	store := openRecovered(t, dir)
+	store.SnapshotEvery = 3
+	store.SetSession(Session{ID: "sess", UserID: "user", Currency: "EUR"})
+	store.CreateTeam(Team{ID: "t1", Name: "Red Team"})
+	store.SetOrder(testOrder("a")) // triggers the snapshot
+	store.SetOrder(testOrder("b"))
+	store.RecordSolve(Solve{TeamID: "t1", Challenge: "price", Points: 100})
+	store.Close()
+
+	if _, err := os.Stat(filepath.Join(dir, snapshotFileName)); err != nil {
+		t.Fatalf("snapshot not written: %v", err)
+	}
+
+	reopened := openRecovered(t, dir)
+	for _, id := range []string{"a", "b"} {
+		if _, ok := reopened.GetOrder(id); !ok {
+			t.Errorf("lost order %s", id)
+		}
+	}
+	if _, ok := reopened.GetSession("sess"); !ok {
+		t.Error("lost session")
+	}
+	if _, ok := reopened.GetTeam("t1"); !ok || len(reopened.ListSolves()) != 1 {
+		t.Error("lost team or solve")
+	}
+	if reopened.records != 2 {
+		t.Errorf("log holds %d records after compaction, want 2", reopened.records)
+	}
+}
+
+// A crash after the snapshot is renamed into place but before the log is
+// truncated leaves every record in both; recovering must not apply them twice
+func TestFileStoreReplayOverSnapshotIsIdempotent(t *testing.T) {
+	dir := t.TempDir()
+	logPath := filepath.Join(dir, logFileName)
+
+	store := openRecovered(t, dir)
+	store.SnapshotEvery = 0
+	items := []CartItem{{ProductID: "1", Quantity: 2}}
+	store.ReserveStock(items)
+	store.CommitStock(items)
+	store.ReserveStock([]CartItem{{ProductID: "2", Quantity: 3}})
+	store.ReleaseStock([]CartItem{{ProductID: "2", Quantity: 1}})
+	store.RedeemCoupons("secure", []string{"TAKE5"}, "user", "order-1", time.Now())
+	store.RecordRedemptions([]Redemption{{Shop: "vulnerable", Code: "LAUNCH50", UserID: "user", OrderID: "order-2"}, {Shop: "vulnerable", Code: "LAUNCH50", UserID: "user", OrderID: "order-2"}})
+	store.UpdateProduct("3", "admin", func(p *Product) error {
+		p.Price = NewMoney(6999, BaseCurrency)
+		return nil
+	})
+
+	wal, err := os.ReadFile(logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Snapshot(); err != nil {
+		t.Fatal(err)
+	}
+	store.Close()
+	if err := os.WriteFile(logPath, wal, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	recovered := openRecovered(t, dir)
+	if laptop, _ := recovered.GetProduct("1"); laptop.Stock != 3 || laptop.Reserved != 0 {
+		t.Errorf("laptop stock %d reserved %d, want 3 and 0", laptop.Stock, laptop.Reserved)
+	}
+	if mouse, _ := recovered.GetProduct("2"); mouse.Stock != 50 || mouse.Reserved != 2 {
+		t.Errorf("mouse stock %d reserved %d, want 50 and 2", mouse.Stock, mouse.Reserved)
+	}
+	if total, _ := recovered.CouponUsage("secure", "TAKE5", "user"); total != 1 {
+		t.Errorf("TAKE5 redeemed %d times, want 1", total)
+	}
+	if total, _ := recovered.CouponUsage("vulnerable", "LAUNCH50", "user"); total != 2 {
+		t.Errorf("LAUNCH50 redeemed %d times, want the 2 the order recorded", total)
+	}
+	if changes := recovered.ListProductChanges(); len(changes) != 1 {
+		t.Errorf("audit log has %d entries, want 1", len(changes))
+	}
+}
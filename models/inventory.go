@@ -0,0 +1,137 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrOutOfStock  = errors.New("out of stock")
+	ErrNotReserved = errors.New("more stock released than is reserved")
+)
+
+// Available is the stock that is neither sold nor held by a pending order
+func (p Product) Available() int {
+	return p.Stock - p.Reserved
+}
+
+// quantities sums the requested quantity per product so a cart holding the
+// same product on several lines is checked against stock once
+func quantities(items []CartItem) map[string]int {
+	wanted := make(map[string]int)
+	for _, item := range items {
+		wanted[item.ProductID] += item.Quantity
+	}
+	return wanted
+}
+
+// ReserveStock holds stock for every item or for none of them
+func (s *MemoryStore) ReserveStock(items []CartItem) error {
+	_, err := s.reserveStock(items)
+	return err
+}
+
+// reserveStock, releaseStock and commitStock return the products they
+// changed, as they are afterwards, for the FileStore to log
+func (s *MemoryStore) reserveStock(items []CartItem) ([]Product, error) {
+	s.productsMutex.Lock()
+	defer s.productsMutex.Unlock()
+
+	wanted := quantities(items)
+	for id, quantity := range wanted {
+		product, exists := s.products[id]
+		if !exists {
+			return nil, fmt.Errorf("product %s: %w", id, ErrOutOfStock)
+		}
+		if product.Archived {
+			return nil, fmt.Errorf("%s is no longer sold: %w", product.Name, ErrOutOfStock)
+		}
+		if quantity < 1 || quantity > product.Available() {
+			return nil, fmt.Errorf("%s: %w", product.Name, ErrOutOfStock)
+		}
+	}
+	var changed []Product
+	for id, quantity := range wanted {
+		product := s.products[id]
+		product.Reserved += quantity
+		s.products[id] = product
+		changed = append(changed, product)
+	}
+	return changed, nil
+}
+
+// ReleaseStock returns reserved stock after a cancelled or expired order
+func (s *MemoryStore) ReleaseStock(items []CartItem) error {
+	_, err := s.releaseStock(items)
+	return err
+}
+
+func (s *MemoryStore) releaseStock(items []CartItem) ([]Product, error) {
+	return s.unreserve(items, false)
+}
+
+// CommitStock turns a reservation into a sale
+func (s *MemoryStore) CommitStock(items []CartItem) error {
+	_, err := s.commitStock(items)
+	return err
+}
+
+func (s *MemoryStore) commitStock(items []CartItem) ([]Product, error) {
+	return s.unreserve(items, true)
+}
+
+// unreserve takes items off their products' reservations, and off their
+// stock too if they were sold. Taking off more than is reserved means the
+// caller never held these items, so nothing is changed and it's reported.
+func (s *MemoryStore) unreserve(items []CartItem, sold bool) ([]Product, error) {
+	s.productsMutex.Lock()
+	defer s.productsMutex.Unlock()
+
+	wanted := quantities(items)
+	for id, quantity := range wanted {
+		product, exists := s.products[id]
+		if !exists || quantity < 0 || quantity > product.Reserved {
+			return nil, fmt.Errorf("product %s: %w", id, ErrNotReserved)
+		}
+	}
+	var changed []Product
+	for id, quantity := range wanted {
+		product := s.products[id]
+		product.Reserved -= quantity
+		if sold {
+			product.Stock -= quantity
+		}
+		s.products[id] = product
+		changed = append(changed, product)
+	}
+	return changed, nil
+}
+
+// SetStock overwrites a product's stock count, leaving its reservations and
+// catalog details as they are
+func (s *MemoryStore) SetStock(id string, stock int) error {
+	_, err := s.setStock(id, stock)
+	return err
+}
+
+func (s *MemoryStore) setStock(id string, stock int) (Product, error) {
+	s.productsMutex.Lock()
+	defer s.productsMutex.Unlock()
+
+	product, exists := s.products[id]
+	if !exists {
+		return Product{}, ErrProductNotFound
+	}
+	product.Stock = stock
+	s.products[id] = product
+	return product, nil
+}
+
+// restoreStock puts back products a logged stock movement left behind
+func (s *MemoryStore) restoreStock(products []Product) {
+	s.productsMutex.Lock()
+	defer s.productsMutex.Unlock()
+	for _, product := range products {
+		s.products[product.ID] = product
+	}
+}
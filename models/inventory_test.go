@@ -0,0 +1,40 @@
+package models
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestStockMovements(t *testing.T) {
+	store := NewMemoryStore()
+	InitStores(store)
+	laptops := func(n int) []CartItem { return []CartItem{{ProductID: "1", Quantity: n}} }
+
+	steps := []struct {
+		name     string
+		move     func() error
+		err      error
+		stock    int
+		reserved int
+	}{
+		{"reserve", func() error { return store.ReserveStock(laptops(3)) }, nil, 5, 3},
+		{"reserve past what's available", func() error { return store.ReserveStock(laptops(3)) }, ErrOutOfStock, 5, 3},
+		{"release more than is reserved", func() error { return store.ReleaseStock(laptops(5)) }, ErrNotReserved, 5, 3},
+		{"commit more than is reserved", func() error { return store.CommitStock(laptops(4)) }, ErrNotReserved, 5, 3},
+		{"release an unknown product", func() error { return store.ReleaseStock([]CartItem{{ProductID: "9", Quantity: 1}}) }, ErrNotReserved, 5, 3},
+		{"one bad line spoils the release", func() error {
+			return store.ReleaseStock(append(laptops(1), CartItem{ProductID: "2", Quantity: 1}))
+		}, ErrNotReserved, 5, 3},
+		{"commit", func() error { return store.CommitStock(laptops(2)) }, nil, 3, 1},
+		{"release", func() error { return store.ReleaseStock(laptops(1)) }, nil, 3, 0},
+		{"release again", func() error { return store.ReleaseStock(laptops(1)) }, ErrNotReserved, 3, 0},
+	}
+	for _, step := range steps {
+		if err := step.move(); !errors.Is(err, step.err) {
+			t.Errorf("%s: %v, want %v", step.name, err, step.err)
+		}
+		if laptop, _ := store.GetProduct("1"); laptop.Stock != step.stock || laptop.Reserved != step.reserved {
+			t.Errorf("%s: laptop stock %d reserved %d, want %d and %d", step.name, laptop.Stock, laptop.Reserved, step.stock, step.reserved)
+		}
+	}
+}
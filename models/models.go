@@ -3,115 +3,90 @@ package models
 import (
 	"crypto/rand"
 	"encoding/hex"
-	"sync"
 	"time"
 )
 
 type Product struct {
-	ID    string
-	Name  string
-	Price float64
+	ID       string
+	Name     string
+	Price    Money
+	Stock    int // units on hand, including reserved ones
+	Reserved int // units held by pending orders
+	// Archived products are kept for the orders that reference them but
+	// are no longer sold
+	Archived bool
 }
 
 type CartItem struct {
 	ProductID string
 	Quantity  int
-	Price     float64 // This will be manipulated in vulnerable version
+	Price     Money // This will be manipulated in vulnerable version
 }
 
 type Order struct {
 	ID        string
+	Shop      string // the scenario that placed it, e.g. "vulnerable-order"
 	UserID    string
 	Items     []CartItem
-	Total     float64
-	Status    string
-	Timestamp time.Time
+	Total     Money // in the currency the customer was charged
+	BaseTotal Money // Total before conversion, in BaseCurrency
+	// ExchangeRate is the BaseCurrency -> Total.Currency rate the order was
+	// charged at, as an exact decimal string
+	ExchangeRate string
+	Status       OrderStatus
+	History      []StatusChange
+	Timestamp    time.Time
+	// PaymentID is the gateway charge that pays for the order
+	PaymentID string
+	// ReservedUntil is when a pending order's stock reservation lapses; zero
+	// for orders that never reserved stock
+	ReservedUntil time.Time
+	// Coupons redeemed on the order and what they took off; Total is
+	// already net of Discount
+	Coupons  []string
+	Discount Money
 }
 
 type Cart struct {
 	Items []CartItem
-	Total float64
+	Total Money
+	// Coupons applied in the coupon shops; Total is net of Discount
+	Coupons  []string
+	Discount Money
 }
 
-// Global stores with mutex for concurrent access
-var (
-	Products      = make(map[string]Product)
-	Orders        = make(map[string]Order)
-	Carts         = make(map[string]Cart)   // session_id -> cart
-	Sessions      = make(map[string]string) // session_id -> user_id
-	ProductsMutex = sync.RWMutex{}
-	OrdersMutex   = sync.RWMutex{}
-	CartsMutex    = sync.RWMutex{}
-	SessionsMutex = sync.RWMutex{}
-)
+type Session struct {
+	ID       string
+	UserID   string
+	Currency string // display currency chosen by the visitor
+	Username string // name the visitor signed in as, empty while anonymous
 
-func InitStores() {
-	ProductsMutex.Lock()
-	defer ProductsMutex.Unlock()
+	// CSRFToken is the synchronizer token forms on the secure shops must echo
+	CSRFToken string
 
-	Products["1"] = Product{ID: "1", Name: "Laptop", Price: 999.99}
-	Products["2"] = Product{ID: "2", Name: "Mouse", Price: 29.99}
-	Products["3"] = Product{ID: "3", Name: "Keyboard", Price: 79.99}
-	Products["4"] = Product{ID: "4", Name: "Monitor", Price: 299.99}
-}
+	// TeamID is the capture-the-flag team the visitor plays for, and
+	// NewFlags the challenges they solved since the last page was shown
+	TeamID   string
+	NewFlags []string
 
-func GenerateID() string {
-	bytes := make([]byte, 16)
-	rand.Read(bytes)
-	return hex.EncodeToString(bytes)
+	CreatedAt time.Time
+	LastSeen  time.Time
 }
 
-func GetProduct(id string) (Product, bool) {
-	ProductsMutex.RLock()
-	defer ProductsMutex.RUnlock()
-	product, exists := Products[id]
-	return product, exists
-}
-
-func GetOrder(id string) (Order, bool) {
-	OrdersMutex.RLock()
-	defer OrdersMutex.RUnlock()
-	order, exists := Orders[id]
-	return order, exists
-}
-
-func SetOrder(order Order) {
-	OrdersMutex.Lock()
-	defer OrdersMutex.Unlock()
-	Orders[order.ID] = order
-}
+// InitStores seeds the product catalog and coupons
+func InitStores(store Store) {
+	store.SetProduct(Product{ID: "1", Name: "Laptop", Price: NewMoney(99999, BaseCurrency), Stock: 5})
+	store.SetProduct(Product{ID: "2", Name: "Mouse", Price: NewMoney(2999, BaseCurrency), Stock: 50})
+	store.SetProduct(Product{ID: "3", Name: "Keyboard", Price: NewMoney(7999, BaseCurrency), Stock: 25})
+	store.SetProduct(Product{ID: "4", Name: "Monitor", Price: NewMoney(29999, BaseCurrency), Stock: 10})
 
-func GetCart(sessionID string) Cart {
-	CartsMutex.RLock()
-	defer CartsMutex.RUnlock()
-	cart, exists := Carts[sessionID]
-	if !exists {
-		return Cart{Items: []CartItem{}, Total: 0}
+	for _, coupon := range SeedCoupons() {
+		store.SetCoupon(coupon)
 	}
-	return cart
 }
 
-func SetCart(sessionID string, cart Cart) {
-	CartsMutex.Lock()
-	defer CartsMutex.Unlock()
-	Carts[sessionID] = cart
-}
-
-func ClearCart(sessionID string) {
-	CartsMutex.Lock()
-	defer CartsMutex.Unlock()
-	delete(Carts, sessionID)
-}
-
-func GetSession(sessionID string) (string, bool) {
-	SessionsMutex.RLock()
-	defer SessionsMutex.RUnlock()
-	userID, exists := Sessions[sessionID]
-	return userID, exists
-}
-
-func SetSession(sessionID, userID string) {
-	SessionsMutex.Lock()
-	defer SessionsMutex.Unlock()
-	Sessions[sessionID] = userID
+func GenerateID() string {
+	bytes := make([]byte, 16)
+	rand.Read(bytes)
+	return hex.EncodeToString(bytes)
 }
@@ -0,0 +1,205 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+)
+
+// BaseCurrency is the currency the catalog is priced in
+const BaseCurrency = "USD"
+
+var (
+	ErrInvalidAmount    = errors.New("invalid amount")
+	ErrExcessPrecision  = errors.New("amount has more decimal places than the currency allows")
+	ErrUnknownCurrency  = errors.New("unknown currency")
+	ErrCurrencyMismatch = errors.New("currency mismatch")
+	ErrOverflow         = errors.New("amount overflows")
+)
+
+type currencyInfo struct {
+	Symbol   string
+	Exponent int // number of minor-unit digits
+}
+
+var currencies = map[string]currencyInfo{
+	"USD": {Symbol: "$", Exponent: 2},
+	"EUR": {Symbol: "€", Exponent: 2},
+	"GBP": {Symbol: "£", Exponent: 2},
+	"JPY": {Symbol: "¥", Exponent: 0},
+}
+
+// Money is an exact amount held as integer minor units (cents for USD).
+// The zero value has no currency and adopts the currency of whatever is
+// added to it, so it can be used as the starting point of a sum.
+type Money struct {
+	Amount   int64  `json:"amount"`
+	Currency string `json:"currency"`
+}
+
+func NewMoney(minorUnits int64, currency string) Money {
+	return Money{Amount: minorUnits, Currency: currency}
+}
+
+// ParseMoney parses a plain decimal such as "29.99" or "-5" in the given
+// currency. Exponent notation, NaN, Inf and more fractional digits than the
+// currency has minor units are rejected rather than rounded.
+func ParseMoney(s, currency string) (Money, error) {
+	info, ok := currencies[currency]
+	if !ok {
+		return Money{}, fmt.Errorf("%w: %q", ErrUnknownCurrency, currency)
+	}
+
+	s = strings.TrimSpace(s)
+	negative := strings.HasPrefix(s, "-")
+	if negative {
+		s = s[1:]
+	}
+	whole, frac, hasPoint := strings.Cut(s, ".")
+	if whole == "" || (hasPoint && frac == "") || !isDigits(whole) || !isDigits(frac) {
+		return Money{}, fmt.Errorf("%w: %q", ErrInvalidAmount, s)
+	}
+	if len(frac) > info.Exponent {
+		return Money{}, fmt.Errorf("%w: %q", ErrExcessPrecision, s)
+	}
+	frac += strings.Repeat("0", info.Exponent-len(frac))
+
+	var amount int64
+	for _, c := range whole + frac {
+		digit := int64(c - '0')
+		if amount > (math.MaxInt64-digit)/10 {
+			return Money{}, fmt.Errorf("%w: %q", ErrOverflow, s)
+		}
+		amount = amount*10 + digit
+	}
+	if negative {
+		amount = -amount
+	}
+	return Money{Amount: amount, Currency: currency}, nil
+}
+
+func isDigits(s string) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// Add returns m+other, failing on mismatched currencies or int64 overflow
+func (m Money) Add(other Money) (Money, error) {
+	currency, err := m.commonCurrency(other)
+	if err != nil {
+		return Money{}, err
+	}
+	sum := m.Amount + other.Amount
+	if (other.Amount > 0 && sum < m.Amount) || (other.Amount < 0 && sum > m.Amount) {
+		return Money{}, ErrOverflow
+	}
+	return Money{Amount: sum, Currency: currency}, nil
+}
+
+// Sub returns m-other, failing on mismatched currencies or int64 overflow
+func (m Money) Sub(other Money) (Money, error) {
+	if other.Amount == math.MinInt64 {
+		return Money{}, ErrOverflow
+	}
+	return m.Add(Money{Amount: -other.Amount, Currency: other.Currency})
+}
+
+// Mul returns m multiplied by quantity, failing instead of wrapping around
+func (m Money) Mul(quantity int) (Money, error) {
+	q := int64(quantity)
+	if m.Amount == 0 || q == 0 {
+		return Money{Amount: 0, Currency: m.Currency}, nil
+	}
+	product := m.Amount * q
+	if (q == -1 && m.Amount == math.MinInt64) || product/q != m.Amount {
+		return Money{}, ErrOverflow
+	}
+	return Money{Amount: product, Currency: m.Currency}, nil
+}
+
+func (m Money) commonCurrency(other Money) (string, error) {
+	switch {
+	case m.Currency == other.Currency:
+		return m.Currency, nil
+	case m.Currency == "" && m.Amount == 0:
+		return other.Currency, nil
+	case other.Currency == "" && other.Amount == 0:
+		return m.Currency, nil
+	}
+	return "", fmt.Errorf("%w: %s and %s", ErrCurrencyMismatch, m.Currency, other.Currency)
+}
+
+func (m Money) IsNegative() bool {
+	return m.Amount < 0
+}
+
+// Cmp compares two amounts of the same currency, returning -1, 0 or +1
+func (m Money) Cmp(other Money) int {
+	switch {
+	case m.Amount < other.Amount:
+		return -1
+	case m.Amount > other.Amount:
+		return 1
+	}
+	return 0
+}
+
+// Decimal formats the amount without a currency symbol, e.g. "999.99",
+// suitable for form values that ParseMoney reads back
+func (m Money) Decimal() string {
+	exponent := 2
+	if info, ok := currencies[m.Currency]; ok {
+		exponent = info.Exponent
+	}
+
+	sign := ""
+	// Work in uint64 so MinInt64 can be negated
+	abs := uint64(m.Amount)
+	if m.Amount < 0 {
+		sign = "-"
+		abs = uint64(-(m.Amount + 1)) + 1
+	}
+	if exponent == 0 {
+		return fmt.Sprintf("%s%d", sign, abs)
+	}
+	scale := uint64(math.Pow10(exponent))
+	return fmt.Sprintf("%s%d.%0*d", sign, abs/scale, exponent, abs%scale)
+}
+
+// String formats the amount with its currency symbol, e.g. "$999.99"
+func (m Money) String() string {
+	symbol := ""
+	if info, ok := currencies[m.Currency]; ok {
+		symbol = info.Symbol
+	}
+	decimal := m.Decimal()
+	if strings.HasPrefix(decimal, "-") {
+		return "-" + symbol + decimal[1:]
+	}
+	return symbol + decimal
+}
+
+// LineTotal is the price of a cart line: unit price times quantity
+func (item CartItem) LineTotal() (Money, error) {
+	return item.Price.Mul(item.Quantity)
+}
+
+// SumItems totals a set of cart lines at their recorded prices
+func SumItems(items []CartItem) (Money, error) {
+	var total Money
+	for _, item := range items {
+		line, err := item.LineTotal()
+		if err != nil {
+			return Money{}, err
+		}
+		if total, err = total.Add(line); err != nil {
+			return Money{}, err
+		}
+	}
+	return total, nil
+}
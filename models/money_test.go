@@ -0,0 +1,188 @@
+package models
+
+import (
+	"errors"
+	"math"
+	"math/big"
+	"testing"
+)
+
+func TestParseMoney(t *testing.T) {
+	tests := []struct {
+		in       string
+		currency string
+		want     int64
+		err      error
+	}{
+		{"29.99", "USD", 2999, nil},
+		{"29.9", "USD", 2990, nil},
+		{" 5 ", "USD", 500, nil},
+		{"-5", "USD", -500, nil},
+		{"-0", "USD", 0, nil},
+		{"0.01", "USD", 1, nil},
+		{"1000", "JPY", 1000, nil},
+		{"92233720368547758.07", "USD", math.MaxInt64, nil},
+		{"92233720368547758.08", "USD", 0, ErrOverflow},
+		{"99999999999999999999", "JPY", 0, ErrOverflow},
+		{"1.999", "USD", 0, ErrExcessPrecision},
+		{"1.5", "JPY", 0, ErrExcessPrecision},
+		{"", "USD", 0, ErrInvalidAmount},
+		{"-", "USD", 0, ErrInvalidAmount},
+		{".5", "USD", 0, ErrInvalidAmount},
+		{"5.", "USD", 0, ErrInvalidAmount},
+		{"+5", "USD", 0, ErrInvalidAmount},
+		{"--5", "USD", 0, ErrInvalidAmount},
+		{"1e3", "USD", 0, ErrInvalidAmount},
+		{"1,000", "USD", 0, ErrInvalidAmount},
+		{"0x10", "USD", 0, ErrInvalidAmount},
+		{"NaN", "USD", 0, ErrInvalidAmount},
+		{"Inf", "USD", 0, ErrInvalidAmount},
+		{"1.2.3", "USD", 0, ErrInvalidAmount},
+		{"١٢", "USD", 0, ErrInvalidAmount},
+		{"5", "XYZ", 0, ErrUnknownCurrency},
+	}
+	for _, tt := range tests {
+		got, err := ParseMoney(tt.in, tt.currency)
+		if !errors.Is(err, tt.err) {
+			t.Errorf("ParseMoney(%q, %s) error = %v, want %v", tt.in, tt.currency, err, tt.err)
+			continue
+		}
+		if err == nil && got != NewMoney(tt.want, tt.currency) {
+			t.Errorf("ParseMoney(%q, %s) = %+v, want %d", tt.in, tt.currency, got, tt.want)
+		}
+	}
+}
+
+func TestMoneyArithmetic(t *testing.T) {
+	usd := func(n int64) Money { return NewMoney(n, "USD") }
+	tests := []struct {
+		name string
+		got  func() (Money, error)
+		want Money
+		err  error
+	}{
+		{"add", func() (Money, error) { return usd(999).Add(usd(1)) }, usd(1000), nil},
+		{"zero value adopts currency", func() (Money, error) { return Money{}.Add(usd(5)) }, usd(5), nil},
+		{"add mismatch", func() (Money, error) { return usd(1).Add(NewMoney(1, "EUR")) }, Money{}, ErrCurrencyMismatch},
+		{"add overflow", func() (Money, error) { return usd(math.MaxInt64).Add(usd(1)) }, Money{}, ErrOverflow},
+		{"add underflow", func() (Money, error) { return usd(math.MinInt64).Add(usd(-1)) }, Money{}, ErrOverflow},
+		{"sub", func() (Money, error) { return usd(500).Sub(usd(501)) }, usd(-1), nil},
+		{"sub MinInt64", func() (Money, error) { return usd(0).Sub(usd(math.MinInt64)) }, Money{}, ErrOverflow},
+		{"mul", func() (Money, error) { return usd(2999).Mul(3) }, usd(8997), nil},
+		{"mul zero", func() (Money, error) { return usd(math.MaxInt64).Mul(0) }, usd(0), nil},
+		{"mul negative", func() (Money, error) { return usd(2999).Mul(-2) }, usd(-5998), nil},
+		{"mul overflow", func() (Money, error) { return usd(99999).Mul(math.MaxInt64 / 1000) }, Money{}, ErrOverflow},
+		{"mul MinInt64 by -1", func() (Money, error) { return usd(math.MinInt64).Mul(-1) }, Money{}, ErrOverflow},
+		{"sum", func() (Money, error) {
+			return SumItems([]CartItem{{Quantity: 2, Price: usd(2999)}, {Quantity: 1, Price: usd(1)}})
+		}, usd(5999), nil},
+		{"sum overflow", func() (Money, error) {
+			return SumItems([]CartItem{{Quantity: 1, Price: usd(math.MaxInt64)}, {Quantity: 1, Price: usd(1)}})
+		}, Money{}, ErrOverflow},
+		{"sum mixed currencies", func() (Money, error) {
+			return SumItems([]CartItem{{Quantity: 1, Price: usd(1)}, {Quantity: 1, Price: NewMoney(1, "EUR")}})
+		}, Money{}, ErrCurrencyMismatch},
+	}
+	for _, tt := range tests {
+		got, err := tt.got()
+		if !errors.Is(err, tt.err) || (err == nil && got != tt.want) {
+			t.Errorf("%s = %+v, %v; want %+v, %v", tt.name, got, err, tt.want, tt.err)
+		}
+	}
+}
+
+func TestMoneyString(t *testing.T) {
+	tests := []struct {
+		m    Money
+		want string
+	}{
+		{NewMoney(99999, "USD"), "$999.99"},
+		{NewMoney(5, "USD"), "$0.05"},
+		{NewMoney(-5, "USD"), "-$0.05"},
+		{NewMoney(1512, "JPY"), "¥1512"},
+		{NewMoney(math.MinInt64, "USD"), "-$92233720368547758.08"},
+	}
+	for _, tt := range tests {
+		if got := tt.m.String(); got != tt.want {
+			t.Errorf("%+v.String() = %q, want %q", tt.m, got, tt.want)
+		}
+		// Decimal is what forms post back, so it has to parse to the same amount
+		if tt.m.Amount != math.MinInt64 {
+			if back, err := ParseMoney(tt.m.Decimal(), tt.m.Currency); err != nil || back != tt.m {
+				t.Errorf("ParseMoney(%q) = %+v, %v; want %+v", tt.m.Decimal(), back, err, tt.m)
+			}
+		}
+	}
+}
+
+func TestConvertAtRounding(t *testing.T) {
+	rate := func(s string) *big.Rat {
+		r, err := ParseRate(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return r
+	}
+	tests := []struct {
+		name     string
+		m        Money
+		currency string
+		rate     string
+		mode     RoundingMode
+		want     int64
+		err      error
+	}{
+		{"exact", NewMoney(10000, "USD"), "EUR", "0.92", RoundHalfUp, 9200, nil},
+		{"half up", NewMoney(5, "USD"), "EUR", "0.5", RoundHalfUp, 3, nil},
+		{"half down", NewMoney(5, "USD"), "EUR", "0.5", RoundDown, 2, nil},
+		{"negative half up rounds away from zero", NewMoney(-5, "USD"), "EUR", "0.5", RoundHalfUp, -3, nil},
+		{"negative round down truncates toward zero", NewMoney(-5, "USD"), "EUR", "0.5", RoundDown, -2, nil},
+		{"just below half", NewMoney(49, "USD"), "EUR", "0.01", RoundHalfUp, 0, nil},
+		{"cent to yen", NewMoney(1, "USD"), "JPY", "151.20", RoundHalfUp, 2, nil},
+		{"cent to yen round down", NewMoney(1, "USD"), "JPY", "151.20", RoundDown, 1, nil},
+		{"dollars to yen", NewMoney(99999, "USD"), "JPY", "151.20", RoundHalfUp, 151198, nil},
+		{"yen to cents", NewMoney(1, "JPY"), "USD", "0.0066", RoundHalfUp, 1, nil},
+		{"yen to cents round down", NewMoney(1, "JPY"), "USD", "0.0066", RoundDown, 0, nil},
+		{"overflow", NewMoney(math.MaxInt64, "USD"), "EUR", "2", RoundHalfUp, 0, ErrOverflow},
+		{"unknown target", NewMoney(1, "USD"), "XYZ", "1", RoundHalfUp, 0, ErrUnknownCurrency},
+		{"unknown source", NewMoney(1, "XYZ"), "USD", "1", RoundHalfUp, 0, ErrUnknownCurrency},
+	}
+	for _, tt := range tests {
+		got, err := ConvertAt(tt.m, tt.currency, rate(tt.rate), tt.mode)
+		if !errors.Is(err, tt.err) || (err == nil && got != NewMoney(tt.want, tt.currency)) {
+			t.Errorf("%s: ConvertAt = %+v, %v; want %d, %v", tt.name, got, err, tt.want, tt.err)
+		}
+	}
+}
+
+func TestRateTable(t *testing.T) {
+	table, err := NewRateTable("USD", map[string]string{"EUR": "0.92", "JPY": "151.20"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, rate, err := table.Convert(NewMoney(2999, "USD"), "EUR")
+	if err != nil || got != NewMoney(2759, "EUR") || FormatRate(rate) != "0.92" {
+		t.Errorf("Convert = %+v, %v, %v; want €27.59 at 0.92", got, rate, err)
+	}
+	if got, _, err := table.Convert(NewMoney(2999, "USD"), "USD"); err != nil || got != NewMoney(2999, "USD") {
+		t.Errorf("converting to the base = %+v, %v", got, err)
+	}
+	if _, _, err := table.Convert(NewMoney(1, "EUR"), "JPY"); !errors.Is(err, ErrCurrencyMismatch) {
+		t.Errorf("converting from a non-base currency: %v, want ErrCurrencyMismatch", err)
+	}
+	if _, _, err := table.Convert(NewMoney(1, "USD"), "GBP"); !errors.Is(err, ErrUnknownCurrency) {
+		t.Errorf("converting to a currency without a rate: %v, want ErrUnknownCurrency", err)
+	}
+	if _, err := NewRateTable("USD", map[string]string{"XYZ": "1"}); !errors.Is(err, ErrUnknownCurrency) {
+		t.Errorf("NewRateTable with an unknown currency: %v", err)
+	}
+
+	for _, bad := range []string{"", "0", "0.00", "-1", "+1", "1e2", ".5", "5.", "abc", "NaN"} {
+		if _, err := ParseRate(bad); err == nil {
+			t.Errorf("ParseRate(%q) succeeded", bad)
+		}
+	}
+	if rate, err := ParseRate("151.20"); err != nil || FormatRate(rate) != "151.2" {
+		t.Errorf("ParseRate(151.20) = %v, %v", rate, err)
+	}
+}
@@ -0,0 +1,34 @@
+package models
+
+import "time"
+
+// ClaimNonce records a one-time value such as a webhook event ID. It returns
+// false if the nonce was already claimed and has not yet expired. Expired
+// nonces are pruned on each call; callers must reject anything older than
+// the expiry some other way (e.g. a signed timestamp).
+func (s *MemoryStore) ClaimNonce(nonce string, expiresAt time.Time) bool {
+	s.noncesMutex.Lock()
+	defer s.noncesMutex.Unlock()
+
+	now := time.Now()
+	for seen, expiry := range s.nonces {
+		if now.After(expiry) {
+			delete(s.nonces, seen)
+		}
+	}
+	if _, seen := s.nonces[nonce]; seen {
+		return false
+	}
+	s.nonces[nonce] = expiresAt
+	return true
+}
+
+func (s *MemoryStore) allNonces() map[string]time.Time {
+	s.noncesMutex.Lock()
+	defer s.noncesMutex.Unlock()
+	nonces := make(map[string]time.Time, len(s.nonces))
+	for nonce, expiresAt := range s.nonces {
+		nonces[nonce] = expiresAt
+	}
+	return nonces
+}
@@ -0,0 +1,113 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+type OrderStatus string
+
+const (
+	StatusPending         OrderStatus = "pending"          // placed, no payment attempted yet
+	StatusAwaitingPayment OrderStatus = "awaiting_payment" // payment submitted, not yet confirmed
+	StatusPaid            OrderStatus = "paid"
+	StatusFulfilled       OrderStatus = "fulfilled"
+	StatusCancelled       OrderStatus = "cancelled"
+	StatusRefunded        OrderStatus = "refunded"
+	StatusExpired         OrderStatus = "expired"
+)
+
+var (
+	ErrInvalidTransition = errors.New("invalid order status transition")
+	ErrOrderNotFound     = errors.New("order not found")
+)
+
+// orderTransitions lists, for each status, the statuses an order may move to
+// next. Statuses with no entry are terminal.
+var orderTransitions = map[OrderStatus][]OrderStatus{
+	StatusPending:         {StatusAwaitingPayment, StatusCancelled, StatusExpired},
+	StatusAwaitingPayment: {StatusPaid, StatusCancelled, StatusExpired},
+	StatusPaid:            {StatusFulfilled, StatusRefunded},
+	StatusFulfilled:       {StatusRefunded},
+	// A payment that only lands after the order was given up is refunded
+	StatusCancelled: {StatusRefunded},
+	StatusExpired:   {StatusRefunded},
+}
+
+// StatusChange is one entry in an order's history
+type StatusChange struct {
+	From  OrderStatus
+	To    OrderStatus
+	At    time.Time
+	Actor string // who caused the change: customer, payment-gateway, system...
+}
+
+func (s OrderStatus) CanTransitionTo(to OrderStatus) bool {
+	for _, next := range orderTransitions[s] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+// IsOpen reports whether the order is still waiting to be paid, i.e. it
+// still holds its stock reservation
+func (s OrderStatus) IsOpen() bool {
+	return s == StatusPending || s == StatusAwaitingPayment
+}
+
+// NewOrder builds a pending order, placed in shop, whose history starts with
+// its creation
+func NewOrder(shop, userID string, items []CartItem, total Money, actor string) Order {
+	now := time.Now()
+	return Order{
+		ID:        GenerateID(),
+		Shop:      shop,
+		UserID:    userID,
+		Items:     items,
+		Total:     total,
+		Status:    StatusPending,
+		Timestamp: now,
+		History:   []StatusChange{{To: StatusPending, At: now, Actor: actor}},
+	}
+}
+
+// Transition validates and applies a status change in place. Use it inside
+// Store.UpdateOrder so the check and the write are atomic.
+func (o *Order) Transition(to OrderStatus, actor string) error {
+	if !o.Status.CanTransitionTo(to) {
+		return fmt.Errorf("%w: %s -> %s", ErrInvalidTransition, o.Status, to)
+	}
+	// Copy the history so earlier copies of the order stay unchanged
+	o.History = append(append([]StatusChange(nil), o.History...), StatusChange{From: o.Status, To: to, At: time.Now(), Actor: actor})
+	o.Status = to
+	return nil
+}
+
+// UpdateOrder applies update to an order under the orders lock and stores
+// the result unless update returns an error
+func (s *MemoryStore) UpdateOrder(id string, update func(*Order) error) (Order, error) {
+	s.ordersMutex.Lock()
+	defer s.ordersMutex.Unlock()
+
+	order, exists := s.orders[id]
+	if !exists {
+		return Order{}, fmt.Errorf("order %s: %w", id, ErrOrderNotFound)
+	}
+	if err := update(&order); err != nil {
+		return s.orders[id], err
+	}
+	s.orders[id] = order
+	return order, nil
+}
+
+// TransitionOrder moves an order to a new status if the transition table
+// allows it. Two concurrent transitions out of the same status cannot both
+// succeed.
+func (s *MemoryStore) TransitionOrder(id string, to OrderStatus, actor string) (Order, error) {
+	return s.UpdateOrder(id, func(o *Order) error {
+		return o.Transition(to, actor)
+	})
+}
@@ -0,0 +1,155 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+	"sort"
+	"strings"
+)
+
+// RoundingMode selects how a converted amount is brought back to whole
+// minor units
+type RoundingMode int
+
+const (
+	RoundHalfUp RoundingMode = iota // half away from zero
+	RoundDown                       // truncate toward zero
+)
+
+// RateTable holds exact exchange rates from Base into other currencies,
+// expressed as units of the target currency per one unit of Base
+type RateTable struct {
+	Base  string
+	rates map[string]*big.Rat
+}
+
+type rateFile struct {
+	Base  string            `json:"base"`
+	Rates map[string]string `json:"rates"`
+}
+
+// NewRateTable builds a table from decimal strings such as "0.92". The base
+// currency is always present with a rate of 1.
+func NewRateTable(base string, rates map[string]string) (*RateTable, error) {
+	if _, ok := currencies[base]; !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownCurrency, base)
+	}
+	table := &RateTable{Base: base, rates: map[string]*big.Rat{base: big.NewRat(1, 1)}}
+	for currency, value := range rates {
+		if _, ok := currencies[currency]; !ok {
+			return nil, fmt.Errorf("%w: %q", ErrUnknownCurrency, currency)
+		}
+		rate, err := ParseRate(value)
+		if err != nil {
+			return nil, fmt.Errorf("rate for %s: %w", currency, err)
+		}
+		table.rates[currency] = rate
+	}
+	return table, nil
+}
+
+// LoadRates reads a JSON rate file of the form
+// {"base": "USD", "rates": {"EUR": "0.92"}}
+func LoadRates(path string) (*RateTable, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file rateFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	return NewRateTable(file.Base, file.Rates)
+}
+
+// ParseRate parses a positive plain decimal rate
+func ParseRate(s string) (*big.Rat, error) {
+	whole, frac, hasPoint := strings.Cut(strings.TrimSpace(s), ".")
+	if whole == "" || (hasPoint && frac == "") || !isDigits(whole) || !isDigits(frac) {
+		return nil, fmt.Errorf("invalid rate %q", s)
+	}
+	rate, ok := new(big.Rat).SetString(whole + "." + frac + "0")
+	if !ok || rate.Sign() <= 0 {
+		return nil, fmt.Errorf("invalid rate %q", s)
+	}
+	return rate, nil
+}
+
+// FormatRate renders a rate as a decimal string with trailing zeros removed
+func FormatRate(rate *big.Rat) string {
+	s := rate.FloatString(8)
+	s = strings.TrimRight(s, "0")
+	return strings.TrimSuffix(s, ".")
+}
+
+// Currencies lists the currencies the table can convert into, sorted
+func (t *RateTable) Currencies() []string {
+	list := make([]string, 0, len(t.rates))
+	for currency := range t.rates {
+		list = append(list, currency)
+	}
+	sort.Strings(list)
+	return list
+}
+
+func (t *RateTable) Rate(currency string) (*big.Rat, bool) {
+	rate, ok := t.rates[currency]
+	return rate, ok
+}
+
+// Convert turns a Base amount into currency at the table rate, rounding half
+// away from zero, and returns the rate it used
+func (t *RateTable) Convert(m Money, currency string) (Money, *big.Rat, error) {
+	if m.Currency != t.Base {
+		return Money{}, nil, fmt.Errorf("%w: %s and %s", ErrCurrencyMismatch, m.Currency, t.Base)
+	}
+	rate, ok := t.rates[currency]
+	if !ok {
+		return Money{}, nil, fmt.Errorf("%w: %q", ErrUnknownCurrency, currency)
+	}
+	converted, err := ConvertAt(m, currency, rate, RoundHalfUp)
+	return converted, rate, err
+}
+
+// ConvertAt converts m into currency at an explicit rate, accounting for the
+// two currencies having different numbers of minor units
+func ConvertAt(m Money, currency string, rate *big.Rat, mode RoundingMode) (Money, error) {
+	from, ok := currencies[m.Currency]
+	if !ok {
+		return Money{}, fmt.Errorf("%w: %q", ErrUnknownCurrency, m.Currency)
+	}
+	to, ok := currencies[currency]
+	if !ok {
+		return Money{}, fmt.Errorf("%w: %q", ErrUnknownCurrency, currency)
+	}
+
+	value := new(big.Rat).SetInt64(m.Amount)
+	value.Mul(value, rate)
+	shift := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(abs(to.Exponent-from.Exponent))), nil)
+	if to.Exponent > from.Exponent {
+		value.Mul(value, new(big.Rat).SetInt(shift))
+	} else {
+		value.Quo(value, new(big.Rat).SetInt(shift))
+	}
+
+	quo, rem := new(big.Int).QuoRem(value.Num(), value.Denom(), new(big.Int))
+	if mode == RoundHalfUp {
+		twice := new(big.Int).Mul(new(big.Int).Abs(rem), big.NewInt(2))
+		if twice.Cmp(value.Denom()) >= 0 {
+			quo.Add(quo, big.NewInt(int64(value.Sign())))
+		}
+	}
+	if !quo.IsInt64() {
+		return Money{}, ErrOverflow
+	}
+	return Money{Amount: quo.Int64(), Currency: currency}, nil
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
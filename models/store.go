@@ -0,0 +1,252 @@
+package models
+
+import (
+	"sync"
+	"time"
+)
+
+// Store is the persistence boundary used by every handler. Implementations
+// must be safe for concurrent use.
+type Store interface {
+	GetProduct(id string) (Product, bool)
+	ListProducts() map[string]Product
+	SetProduct(product Product)
+	// CreateProduct and UpdateProduct are the admin's catalog edits; each
+	// one is recorded in the audit log ListProductChanges returns
+	CreateProduct(product Product, actor string) (Product, error)
+	UpdateProduct(id, actor string, update func(*Product) error) (Product, error)
+	ListProductChanges() []ProductChange
+	ReserveStock(items []CartItem) error
+	// ReleaseStock and CommitStock fail with ErrNotReserved, changing
+	// nothing, if the items aren't all reserved
+	ReleaseStock(items []CartItem) error
+	CommitStock(items []CartItem) error
+	// SetStock overwrites a product's stock count and nothing else
+	SetStock(id string, stock int) error
+
+	GetOrder(id string) (Order, bool)
+	ListOrders() []Order
+	SetOrder(order Order)
+	UpdateOrder(id string, update func(*Order) error) (Order, error)
+	TransitionOrder(id string, to OrderStatus, actor string) (Order, error)
+
+	// Carts are kept per session and scenario, so each shop has a cart of
+	// its own that no other shop can read or check out
+	GetCart(sessionID, scenario string) Cart
+	SetCart(sessionID, scenario string, cart Cart)
+	ClearCart(sessionID, scenario string)
+	ListCarts(sessionID string) map[string]Cart // scenario -> cart
+	DeleteCarts(sessionID string)
+
+	GetSession(sessionID string) (Session, bool)
+	SetSession(session Session)
+	DeleteSession(sessionID string)
+	ListSessions() map[string]Session
+
+	CreateUser(user User) error
+	GetUser(id string) (User, bool)
+	GetUserByUsername(username string) (User, bool)
+
+	GetCoupon(code string) (Coupon, bool)
+	ListCoupons() []Coupon
+	SetCoupon(coupon Coupon)
+	CouponUsage(shop, code, userID string) (total, byUser int)
+	RecordRedemptions(redemptions []Redemption)
+	RedeemCoupons(shop string, codes []string, userID, orderID string, now time.Time) error
+
+	ClaimNonce(nonce string, expiresAt time.Time) bool
+
+	// Teams and solves back capture-the-flag mode
+	CreateTeam(team Team) error
+	GetTeam(id string) (Team, bool)
+	GetTeamByName(name string) (Team, bool)
+	ListTeams() []Team
+	RecordSolve(solve Solve) bool
+	ListSolves() []Solve
+}
+
+// MemoryStore keeps everything in process memory, guarded by one mutex per map
+type MemoryStore struct {
+	products       map[string]Product
+	productChanges []ProductChange
+	orders         map[string]Order
+	carts          map[string]map[string]Cart // session_id -> scenario -> cart
+	sessions       map[string]Session
+	nonces         map[string]time.Time // nonce -> expiry
+	users          map[string]User
+	usernames      map[string]string // username -> user ID
+	coupons        map[string]Coupon
+	redemptions    []Redemption
+	teams          map[string]Team
+	teamNames      map[string]string // lower-cased name -> team ID
+	solves         []Solve
+	productsMutex  sync.RWMutex // guards products and productChanges
+	ordersMutex    sync.RWMutex
+	cartsMutex     sync.RWMutex
+	sessionsMutex  sync.RWMutex
+	noncesMutex    sync.Mutex
+	usersMutex     sync.RWMutex
+	couponsMutex   sync.RWMutex // guards coupons and redemptions
+	ctfMutex       sync.RWMutex // guards teams and solves
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		products:  make(map[string]Product),
+		orders:    make(map[string]Order),
+		carts:     make(map[string]map[string]Cart),
+		sessions:  make(map[string]Session),
+		nonces:    make(map[string]time.Time),
+		users:     make(map[string]User),
+		usernames: make(map[string]string),
+		coupons:   make(map[string]Coupon),
+		teams:     make(map[string]Team),
+		teamNames: make(map[string]string),
+	}
+}
+
+func (s *MemoryStore) GetProduct(id string) (Product, bool) {
+	s.productsMutex.RLock()
+	defer s.productsMutex.RUnlock()
+	product, exists := s.products[id]
+	return product, exists
+}
+
+// ListProducts returns a copy so callers can range over it without holding the lock
+func (s *MemoryStore) ListProducts() map[string]Product {
+	s.productsMutex.RLock()
+	defer s.productsMutex.RUnlock()
+	products := make(map[string]Product, len(s.products))
+	for id, product := range s.products {
+		products[id] = product
+	}
+	return products
+}
+
+func (s *MemoryStore) SetProduct(product Product) {
+	s.productsMutex.Lock()
+	defer s.productsMutex.Unlock()
+	s.products[product.ID] = product
+}
+
+func (s *MemoryStore) GetOrder(id string) (Order, bool) {
+	s.ordersMutex.RLock()
+	defer s.ordersMutex.RUnlock()
+	order, exists := s.orders[id]
+	return order, exists
+}
+
+func (s *MemoryStore) ListOrders() []Order {
+	s.ordersMutex.RLock()
+	defer s.ordersMutex.RUnlock()
+	orders := make([]Order, 0, len(s.orders))
+	for _, order := range s.orders {
+		orders = append(orders, order)
+	}
+	return orders
+}
+
+func (s *MemoryStore) SetOrder(order Order) {
+	s.ordersMutex.Lock()
+	defer s.ordersMutex.Unlock()
+	s.orders[order.ID] = order
+}
+
+func (s *MemoryStore) GetCart(sessionID, scenario string) Cart {
+	s.cartsMutex.RLock()
+	defer s.cartsMutex.RUnlock()
+	cart, exists := s.carts[sessionID][scenario]
+	if !exists {
+		return Cart{Items: []CartItem{}, Total: NewMoney(0, BaseCurrency)}
+	}
+	return cart
+}
+
+func (s *MemoryStore) SetCart(sessionID, scenario string, cart Cart) {
+	s.cartsMutex.Lock()
+	defer s.cartsMutex.Unlock()
+	if s.carts[sessionID] == nil {
+		s.carts[sessionID] = make(map[string]Cart)
+	}
+	s.carts[sessionID][scenario] = cart
+}
+
+func (s *MemoryStore) ClearCart(sessionID, scenario string) {
+	s.cartsMutex.Lock()
+	defer s.cartsMutex.Unlock()
+	delete(s.carts[sessionID], scenario)
+	if len(s.carts[sessionID]) == 0 {
+		delete(s.carts, sessionID)
+	}
+}
+
+// ListCarts returns a copy of every cart the session has, keyed by scenario
+func (s *MemoryStore) ListCarts(sessionID string) map[string]Cart {
+	s.cartsMutex.RLock()
+	defer s.cartsMutex.RUnlock()
+	carts := make(map[string]Cart, len(s.carts[sessionID]))
+	for scenario, cart := range s.carts[sessionID] {
+		carts[scenario] = cart
+	}
+	return carts
+}
+
+// DeleteCarts drops the session's carts in every scenario
+func (s *MemoryStore) DeleteCarts(sessionID string) {
+	s.cartsMutex.Lock()
+	defer s.cartsMutex.Unlock()
+	delete(s.carts, sessionID)
+}
+
+func (s *MemoryStore) GetSession(sessionID string) (Session, bool) {
+	s.sessionsMutex.RLock()
+	defer s.sessionsMutex.RUnlock()
+	session, exists := s.sessions[sessionID]
+	return session, exists
+}
+
+func (s *MemoryStore) SetSession(session Session) {
+	s.sessionsMutex.Lock()
+	defer s.sessionsMutex.Unlock()
+	s.sessions[session.ID] = session
+}
+
+func (s *MemoryStore) DeleteSession(sessionID string) {
+	s.sessionsMutex.Lock()
+	defer s.sessionsMutex.Unlock()
+	delete(s.sessions, sessionID)
+}
+
+func (s *MemoryStore) allOrders() map[string]Order {
+	s.ordersMutex.RLock()
+	defer s.ordersMutex.RUnlock()
+	orders := make(map[string]Order, len(s.orders))
+	for id, order := range s.orders {
+		orders[id] = order
+	}
+	return orders
+}
+
+func (s *MemoryStore) allCarts() map[string]map[string]Cart {
+	s.cartsMutex.RLock()
+	defer s.cartsMutex.RUnlock()
+	carts := make(map[string]map[string]Cart, len(s.carts))
+	for sessionID, scenarios := range s.carts {
+		carts[sessionID] = make(map[string]Cart, len(scenarios))
+		for scenario, cart := range scenarios {
+			carts[sessionID][scenario] = cart
+		}
+	}
+	return carts
+}
+
+// ListSessions returns a copy of every session, keyed by ID
+func (s *MemoryStore) ListSessions() map[string]Session {
+	s.sessionsMutex.RLock()
+	defer s.sessionsMutex.RUnlock()
+	sessions := make(map[string]Session, len(s.sessions))
+	for sessionID, session := range s.sessions {
+		sessions[sessionID] = session
+	}
+	return sessions
+}
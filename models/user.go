@@ -0,0 +1,155 @@
+package models
+
+import (
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Password hashes are stored as "pbkdf2-sha256$<iterations>$<salt>$<key>"
+// with base64 (raw, standard alphabet) salt and key, so the cost can be
+// raised later without invalidating existing hashes
+const (
+	passwordScheme     = "pbkdf2-sha256"
+	passwordIterations = 600_000 // OWASP 2023 recommendation for PBKDF2-HMAC-SHA256
+	passwordSaltSize   = 16
+	passwordKeySize    = 32
+
+	MinPasswordLength = 8
+)
+
+var (
+	ErrUsernameTaken   = errors.New("username already taken")
+	ErrInvalidUsername = errors.New("username must be 3-32 letters, digits, '.', '-' or '_'")
+	ErrWeakPassword    = fmt.Errorf("password must be at least %d characters", MinPasswordLength)
+)
+
+type User struct {
+	ID           string
+	Username     string
+	PasswordHash string
+	CreatedAt    time.Time
+}
+
+// NormalizeUsername lower-cases and trims a username so "Alice " and
+// "alice" are the same account
+func NormalizeUsername(username string) string {
+	return strings.ToLower(strings.TrimSpace(username))
+}
+
+func validUsername(username string) bool {
+	if len(username) < 3 || len(username) > 32 {
+		return false
+	}
+	for _, c := range username {
+		if (c < 'a' || c > 'z') && (c < '0' || c > '9') && c != '.' && c != '-' && c != '_' {
+			return false
+		}
+	}
+	return true
+}
+
+// NewUser validates the credentials and returns a user with a hashed
+// password; it is not stored yet
+func NewUser(username, password string) (User, error) {
+	username = NormalizeUsername(username)
+	if !validUsername(username) {
+		return User{}, ErrInvalidUsername
+	}
+	if len(password) < MinPasswordLength {
+		return User{}, ErrWeakPassword
+	}
+	hash, err := HashPassword(password)
+	if err != nil {
+		return User{}, err
+	}
+	return User{ID: GenerateID(), Username: username, PasswordHash: hash, CreatedAt: time.Now()}, nil
+}
+
+// HashPassword derives a key from password with a fresh random salt
+func HashPassword(password string) (string, error) {
+	salt := make([]byte, passwordSaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key, err := pbkdf2.Key(sha256.New, password, salt, passwordIterations, passwordKeySize)
+	if err != nil {
+		return "", err
+	}
+	return strings.Join([]string{
+		passwordScheme,
+		strconv.Itoa(passwordIterations),
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	}, "$"), nil
+}
+
+// CheckPassword reports whether password matches an encoded hash. The
+// comparison is constant-time; malformed hashes never match.
+func CheckPassword(encoded, password string) bool {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 4 || parts[0] != passwordScheme {
+		return false
+	}
+	iterations, err := strconv.Atoi(parts[1])
+	if err != nil || iterations < 1 {
+		return false
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return false
+	}
+	want, err := base64.RawStdEncoding.DecodeString(parts[3])
+	if err != nil || len(want) == 0 {
+		return false
+	}
+	got, err := pbkdf2.Key(sha256.New, password, salt, iterations, len(want))
+	if err != nil {
+		return false
+	}
+	return subtle.ConstantTimeCompare(got, want) == 1
+}
+
+// CreateUser stores a new user unless the username is already taken
+func (s *MemoryStore) CreateUser(user User) error {
+	s.usersMutex.Lock()
+	defer s.usersMutex.Unlock()
+
+	if _, taken := s.usernames[user.Username]; taken {
+		return ErrUsernameTaken
+	}
+	s.users[user.ID] = user
+	s.usernames[user.Username] = user.ID
+	return nil
+}
+
+func (s *MemoryStore) GetUser(id string) (User, bool) {
+	s.usersMutex.RLock()
+	defer s.usersMutex.RUnlock()
+	user, exists := s.users[id]
+	return user, exists
+}
+
+func (s *MemoryStore) GetUserByUsername(username string) (User, bool) {
+	s.usersMutex.RLock()
+	defer s.usersMutex.RUnlock()
+	user, exists := s.users[s.usernames[NormalizeUsername(username)]]
+	return user, exists
+}
+
+func (s *MemoryStore) allUsers() map[string]User {
+	s.usersMutex.RLock()
+	defer s.usersMutex.RUnlock()
+	users := make(map[string]User, len(s.users))
+	for id, user := range s.users {
+		users[id] = user
+	}
+	return users
+}
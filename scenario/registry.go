@@ -0,0 +1,101 @@
+package scenario
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+)
+
+// Registry holds the registered scenarios in registration order
+type Registry struct {
+	mu        sync.RWMutex
+	scenarios []Scenario
+	byName    map[string]Scenario
+}
+
+func NewRegistry() *Registry {
+	return &Registry{byName: make(map[string]Scenario)}
+}
+
+// Register adds a scenario, refusing a second one with the same name
+func (r *Registry) Register(s Scenario) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, exists := r.byName[s.Name()]; exists {
+		return fmt.Errorf("scenario %q registered twice", s.Name())
+	}
+	r.byName[s.Name()] = s
+	r.scenarios = append(r.scenarios, s)
+	return nil
+}
+
+// MustRegister is Register for scenarios wired up at startup
+func (r *Registry) MustRegister(s Scenario) {
+	if err := r.Register(s); err != nil {
+		panic(err)
+	}
+}
+
+// Get looks a scenario up by name
+func (r *Registry) Get(name string) (Scenario, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	s, ok := r.byName[name]
+	return s, ok
+}
+
+// Scenarios returns every registered scenario in registration order
+func (r *Registry) Scenarios() []Scenario {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return append([]Scenario(nil), r.scenarios...)
+}
+
+// Category is a home page section
+type Category struct {
+	Name      string
+	Scenarios []Scenario
+}
+
+// Categories groups the scenarios by category, both in the order they were
+// first registered
+func (r *Registry) Categories() []Category {
+	var categories []Category
+	index := map[string]int{}
+	for _, s := range r.Scenarios() {
+		i, ok := index[s.Category()]
+		if !ok {
+			i = len(categories)
+			index[s.Category()] = i
+			categories = append(categories, Category{Name: s.Category()})
+		}
+		categories[i].Scenarios = append(categories[i].Scenarios, s)
+	}
+	return categories
+}
+
+// Patterns lists every route pattern the registered scenarios serve
+func (r *Registry) Patterns() []string {
+	var patterns []string
+	for _, s := range r.Scenarios() {
+		for _, v := range []Variant{Vulnerable, Secure} {
+			set := Set(s, v)
+			for _, route := range set.Routes {
+				patterns = append(patterns, set.Prefix+route.Path)
+			}
+		}
+	}
+	return patterns
+}
+
+// Mount registers every scenario's routes on mux
+func (r *Registry) Mount(mux *http.ServeMux) {
+	for _, s := range r.Scenarios() {
+		for _, v := range []Variant{Vulnerable, Secure} {
+			set := Set(s, v)
+			for _, route := range set.Routes {
+				mux.HandleFunc(set.Prefix+route.Path, route.Handler)
+			}
+		}
+	}
+}
@@ -0,0 +1,98 @@
+// Package scenario describes the vulnerable/secure pairs the platform serves.
+// Each pair implements Scenario and is registered with a Registry, which
+// mounts its routes and lists it on the home page, so adding a pair needs no
+// edits to main.go or the home page.
+package scenario
+
+import "net/http"
+
+// Difficulty is how hard the vulnerable variant is to exploit
+type Difficulty int
+
+const (
+	Beginner Difficulty = iota + 1
+	Intermediate
+	Advanced
+)
+
+func (d Difficulty) String() string {
+	switch d {
+	case Beginner:
+		return "beginner"
+	case Intermediate:
+		return "intermediate"
+	case Advanced:
+		return "advanced"
+	}
+	return "unrated"
+}
+
+// Variant names one side of a pair
+type Variant string
+
+const (
+	Vulnerable Variant = "vulnerable"
+	Secure     Variant = "secure"
+)
+
+// Route is one handler of a variant. Path is appended to the set's Prefix
+// and may use net/http pattern wildcards; "" mounts the prefix itself.
+type Route struct {
+	Path    string
+	Handler http.HandlerFunc
+}
+
+// HandlerSet is everything one variant of a scenario serves
+type HandlerSet struct {
+	// Summary is the one-line description shown on the home page
+	Summary string
+	// Landing is the page the home page links to
+	Landing string
+	// Prefix is prepended to every route's Path, e.g. "/vulnerable-price"
+	Prefix string
+	Routes []Route
+}
+
+// Scenario is a vulnerable/secure pair demonstrating one class of flaw
+type Scenario interface {
+	// Name identifies the scenario and titles it on the home page; it must
+	// be unique within a registry
+	Name() string
+	// Category groups related scenarios on the home page
+	Category() string
+	Description() string
+	Difficulty() Difficulty
+	// Hints nudge a student towards the exploit, mildest first
+	Hints() []string
+
+	Vulnerable() HandlerSet
+	Secure() HandlerSet
+}
+
+// Definition is a Scenario spelled out as data, for pairs that need no
+// behaviour of their own
+type Definition struct {
+	Title         string
+	Group         string
+	Summary       string
+	Level         Difficulty
+	Clues         []string
+	VulnerableSet HandlerSet
+	SecureSet     HandlerSet
+}
+
+func (d Definition) Name() string           { return d.Title }
+func (d Definition) Category() string       { return d.Group }
+func (d Definition) Description() string    { return d.Summary }
+func (d Definition) Difficulty() Difficulty { return d.Level }
+func (d Definition) Hints() []string        { return d.Clues }
+func (d Definition) Vulnerable() HandlerSet { return d.VulnerableSet }
+func (d Definition) Secure() HandlerSet     { return d.SecureSet }
+
+// Set returns the handler set of the given variant
+func Set(s Scenario, v Variant) HandlerSet {
+	if v == Secure {
+		return s.Secure()
+	}
+	return s.Vulnerable()
+}